@@ -0,0 +1,139 @@
+package app
+
+import (
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/auth"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+)
+
+// GetRole returns the acl.Role of the user authenticated on a websocket.
+// Unauthenticated users and users without an entry in the ACL have the role
+// acl.None.
+func (rl *Relay) GetRole(ws *relayws.WebSocket) (role acl.Role) {
+	pub := ws.AuthPubKey()
+	if pub == "" {
+		return acl.None
+	}
+	return rl.ACL.GetRole(pub)
+}
+
+// ACLActive returns true if the ACL has any entries, which is normally the
+// case when owners are configured. An empty ACL means the relay is open and no
+// role checks are performed.
+func (rl *Relay) ACLActive() bool { return rl.ACL.Len() > 0 }
+
+// CanRead checks whether the user on a websocket may query events from the
+// relay, returning a machine readable reason if not.
+//
+// Readers and above may always read, denied users may never read, and
+// unauthenticated users or those with no role may only read if the relay is
+// configured to be public.
+func (rl *Relay) CanRead(ws *relayws.WebSocket) (ok bool, reason string) {
+	if !rl.ACLActive() {
+		return true, ""
+	}
+	if MatchIP(rl.Config().AllowIPs, ws.RealRemote()) {
+		return true, ""
+	}
+	switch rl.GetRole(ws) {
+	case acl.Owner, acl.Admin, acl.Writer, acl.Reader:
+		return true, ""
+	case acl.Denied:
+		return false, normalize.Reason("access to this relay has been denied",
+			okenvelope.Blocked.S())
+	}
+	if rl.Config().Public {
+		return true, ""
+	}
+	if ws.AuthPubKey() == "" {
+		return false, normalize.Reason("this relay only serves members, "+
+			"please authenticate", auth.Required)
+	}
+	return false, normalize.Reason("authenticated user is not a member of "+
+		"this relay", okenvelope.Restricted.S())
+}
+
+// CanWrite checks whether the user on a websocket may publish an event to the
+// relay, returning a machine readable reason if not.
+//
+// Writers and above may publish, everyone else may only send direct messages to
+// the relay chat control interface, which does its own authentication.
+func (rl *Relay) CanWrite(ws *relayws.WebSocket, ev *event.T) (ok bool,
+	reason string) {
+
+	if !rl.ACLActive() {
+		return true, ""
+	}
+	role := rl.GetRole(ws)
+	switch role {
+	case acl.Owner, acl.Admin, acl.Writer:
+		return true, ""
+	case acl.Denied:
+		return false, normalize.Reason("access to this relay has been denied",
+			okenvelope.Blocked.S())
+	}
+	if rl.IsChatMessage(ev) {
+		return true, ""
+	}
+	if ws.AuthPubKey() == "" {
+		return false, normalize.Reason("this relay only accepts events from "+
+			"members, please authenticate", auth.Required)
+	}
+	if role == acl.Reader {
+		return false, normalize.Reason("reader role does not permit "+
+			"publishing events", okenvelope.Restricted.S())
+	}
+	return false, normalize.Reason("authenticated user is not a member of "+
+		"this relay", okenvelope.Restricted.S())
+}
+
+// CanDelete returns true if the author of a deletion event may delete the
+// events of another pubkey.
+//
+// Users may always delete their own events, owners may delete any events and
+// admins may delete the events of anyone except owners and admins.
+func (rl *Relay) CanDelete(deleter, author string) bool {
+	if deleter == author {
+		return true
+	}
+	switch rl.ACL.GetRole(deleter) {
+	case acl.Owner:
+		return true
+	case acl.Admin:
+		role := rl.ACL.GetRole(author)
+		return role != acl.Owner && role != acl.Admin
+	}
+	return false
+}
+
+// IsSpammer returns true if the author of an event is banned or denied by the
+// ACL. Search results from spammers are hidden unless the NIP-50 include:spam
+// extension is used.
+func (rl *Relay) IsSpammer(pub string) bool {
+	if _, banned := rl.Spam.IsBanned(pub); banned {
+		return true
+	}
+	return rl.ACL.GetRole(pub) == acl.Denied
+}
+
+// MatchIP returns true if a remote address, with or without its port, is one
+// of a list of IP addresses.
+func MatchIP(addrs []string, remote string) bool {
+	host := RemoteHost(remote)
+	for _, v := range addrs {
+		if v == remote || v == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Whitelisted returns true if a remote address may access the relay, which is
+// when there is no Whitelist or it is on the Whitelist.
+func (rl *Relay) Whitelisted(remote string) bool {
+	wl := rl.Whitelist()
+	return len(wl) == 0 || MatchIP(wl, remote)
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+)
+
+// newACLRelay returns a relay with a user of each role in its ACL, and a
+// websocket authenticated as each of them.
+func newACLRelay(t *testing.T, conf *base.Config) (rl *Relay,
+	users map[acl.Role]*relayws.WebSocket) {
+
+	relay, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	rl = &Relay{RelayPubHex: relay, ACL: &acl.T{}}
+	rl.SetConfig(conf)
+	users = make(map[acl.Role]*relayws.WebSocket)
+	for _, role := range []acl.Role{acl.Owner, acl.Admin, acl.Writer,
+		acl.Reader, acl.Denied, acl.None} {
+		pub, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+		if role != acl.None {
+			if err := rl.ACL.AddEntry(&acl.Entry{Role: role,
+				Pubkey: pub}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		users[role] = &relayws.WebSocket{}
+		users[role].SetAuthPubKey(pub)
+	}
+	return
+}
+
+func TestCanRead(t *testing.T) {
+	anon := &relayws.WebSocket{}
+	for _, public := range []bool{false, true} {
+		rl, users := newACLRelay(t, &base.Config{Public: public})
+		for role, ws := range users {
+			expected := role != acl.Denied && (role != acl.None || public)
+			if ok, reason := rl.CanRead(ws); ok != expected {
+				t.Errorf("public %v: expected %s read %v, got %v '%s'", public,
+					acl.RoleStrings[role], expected, ok, reason)
+			}
+		}
+		if ok, reason := rl.CanRead(anon); ok != public {
+			t.Errorf("public %v: expected unauthenticated read %v, got %v "+
+				"'%s'", public, public, ok, reason)
+		}
+	}
+	// a relay with no ACL is open
+	rl := &Relay{ACL: &acl.T{}}
+	rl.SetConfig(&base.Config{})
+	if ok, reason := rl.CanRead(anon); !ok {
+		t.Errorf("relay without ACL refused read: %s", reason)
+	}
+}
+
+func TestCanWrite(t *testing.T) {
+	rl, users := newACLRelay(t, &base.Config{Public: true})
+	note := &event.T{Kind: kind.TextNote}
+	for role, ws := range users {
+		expected := role == acl.Owner || role == acl.Admin ||
+			role == acl.Writer
+		if ok, reason := rl.CanWrite(ws, note); ok != expected {
+			t.Errorf("expected %s write %v, got %v '%s'",
+				acl.RoleStrings[role], expected, ok, reason)
+		}
+	}
+	// anyone but denied users may send direct messages to the relay chat
+	anon := &relayws.WebSocket{}
+	dm := &event.T{Kind: kind.EncryptedDirectMessage,
+		Tags: tags.T{{"p", rl.RelayPubHex}}}
+	for _, ws := range []*relayws.WebSocket{anon, users[acl.Reader],
+		users[acl.None]} {
+		if ok, reason := rl.CanWrite(ws, dm); !ok {
+			t.Errorf("direct message to the relay refused: %s", reason)
+		}
+	}
+	if ok, _ := rl.CanWrite(users[acl.Denied], dm); ok {
+		t.Errorf("direct message from denied user accepted")
+	}
+	if ok, _ := rl.CanWrite(anon, note); ok {
+		t.Errorf("event from unauthenticated user accepted")
+	}
+}
+
+func TestRealRemote(t *testing.T) {
+	rl := &Relay{}
+	rl.SetConfig(&base.Config{TrustedProxies: []string{"10.0.0.1"}})
+	for i, test := range []struct {
+		remote, forwardedFor, expected string
+	}{
+		{"10.0.0.1:4000", "", "10.0.0.1:4000"},
+		{"10.0.0.1:4000", "1.2.3.4", "1.2.3.4"},
+		// only the address appended by the proxy is trusted
+		{"10.0.0.1:4000", "5.6.7.8, 1.2.3.4", "1.2.3.4"},
+		// the header is ignored if the connection is not from a proxy
+		{"10.0.0.2:4000", "1.2.3.4", "10.0.0.2:4000"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if rr := rl.RealRemote(r); rr != test.expected {
+			t.Errorf("%d: expected remote %s, got %s", i, test.expected, rr)
+		}
+	}
+}
+
+func TestAllowIPs(t *testing.T) {
+	rl, _ := newACLRelay(t, &base.Config{AllowIPs: []string{"10.0.0.9"},
+		Whitelist: []string{"10.0.0.9"}})
+	for i, test := range []struct {
+		remote, forwardedFor string
+		allowed              bool
+	}{
+		{"10.0.0.9:4000", "", true},
+		{"10.0.0.8:4000", "", false},
+		// the forwarded address is chosen by the client
+		{"10.0.0.8:4000", "10.0.0.9", false},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		ws := &relayws.WebSocket{}
+		ws.SetRealRemote(rl.RealRemote(r))
+		if ok, _ := rl.CanRead(ws); ok != test.allowed {
+			t.Errorf("%d: expected read from %s %v, got %v", i, test.remote,
+				test.allowed, ok)
+		}
+		if ok := rl.Whitelisted(ws.RealRemote()); ok != test.allowed {
+			t.Errorf("%d: expected %s whitelisted %v, got %v", i,
+				test.remote, test.allowed, ok)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package acl
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 
@@ -45,6 +46,11 @@ const (
 	Kind        = kind.ACLEvent
 	ReplacesTag = "replaces"
 	ExpiryTag   = "expiry"
+	ReasonTag   = "reason"
+	// AddressTag is the d tag of an ACL event, which is the pubkey of the
+	// entry, so the parameterized replaceable ACL events of different pubkeys
+	// do not replace each other.
+	AddressTag = "d"
 )
 
 // RoleStrings are the human readable form of the role enums.
@@ -57,6 +63,16 @@ var RoleStrings = []string{
 	"none",
 }
 
+// ParseRole converts a role string as found in RoleStrings back to a Role.
+func ParseRole(s string) (role Role, ok bool) {
+	for i, v := range RoleStrings {
+		if s == v {
+			return Role(i), true
+		}
+	}
+	return None, false
+}
+
 type (
 	// Entry is
 	Entry struct {
@@ -80,6 +96,9 @@ type (
 		// Expires is the unix timestamp after which this entry is no longer in
 		// force and in effect reverts to None.
 		Expires timestamp.T
+		// Reason is the explanation given by the user that made the change, if
+		// any, such as why a pubkey was denied.
+		Reason string
 	}
 	// T is the state information of the relay's Access Control List (ACL).
 	T struct {
@@ -93,8 +112,10 @@ func (ae *T) AddEntry(entry *Entry) (err error) {
 	if entry == nil {
 		return log.E.Err("nil entry for ACL")
 	}
-	// set last modified timestamp to now
-	entry.LastModified = timestamp.Now()
+	// set last modified timestamp to now if it wasn't set from an event
+	if entry.LastModified == 0 {
+		entry.LastModified = timestamp.Now()
+	}
 	// scan for duplicate and replace if found
 	ae.Lock()
 	defer ae.Unlock()
@@ -107,12 +128,18 @@ func (ae *T) AddEntry(entry *Entry) (err error) {
 					"possible to change in configuration")
 			}
 			entry.Replaces = v.EventID
+			if entry.Created == 0 {
+				entry.Created = v.Created
+			}
 			ae.entries[i] = entry
-			log.D.Ln("replacing entry for key '%s' role '%s'",
+			log.D.F("replacing entry for key '%s' role '%s'",
 				entry.Pubkey, RoleStrings[entry.Role])
 			return
 		}
 	}
+	if entry.Created == 0 {
+		entry.Created = entry.LastModified
+	}
 	ae.entries = append(ae.entries, entry)
 	return
 }
@@ -151,13 +178,63 @@ func (ae *T) DeleteEntry(pub string) (err error) {
 		}
 		// prune off the last entry, which will now be the same as the second
 		// last.
-		ae.entries = ae.entries[:len(ae.entries)]
+		ae.entries = ae.entries[:counter]
 	} else {
 		return log.D.Err("cannot delete: pubkey not found %s", pub)
 	}
 	return
 }
 
+// SetOwners replaces the entries with the Owner role with the given pubkeys, as
+// configured for the relay, and returns the pubkeys that were added and
+// removed. Owners that are removed revert to None.
+//
+// This is the only way owner entries can be changed.
+func (ae *T) SetOwners(owners []string) (added, removed []string) {
+	ae.Lock()
+	defer ae.Unlock()
+	want := make(map[string]bool)
+	for _, o := range owners {
+		want[o] = true
+	}
+	var counter int
+	for _, v := range ae.entries {
+		if v.Role == Owner && !want[v.Pubkey] {
+			removed = append(removed, v.Pubkey)
+			continue
+		}
+		if v.Role == Owner {
+			delete(want, v.Pubkey)
+		}
+		ae.entries[counter] = v
+		counter++
+	}
+	ae.entries = ae.entries[:counter]
+	now := timestamp.Now()
+	for _, o := range owners {
+		if !want[o] {
+			continue
+		}
+		delete(want, o)
+		added = append(added, o)
+		en := &Entry{Role: Owner, Pubkey: o, Created: now, LastModified: now}
+		// an owner that previously had another role takes its place
+		var replaced bool
+		for i, v := range ae.entries {
+			if v.Pubkey == o {
+				en.Created, en.Replaces = v.Created, v.EventID
+				ae.entries[i] = en
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ae.entries = append(ae.entries, en)
+		}
+	}
+	return
+}
+
 // Find an Entry in the acl.T that has the matching public key.
 func (ae *T) Find(pub string) (e *Entry) {
 	ae.Lock()
@@ -170,6 +247,54 @@ func (ae *T) Find(pub string) (e *Entry) {
 	return
 }
 
+// Entries returns a copy of the list of entries in the acl.T, optionally only
+// those with one of the given roles, in the order they were first created.
+func (ae *T) Entries(roles ...Role) (entries []*Entry) {
+	ae.Lock()
+	defer ae.Unlock()
+	for _, v := range ae.entries {
+		if len(roles) > 0 {
+			var match bool
+			for _, r := range roles {
+				if v.Role == r {
+					match = true
+					break
+				}
+			}
+			if !match {
+				continue
+			}
+		}
+		en := *v
+		entries = append(entries, &en)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created < entries[j].Created
+	})
+	return
+}
+
+// GetRole returns the Role in force for a pubkey, or None if the pubkey has no
+// Entry in the acl.T or the Entry has expired.
+func (ae *T) GetRole(pub string) (role Role) {
+	if e := ae.Find(pub); e != nil && !e.Expired() {
+		return e.Role
+	}
+	return None
+}
+
+// Expired returns true if the Entry has an expiry time and it has passed.
+func (a *Entry) Expired() bool {
+	return a.Expires > 0 && a.Expires <= timestamp.Now()
+}
+
+// Len returns the number of entries in the acl.T.
+func (ae *T) Len() (l int) {
+	ae.Lock()
+	defer ae.Unlock()
+	return len(ae.entries)
+}
+
 // ToEvent converts an Entry into a raw ACL event.T.
 //
 // note that these are always generated by the ACL configuration interface in
@@ -182,7 +307,8 @@ func (a *Entry) ToEvent() (ev *event.T) {
 	ev = &event.T{
 		CreatedAt: timestamp.Now(),
 		Kind:      Kind,
-		Tags:      tags.T{{"p", a.Pubkey, RoleStrings[a.Role]}},
+		Tags: tags.T{{AddressTag, a.Pubkey},
+			{"p", a.Pubkey, RoleStrings[a.Role]}},
 	}
 	if a.Expires > 0 {
 		ev.Tags = append(ev.Tags, tag.T{ExpiryTag, fmt.Sprint(a.Expires)})
@@ -190,6 +316,9 @@ func (a *Entry) ToEvent() (ev *event.T) {
 	if a.Replaces != "" {
 		ev.Tags = append(ev.Tags, tag.T{ReplacesTag, a.Replaces.String()})
 	}
+	if a.Reason != "" {
+		ev.Tags = append(ev.Tags, tag.T{ReasonTag, a.Reason})
+	}
 	return
 }
 
@@ -197,22 +326,28 @@ func (a *Entry) ToEvent() (ev *event.T) {
 //
 // The ACL control system will in fact generate an Entry first, run
 // Entry.ToEvent to derive a properly formatted event, sign it, and then run
-// FromEvent to validate it after which it will then sign it and store the event
-// into the database so it is available for searches and for initializing the
-// acl.T at startup to configure the ACL.
+// ParseEvent to validate it, store the event into the database so it is
+// available for searches and for initializing the acl.T at startup, and then
+// add the Entry to the acl.T.
 func (ae *T) FromEvent(ev *event.T) (e *Entry, err error) {
+	if e, err = ae.ParseEvent(ev); chk.E(err) {
+		return
+	}
+	if err = ae.AddEntry(e); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseEvent validates an ACL event.T and returns the Entry it describes
+// without changing the acl.T.
+func (ae *T) ParseEvent(ev *event.T) (e *Entry, err error) {
 	// first populate the fields that are instantly transferable
 	e = &Entry{
 		EventID:      ev.ID,
 		AuthKey:      ev.PubKey,
 		LastModified: ev.CreatedAt,
 	}
-	// If the pubkey appears already in the in-memory ACL copy in its Created
-	// timestamp to maintain the record's provenance efficiently.
-	previous := ae.Find(ev.PubKey)
-	if previous != nil {
-		e.Created = previous.Created
-	}
 	// Role requires converting the string back to a number... the strings must
 	// be exactly as in the list RoleStrings. Also there must be a role.
 	pTags := ev.Tags.GetAll("p")
@@ -221,7 +356,7 @@ func (ae *T) FromEvent(ev *event.T) (e *Entry, err error) {
 		return
 	}
 	pTag := pTags[0]
-	if len(pTag) > 3 {
+	if len(pTag) < 3 {
 		err = log.E.Err("p tag with insufficient fields found: %d %v",
 			len(pTag), pTag)
 		return
@@ -235,25 +370,38 @@ func (ae *T) FromEvent(ev *event.T) (e *Entry, err error) {
 	if _, err = hex.Dec(e.Pubkey); chk.D(err) {
 		return
 	}
-	var match bool
-	for i, v := range RoleStrings {
-		if pTag[2] == v {
-			e.Role = Role(i)
-			match = true
-			break
-		}
+	// the d tag makes the event replaceable per pubkey, so it must be present
+	// and name the same pubkey as the p tag.
+	dTag := ev.Tags.GetFirst([]string{AddressTag, ""})
+	if dTag == nil {
+		err = log.E.Err("no d tag found in ACL event %s", ev.ID)
+		return
+	}
+	if dTag.Value() != e.Pubkey {
+		err = log.E.Err("d tag %s does not match the p tag pubkey %s",
+			dTag.Value(), e.Pubkey)
+		return
+	}
+	// If the pubkey appears already in the in-memory ACL copy in its Created
+	// timestamp to maintain the record's provenance efficiently.
+	previous := ae.Find(e.Pubkey)
+	if previous != nil {
+		e.Created = previous.Created
+	} else {
+		e.Created = ev.CreatedAt
 	}
-	if !match {
+	var match bool
+	if e.Role, match = ParseRole(pTag[2]); !match {
 		err = log.E.Err("no match on role string: %v", pTag)
 		return
 	}
-	// Look for the Expires tag.
-	expiryTags := ev.Tags.GetAll("expiry")
-	if len(expiryTags) != 1 {
-		err = log.E.Err("other than 1 expiry tag found: %d %v",
+	// Look for the Expires tag, if there is none, the entry does not expire.
+	expiryTags := ev.Tags.GetAll(ExpiryTag)
+	if len(expiryTags) > 1 {
+		err = log.E.Err("more than 1 expiry tag found: %d %v",
 			len(expiryTags), expiryTags)
 		return
-	} else {
+	} else if len(expiryTags) > 0 {
 		expiryTag := expiryTags[0]
 		if len(expiryTag) < 2 {
 			err = log.E.Err("expiry tag with insufficient fields found: %d %v",
@@ -268,7 +416,7 @@ func (ae *T) FromEvent(ev *event.T) (e *Entry, err error) {
 		e.Expires = timestamp.FromUnix(exp)
 	}
 	// Look for the replaces tag.
-	replacesTags := ev.Tags.GetAll("replaces")
+	replacesTags := ev.Tags.GetAll(ReplacesTag)
 	if len(replacesTags) > 1 {
 		err = log.E.Err("other than 1 replaces tag found: %d %v",
 			len(replacesTags), replacesTags)
@@ -276,7 +424,7 @@ func (ae *T) FromEvent(ev *event.T) (e *Entry, err error) {
 	} else if len(replacesTags) > 0 {
 		replacesTag := replacesTags[0]
 		if len(replacesTag) < 2 {
-			err = log.E.Err("expiry tag with insufficient fields found: %d %v",
+			err = log.E.Err("replaces tag with insufficient fields found: %d %v",
 				len(replacesTag), replacesTag)
 			return
 		}
@@ -292,8 +440,8 @@ func (ae *T) FromEvent(ev *event.T) (e *Entry, err error) {
 		}
 		e.Replaces = eventid.T(replaces)
 	}
-	if err = ae.AddEntry(e); chk.E(err) {
-		return
+	if reasonTag := ev.Tags.GetFirst([]string{ReasonTag, ""}); reasonTag != nil {
+		e.Reason = reasonTag.Value()
 	}
 	return
 }
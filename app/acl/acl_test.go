@@ -39,6 +39,7 @@ func TestT(t *testing.T) {
 			Created:      timestamp.Now() - 1,
 			LastModified: timestamp.Now(),
 			Expires:      timestamp.Now() + 100000,
+			Reason:       "test entry",
 		}
 		if err = aclT.AddEntry(en); err != nil {
 			t.Fatal(err)
@@ -51,7 +52,10 @@ func TestT(t *testing.T) {
 		if e, err = aclT.FromEvent(ev); err != nil {
 			t.Fatal(err)
 		}
-		_ = e
+		if e.Reason != en.Reason {
+			t.Fatalf("reason not restored from event, expected '%s' got '%s'",
+				en.Reason, e.Reason)
+		}
 	}
 	frand.Shuffle(len(pubkeys), func(i, j int) {
 		pubkeys[i], pubkeys[j] = pubkeys[j], pubkeys[i]
@@ -62,3 +66,96 @@ func TestT(t *testing.T) {
 		}
 	}
 }
+
+func TestExpiry(t *testing.T) {
+	seed, err := hex.Dec(testRelaySec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := frand.NewCustom(seed, 128, 20)
+	var sec *secp256k1.SecretKey
+	if sec, err = secp256k1.GenerateSecretKeyFromRand(src); err != nil {
+		t.Fatal(err)
+	}
+	pub := hex.Enc(schnorr.SerializePubKey(sec.PubKey()))
+	aclT := &T{}
+	// an entry without expiry must round trip through an event
+	ev := (&Entry{Role: Writer, Pubkey: pub}).ToEvent()
+	if err = ev.Sign(testRelaySec); err != nil {
+		t.Fatal(err)
+	}
+	var e *Entry
+	if e, err = aclT.FromEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+	if aclT.GetRole(pub) != Writer {
+		t.Fatalf("expected role %s got %s", RoleStrings[Writer],
+			RoleStrings[aclT.GetRole(pub)])
+	}
+	// an expired entry replacing it reverts to None
+	ev = (&Entry{Role: Reader, Pubkey: pub, Replaces: e.EventID,
+		Expires: timestamp.Now() - 1}).ToEvent()
+	if err = ev.Sign(testRelaySec); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = aclT.FromEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+	if e.Replaces != aclT.Find(pub).Replaces {
+		t.Fatalf("replaces not recorded")
+	}
+	if aclT.GetRole(pub) != None {
+		t.Fatalf("expected role %s got %s", RoleStrings[None],
+			RoleStrings[aclT.GetRole(pub)])
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	seed, err := hex.Dec(testRelaySec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := frand.NewCustom(seed, 128, 20)
+	var sec *secp256k1.SecretKey
+	if sec, err = secp256k1.GenerateSecretKeyFromRand(src); err != nil {
+		t.Fatal(err)
+	}
+	pub := hex.Enc(schnorr.SerializePubKey(sec.PubKey()))
+	aclT := &T{}
+	// an event without a d tag is not a valid ACL event
+	ev := (&Entry{Role: Writer, Pubkey: pub}).ToEvent()
+	ev.Tags = ev.Tags.FilterOut([]string{AddressTag})
+	if _, err = aclT.ParseEvent(ev); err == nil {
+		t.Fatalf("ACL event without d tag accepted")
+	}
+	// nor is one with a d tag naming another pubkey
+	ev = (&Entry{Role: Writer, Pubkey: pub}).ToEvent()
+	ev.Tags = append(ev.Tags.FilterOut([]string{AddressTag}),
+		[]string{AddressTag, testRelaySec})
+	if _, err = aclT.ParseEvent(ev); err == nil {
+		t.Fatalf("ACL event with mismatched d tag accepted")
+	}
+}
+
+func TestSetOwners(t *testing.T) {
+	aclT := &T{}
+	aclT.SetOwners([]string{"a", "b"})
+	if err := aclT.AddEntry(&Entry{Role: Writer, Pubkey: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	added, removed := aclT.SetOwners([]string{"b", "c"})
+	if len(added) != 1 || added[0] != "c" || len(removed) != 1 ||
+		removed[0] != "a" {
+		t.Fatalf("expected c added and a removed, got %v %v", added, removed)
+	}
+	for pub, role := range map[string]Role{"a": None, "b": Owner,
+		"c": Owner} {
+		if aclT.GetRole(pub) != role {
+			t.Errorf("expected %s to be %s, got %s", pub, RoleStrings[role],
+				RoleStrings[aclT.GetRole(pub)])
+		}
+	}
+	if aclT.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", aclT.Len())
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"sort"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+// SetRole changes the role of a pubkey in the ACL.
+//
+// The change is made into an ACL event signed by the relay identity key, which
+// is saved through the StoreEvent chain so that it is replicated to any L2 event
+// store and can be replayed by LoadACL at startup, and then applied to the
+// in-memory ACL.
+//
+// An expires value of zero means the role does not expire. The reason is
+// recorded in the ACL event if it is not empty.
+func (rl *Relay) SetRole(c context.T, pub string, role acl.Role,
+	expires timestamp.T, reason string) (e *acl.Entry, err error) {
+
+	if role == acl.Owner {
+		err = log.E.Err("owners can only be set in the configuration")
+		return
+	}
+	en := &acl.Entry{Role: role, Pubkey: pub, Expires: expires,
+		Reason: reason}
+	prev := rl.ACL.Find(pub)
+	if prev != nil {
+		if prev.Role == acl.Owner {
+			err = log.E.Err("owner entries cannot be modified, only " +
+				"possible to change in configuration")
+			return
+		}
+		en.Replaces = prev.EventID
+	}
+	ev := en.ToEvent()
+	// a change in the same second as the previous one must still replace it
+	if prev != nil && ev.CreatedAt <= prev.LastModified {
+		ev.CreatedAt = prev.LastModified + 1
+	}
+	if err = ev.Sign(rl.Config().SecKey); chk.E(err) {
+		return
+	}
+	if e, err = rl.ACL.ParseEvent(ev); chk.E(err) {
+		return
+	}
+	// the event is stored before it is applied, so the in-memory ACL never
+	// has a change that would be lost at restart
+	for _, store := range rl.StoreEvent {
+		if err = store(c, ev); chk.E(err) {
+			return
+		}
+	}
+	if err = rl.ACL.AddEntry(e); chk.E(err) {
+		return
+	}
+	log.I.F("set role of %s to %s", pub, acl.RoleStrings[role])
+	return
+}
+
+// LoadACL replays the ACL events published by the relay identity from the
+// event stores, in order, to rebuild the in-memory ACL.
+//
+// This must be run after the QueryEvents functions are configured and after
+// the owners from the configuration have been added to the ACL.
+func (rl *Relay) LoadACL(c context.T) (err error) {
+	f := &filter.T{
+		Kinds:   kinds.T{acl.Kind},
+		Authors: tag.T{rl.RelayPubHex},
+	}
+	var evs []*event.T
+	for _, query := range rl.QueryEvents {
+		var ch event.C
+		if ch, err = query(c, f); chk.E(err) {
+			return
+		}
+		for ev := range ch {
+			if ev == nil || ev.PubKey != rl.RelayPubHex {
+				continue
+			}
+			var ok bool
+			if ok, err = ev.CheckSignature(); chk.E(err) || !ok {
+				log.W.Ln("ACL event with invalid signature", ev.ID)
+				err = nil
+				continue
+			}
+			evs = append(evs, ev)
+		}
+	}
+	sortACLEvents(evs)
+	var count int
+	for _, ev := range evs {
+		if _, err = rl.ACL.FromEvent(ev); chk.E(err) {
+			// skip invalid entries, the rest of the chain is still valid
+			err = nil
+			continue
+		}
+		count++
+	}
+	log.I.F("loaded %d ACL events, %d entries in ACL", count, rl.ACL.Len())
+	return
+}
+
+// sortACLEvents puts ACL events in the order they were created, events in the
+// same second are ordered by their replaces tags.
+func sortACLEvents(evs []*event.T) {
+	replaces := func(a, b *event.T) bool {
+		t := a.Tags.GetFirst([]string{acl.ReplacesTag, b.ID.String()})
+		return t != nil
+	}
+	sort.SliceStable(evs, func(i, j int) bool {
+		if evs[i].CreatedAt != evs[j].CreatedAt {
+			return evs[i].CreatedAt < evs[j].CreatedAt
+		}
+		return replaces(evs[j], evs[i])
+	})
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+)
+
+// newBadgerRelay returns a relay that stores events in a badger event store in
+// a temporary directory.
+func newBadgerRelay(t *testing.T, conf *base.Config) (rl *Relay,
+	db *badger.Backend) {
+
+	c, cancel := context.Cancel(context.Bg())
+	db = badger.GetBackend(c, &sync.WaitGroup{}, t.TempDir(), false, 0)
+	db.ManualGC = true
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		db.WG.Wait()
+		db.Close()
+	})
+	rl = NewRelay(c, cancel, &relayinfo.T{}, conf)
+	rl.Badger = db
+	rl.StoreEvent = append(rl.StoreEvent, db.SaveEvent)
+	rl.QueryEvents = append(rl.QueryEvents, db.QueryEvents)
+	return
+}
+
+func TestLoadACL(t *testing.T) {
+	conf := &base.Config{SecKey: keys.GeneratePrivateKey()}
+	rl, _ := newBadgerRelay(t, conf)
+	writer, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	reader, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	for pub, role := range map[string]acl.Role{writer: acl.Writer,
+		reader: acl.Reader} {
+		if _, err := rl.SetRole(rl.Ctx, pub, role, 0, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// changing a role replaces only the ACL event of that pubkey
+	if _, err := rl.SetRole(rl.Ctx, reader, acl.Denied, 0, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	// a restarted relay has only the ACL events in the event store
+	loaded := &Relay{RelayPubHex: rl.RelayPubHex, ACL: &acl.T{},
+		QueryEvents: rl.QueryEvents}
+	if err := loaded.LoadACL(rl.Ctx); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ACL.Len() != 2 {
+		t.Fatalf("expected 2 ACL entries, got %d", loaded.ACL.Len())
+	}
+	if role := loaded.ACL.GetRole(writer); role != acl.Writer {
+		t.Errorf("expected writer, got %s", acl.RoleStrings[role])
+	}
+	e := loaded.ACL.Find(reader)
+	if e == nil || e.Role != acl.Denied || e.Reason != "spam" {
+		t.Errorf("expected reader to be denied for spam, got %+v", e)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
@@ -11,6 +12,9 @@ import (
 
 // AddEvent sends an event through then normal add pipeline, as if it was
 // received from a websocket.
+//
+// Ephemeral events go through the same checks but are not stored, only
+// broadcast to the subscribers with matching filters.
 func (rl *Relay) AddEvent(c context.T, ev *event.T) (err error) {
 	if !rl.IsAuthed(c, "add event") {
 		return
@@ -20,8 +24,33 @@ func (rl *Relay) AddEvent(c context.T, ev *event.T) (err error) {
 		log.E.Ln(err)
 		return
 	}
+	if ok, reason := rl.CanWrite(GetConnection(c), ev); !ok {
+		err = errors.New(reason)
+		log.D.Ln(err, GetAuthed(c))
+		return
+	}
+	// NIP-40 events that have already expired are not accepted
+	if ev.IsExpired() {
+		err = errors.New(normalize.Reason("event has already expired",
+			okenvelope.Invalid.S()))
+		log.D.Ln(err, ev.ID)
+		return
+	}
+	// ephemeral events are only relayed to subscribers, which can be disabled
+	if ev.Kind.IsEphemeral() && rl.Config() != nil && rl.Config().NoEphemeral {
+		err = errors.New(normalize.Reason(
+			"this relay does not relay ephemeral events",
+			okenvelope.Blocked.S()))
+		log.D.Ln(err, ev.ID)
+		return
+	}
 	for _, rej := range rl.RejectEvent {
 		if reject, msg := rej(c, ev); reject {
+			if IsShadowRejected(msg) {
+				// pretend the event was accepted
+				log.D.Ln(msg, ev.ID)
+				return
+			}
 			if msg == "" {
 				err = errors.New("blocked: no reason")
 				log.E.Ln(err)
@@ -38,8 +67,16 @@ func (rl *Relay) AddEvent(c context.T, ev *event.T) (err error) {
 		for _, store := range rl.StoreEvent {
 			if saveErr := store(c, ev); saveErr != nil {
 				switch {
+				case errors.Is(saveErr, ErrChatGiftWrap):
+					// only for the relay, pretend it was stored
+					log.D.Ln(ev.ID, saveErr)
+					return nil
 				case errors.Is(saveErr, eventstore.ErrDupEvent):
 					return saveErr
+				case errors.Is(saveErr, eventstore.ErrEventReplaced),
+					errors.Is(saveErr, eventstore.ErrEventDeleted):
+					log.D.Ln(ev.ID, saveErr)
+					return saveErr
 				default:
 					err = log.E.Err(normalize.Reason(saveErr.Error(), "error"))
 					log.D.Ln(ev.ID, err)
@@ -52,9 +89,6 @@ func (rl *Relay) AddEvent(c context.T, ev *event.T) (err error) {
 			ons(c, ev)
 		}
 		// log.I.Ln("saved event", ev.ID)
-	} else {
-		// log.I.Ln("ephemeral event")
-		return
 	}
 	for _, ovw := range rl.OverwriteResponseEvent {
 		ovw(c, ev)
@@ -18,7 +18,7 @@ func (rl *Relay) IsAuthed(c context.T, envType string) bool {
 		panic("how can has no websocket?")
 	}
 	// if access requires auth, check that auth is present.
-	if rl.Info.Limitation.AuthRequired && ws.AuthPubKey() == "" {
+	if rl.Info().Limitation.AuthRequired && ws.AuthPubKey() == "" {
 		reason := "this relay requires authentication for " + envType
 		log.I.Ln(reason)
 		chk.E(ws.WriteEnvelope(&closedenvelope.T{
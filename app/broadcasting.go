@@ -4,7 +4,6 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eventenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
-	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
 )
@@ -12,22 +11,39 @@ import (
 // BroadcastEvent emits an event to all listeners whose filters' match, skipping
 // all filters and actions it also doesn't attempt to store the event or trigger
 // any reactions or callbacks
+//
+// Only the subscriptions found as candidates in the matches index have their
+// filters checked against the event.
 func (rl *Relay) BroadcastEvent(ev *event.T) {
-	listeners.Range(func(ws *relayws.WebSocket, subs ListenerMap) bool {
-
-		if ws.AuthPubKey() == "" && rl.Info.Limitation.AuthRequired {
+	for ws, ids := range matches.Candidates(ev) {
+		subs, ok := listeners.Load(ws)
+		if !ok {
+			continue
+		}
+		if ws.AuthPubKey() == "" && rl.Info().Limitation.AuthRequired {
 			log.E.Ln("cannot broadcast to", ws.RealRemote(), "not authorized")
-			return true
+			continue
+		}
+		if ok, _ = rl.CanRead(ws); !ok {
+			log.T.Ln("not broadcasting to", ws.RealRemote(), ws.AuthPubKey(),
+				"no read access")
+			continue
 		}
-		subs.Range(func(id string, listener *Listener) bool {
-			if !listener.filters.Match(ev) {
-				return true
+		for _, id := range ids {
+			listener, ok := subs.Load(id)
+			if !ok || !listener.filters.Match(ev) {
+				continue
 			}
-			if kinds.IsPrivileged(ev.Kind) && rl.Info.Limitation.AuthRequired {
+			if kinds.IsGiftWrap(ev.Kind) && !rl.IsGiftWrapRecipient(ws, ev) {
+				log.T.Ln("not broadcasting gift wrap to", ws.RealRemote(),
+					"not the recipient")
+				continue
+			}
+			if kinds.IsPrivileged(ev.Kind) && rl.Info().Limitation.AuthRequired {
 				if ws.AuthPubKey() == "" {
 					log.T.Ln("not broadcasting privileged event to",
 						ws.RealRemote(), "not authenticated")
-					return true
+					continue
 				}
 				parties := tag.T{ev.PubKey}
 				pTags := ev.Tags.GetAll("p")
@@ -37,7 +53,7 @@ func (rl *Relay) BroadcastEvent(ev *event.T) {
 				if !parties.Contains(ws.AuthPubKey()) {
 					log.T.Ln("not broadcasting privileged event to",
 						ws.RealRemote(), "not party to event")
-					return true
+					continue
 				}
 			}
 			// todo: there may be an issue triggering repeated broadcasts via L2 reviving
@@ -50,8 +66,6 @@ func (rl *Relay) BroadcastEvent(ev *event.T) {
 				SubscriptionID: subscriptionid.T(id),
 				Event:          ev},
 			))
-			return true
-		})
-		return true
-	})
+		}
+	}
 }
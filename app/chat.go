@@ -1,19 +1,28 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/crypt"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eventenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/giftwrap"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 )
 
-// DecryptDM decrypts a DM, kind 4, 1059 or 1060
+// DecryptDM decrypts a DM, kind 4, or 14 sent in a kind 1059 gift wrap.
+//
+// The content of a kind 14 is not encrypted, it is the rumor of a gift wrap
+// that has already been opened, and youPub is not used for gift wraps as the
+// sender is only known after opening it.
 func DecryptDM(ev *event.T, meSec, youPub string) (decryptedStr string,
 	err error) {
 	switch ev.Kind {
@@ -26,13 +35,22 @@ func DecryptDM(ev *event.T, meSec, youPub string) (decryptedStr string,
 			return
 		}
 		decryptedStr = string(decrypted)
+	case kind.PrivateDirectMessage:
+		decryptedStr = ev.Content
 	case kind.GiftWrap:
+		var rumor *event.T
+		if rumor, err = giftwrap.Unwrap(ev, meSec); chk.E(err) {
+			return
+		}
+		decryptedStr = rumor.Content
 	case kind.GiftWrapWithKind4:
+		err = log.E.Err("kind %d gift wraps are not supported", ev.Kind)
 	}
 	return
 }
 
-// EncryptDM encrypts a DM, kind 4, 1059 or 1060
+// EncryptDM encrypts a DM, kind 4, or kind 14 which is sealed and sent in a
+// kind 1059 gift wrap, which is the event returned.
 func EncryptDM(ev *event.T, meSec, youPub string) (evo *event.T, err error) {
 	var secret []byte
 	switch ev.Kind {
@@ -46,13 +64,40 @@ func EncryptDM(ev *event.T, meSec, youPub string) (evo *event.T, err error) {
 		if err = ev.Sign(meSec); chk.E(err) {
 			return
 		}
-	case kind.GiftWrap:
+	case kind.PrivateDirectMessage, kind.GiftWrap:
+		ev.Kind = kind.PrivateDirectMessage
+		if ev, err = giftwrap.Wrap(ev, meSec, youPub); chk.E(err) {
+			return
+		}
 	case kind.GiftWrapWithKind4:
+		err = log.E.Err("kind %d gift wraps are not supported", ev.Kind)
+		return
 	}
 	evo = ev
 	return
 }
 
+// ErrChatGiftWrap is returned by Chat for a gift wrap to the relay, which is
+// accepted but not stored or broadcast.
+var ErrChatGiftWrap = errors.New("gift wrap to the relay chat")
+
+// IsChatMessage returns true if an event is a direct message to the relay
+// control chat, a kind 4 or a kind 1059 gift wrap tagging only the relay
+// pubkey. Messages that also tag other users are not for the relay alone, so
+// they get none of the exemptions of the chat.
+func (rl *Relay) IsChatMessage(ev *event.T) bool {
+	if ev.Kind != kind.EncryptedDirectMessage && ev.Kind != kind.GiftWrap {
+		return false
+	}
+	pTags := ev.Tags.GetAll("p")
+	for i := range pTags {
+		if pTags[i].Value() != rl.RelayPubHex {
+			return false
+		}
+	}
+	return len(pTags) > 0
+}
+
 // MakeReply creates an appropriate reply event from a provided event that is
 // being replied to (not quoting, just the right tags, timestamps and kind).
 func MakeReply(ev *event.T, content string) (evo *event.T) {
@@ -71,23 +116,48 @@ func MakeReply(ev *event.T, content string) (evo *event.T) {
 }
 
 // Chat implements the control interface, intercepting kind 4 encrypted direct
-// messages and processing them if they are for the relay's pubkey
+// messages and NIP-17 gift wrapped direct messages and processing them if they
+// are for the relay's pubkey. Replies are sent the same way as the message,
+// and only to the connection it came from until the sender has authenticated
+// on it.
+//
+// Gift wraps to the relay can only be opened by the relay, so ErrChatGiftWrap
+// is returned for them to stop them being stored or broadcast.
 func (rl *Relay) Chat(c context.T, ev *event.T) (err error) {
-	ws := GetConnection(c)
-	if ws == nil {
-		return
-	}
 	// log.T.Ln("running chat checker")
-	if ev.Kind != kind.EncryptedDirectMessage {
+	if ev.Kind != kind.EncryptedDirectMessage && ev.Kind != kind.GiftWrap {
 		// log.T.Ln("not chat event", ev.Kind, kind.GetString(ev.Kind))
 		return
 	}
-	if !ev.Tags.ContainsAny("p",
-		rl.RelayPubHex) && ev.PubKey != rl.RelayPubHex {
+	if !rl.IsChatMessage(ev) && ev.PubKey != rl.RelayPubHex {
 		// log.T.Ln("direct message not for relay chat", ev.PubKey, rl.RelayPubHex)
 		return
 	}
-	meSec, youPub := rl.Config.SecKey, ev.PubKey
+	if ev.Kind == kind.GiftWrap && rl.IsChatMessage(ev) {
+		defer func() {
+			if err == nil {
+				err = ErrChatGiftWrap
+			}
+		}()
+	}
+	ws := GetConnection(c)
+	if ws == nil {
+		return
+	}
+	meSec, youPub := rl.Config().SecKey, ev.PubKey
+	if ev.Kind == kind.GiftWrap {
+		// the message is the rumor inside the gift wrap, and the sender is
+		// the author of the seal it was in
+		if ev, err = giftwrap.Unwrap(ev, meSec); err != nil {
+			log.D.Ln("cannot open gift wrap to relay:", err)
+			return nil
+		}
+		if ev.Kind != kind.PrivateDirectMessage {
+			log.D.Ln("gift wrap to relay is not a direct message", ev.Kind)
+			return
+		}
+		youPub = ev.PubKey
+	}
 	log.T.Ln(rl.RelayPubHex, "receiving message via DM", ev.ToObject().String())
 	var decryptedStr string
 	decryptedStr, err = DecryptDM(ev, meSec, youPub)
@@ -119,7 +189,7 @@ func (rl *Relay) Chat(c context.T, ev *event.T) (err error) {
 					return
 				}
 				log.T.Ln("reply", reply.ToObject().String())
-				rl.BroadcastEvent(reply)
+				rl.sendReply(ws, reply)
 				ws.GenerateChallenge()
 				return
 			} else {
@@ -154,10 +224,21 @@ note that if you have NIP-42 enabled in the client and you are already authorise
 				return
 			}
 			log.T.Ln("reply", reply.ToObject().String())
-			rl.BroadcastEvent(reply)
+			rl.sendReply(ws, reply)
 			return
 		}
 	} else {
+		// commands are authorised by the role of the sender, so the sender
+		// must be the user authenticated on this connection.
+		if ws.AuthPubKey() != ev.PubKey {
+			reply = MakeReply(ev, "sender of command is not the "+
+				"authenticated user on this connection")
+			if reply, err = EncryptDM(reply, meSec, youPub); chk.E(err) {
+				return
+			}
+			rl.sendReply(ws, reply)
+			return
+		}
 		if err = rl.command(ev, decryptedStr); chk.E(err) {
 			return
 		}
@@ -165,6 +246,28 @@ note that if you have NIP-42 enabled in the client and you are already authorise
 	return
 }
 
+// sendReply writes a reply of the relay chat to the subscriptions of the
+// connection the message came from that match it. Unlike BroadcastEvent it
+// does not check whether the user on the connection may be sent the reply, as
+// the sender may not have authenticated yet, and the reply goes to no other
+// connection.
+func (rl *Relay) sendReply(ws *relayws.WebSocket, reply *event.T) {
+	subs, ok := listeners.Load(ws)
+	if !ok {
+		log.D.Ln("no subscriptions to send reply to", ws.RealRemote())
+		return
+	}
+	subs.Range(func(id string, listener *Listener) bool {
+		if listener.filters.Match(reply) {
+			chk.E(ws.WriteEnvelope(&eventenvelope.T{
+				SubscriptionID: subscriptionid.T(id),
+				Event:          reply},
+			))
+		}
+		return true
+	})
+}
+
 type Command struct {
 	Name string
 	Help string
@@ -174,7 +277,7 @@ type Command struct {
 
 func (rl *Relay) command(ev *event.T, cmd string) (err error) {
 	log.T.Ln("running relay method")
-	args := strings.Split(cmd, " ")
+	args := strings.Fields(cmd)
 	if len(args) < 1 {
 		err = log.E.Err("no command received")
 		return
@@ -201,7 +304,7 @@ func (rl *Relay) command(ev *event.T, cmd string) (err error) {
 			}
 		}
 	}
-	if reply, err = EncryptDM(reply, rl.Config.SecKey, ev.PubKey); chk.E(err) {
+	if reply, err = EncryptDM(reply, rl.Config().SecKey, ev.PubKey); chk.E(err) {
 		return
 	}
 	rl.BroadcastEvent(reply)
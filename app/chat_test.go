@@ -0,0 +1,232 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eventenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filters"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/fasthttp/websocket"
+)
+
+func TestGiftWrapDM(t *testing.T) {
+	relaySec, userSec := keys.GeneratePrivateKey(), keys.GeneratePrivateKey()
+	relayPub, _ := keys.GetPublicKey(relaySec)
+	userPub, _ := keys.GetPublicKey(userSec)
+	msg := &event.T{CreatedAt: timestamp.Now(),
+		Kind: kind.PrivateDirectMessage, Tags: tags.T{{"p", relayPub}},
+		Content: "help"}
+	wrap, err := EncryptDM(msg, userSec, relayPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := &Relay{RelayPubHex: relayPub}
+	if wrap.Kind != kind.GiftWrap || !rl.IsChatMessage(wrap) {
+		t.Fatalf("expected a gift wrap to the relay, got %s", wrap.Serialize())
+	}
+	var content string
+	if content, err = DecryptDM(wrap, relaySec, ""); err != nil {
+		t.Fatal(err)
+	}
+	if content != "help" {
+		t.Errorf("expected 'help', got '%s'", content)
+	}
+	// replies are wrapped the same way
+	msg.PubKey = userPub
+	reply := MakeReply(msg, "commands")
+	if reply, err = EncryptDM(reply, relaySec, userPub); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Kind != kind.GiftWrap || !reply.Tags.ContainsAny("p", userPub) {
+		t.Fatalf("expected a gift wrap to the user, got %s",
+			reply.Serialize())
+	}
+	if content, err = DecryptDM(reply, userSec, ""); err != nil {
+		t.Fatal(err)
+	}
+	if content != "commands" {
+		t.Errorf("expected 'commands', got '%s'", content)
+	}
+}
+
+func TestFilterGiftWraps(t *testing.T) {
+	user, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	other, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	// gift wraps are restricted even when authentication is not required
+	rl := &Relay{}
+	rl.SetInfo(&relayinfo.T{})
+	rl.SetConfig(&base.Config{})
+	anon, authed := &relayws.WebSocket{}, &relayws.WebSocket{}
+	authed.SetAuthPubKey(user)
+	giftWraps := kinds.T{kind.GiftWrap}
+	for i, tc := range []struct {
+		ws     *relayws.WebSocket
+		f      *filter.T
+		reject bool
+	}{
+		{anon, &filter.T{Kinds: giftWraps,
+			Tags: filter.TagMap{"#p": {user}}}, true},
+		{authed, &filter.T{Kinds: giftWraps,
+			Tags: filter.TagMap{"#p": {user}}}, false},
+		{authed, &filter.T{Kinds: giftWraps}, true},
+		{authed, &filter.T{Kinds: giftWraps,
+			Tags: filter.TagMap{"#p": {user, other}}}, true},
+		// the author of a gift wrap is not a party to it
+		{authed, &filter.T{Kinds: giftWraps, Authors: []string{user}}, true},
+		{anon, &filter.T{Kinds: kinds.T{kind.TextNote}}, false},
+	} {
+		c := context.Value(context.Bg(), wsKey, tc.ws)
+		if reject, msg := rl.FilterPrivileged(c, "sub", tc.f); reject !=
+			tc.reject {
+			t.Errorf("%d: expected reject %v, got %v '%s'", i, tc.reject,
+				reject, msg)
+		}
+	}
+	ev := &event.T{Kind: kind.GiftWrap, PubKey: user,
+		Tags: tags.T{{"p", other}}}
+	if rl.IsGiftWrapRecipient(authed, ev) {
+		t.Errorf("gift wrap to %s would be sent to its author", other)
+	}
+	ev.Tags = tags.T{{"p", user}}
+	if !rl.IsGiftWrapRecipient(authed, ev) || rl.IsGiftWrapRecipient(anon,
+		ev) {
+		t.Errorf("gift wrap should only be sent to its recipient")
+	}
+}
+
+func TestChatGiftWrapNotStored(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	rl.StoreEvent = []Events{rl.Chat, rl.Badger.SaveEvent}
+	other, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	msg := &event.T{CreatedAt: timestamp.Now(),
+		Kind: kind.PrivateDirectMessage, Tags: tags.T{{"p", rl.RelayPubHex}},
+		Content: "help"}
+	toRelay, err := EncryptDM(msg, keys.GeneratePrivateKey(), rl.RelayPubHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a gift wrap that also tags another user is not for the relay chat
+	toBoth := &event.T{CreatedAt: timestamp.Now(), Kind: kind.GiftWrap,
+		Tags: tags.T{{"p", rl.RelayPubHex}, {"p", other}}, Content: "wrapped"}
+	if err = toBoth.Sign(keys.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	anon, writer := &relayws.WebSocket{}, &relayws.WebSocket{}
+	writer.SetAuthPubKey(u.writer)
+	for i, tc := range []struct {
+		ws     *relayws.WebSocket
+		ev     *event.T
+		fail   bool
+		stored int
+	}{
+		{anon, toRelay, false, 0},
+		{anon, toBoth, true, 0},
+		{writer, toBoth, false, 1},
+	} {
+		c := context.Value(rl.Ctx, wsKey, tc.ws)
+		if err = rl.AddEvent(c, tc.ev); (err != nil) != tc.fail {
+			t.Errorf("%d: expected failure %v, got %v", i, tc.fail, err)
+		}
+		var n int
+		if n, err = rl.Badger.CountEvents(rl.Ctx,
+			&filter.T{IDs: tag.T{tc.ev.ID.String()}}); err != nil {
+			t.Fatal(err)
+		}
+		if n != tc.stored {
+			t.Errorf("%d: expected %d stored, got %d", i, tc.stored, n)
+		}
+	}
+}
+
+// newTestConnection returns the relay side of a websocket connection and the
+// client side.
+func newTestConnection(t *testing.T) (ws *relayws.WebSocket,
+	client *websocket.Conn) {
+
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns <- conn
+		}))
+	t.Cleanup(srv.Close)
+	var err error
+	client, _, err = websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	ws = &relayws.WebSocket{Conn: <-conns}
+	t.Cleanup(func() { RemoveListener(ws) })
+	return
+}
+
+func TestChatUnauthenticated(t *testing.T) {
+	rl, _ := newChatRelay(t, &base.Config{})
+	sec := keys.GeneratePrivateKey()
+	pub, _ := keys.GetPublicKey(sec)
+	ws, client := newTestConnection(t)
+	ws.GenerateChallenge()
+	// the sender is subscribed to its direct messages
+	_, cancel := context.CancelCause(context.Bg())
+	if err := SetListener("dms", ws, filters.T{{
+		Kinds: kinds.T{kind.EncryptedDirectMessage},
+		Tags:  filter.TagMap{"#p": {pub}}}}, cancel, 0); err != nil {
+		t.Fatal(err)
+	}
+	msg := &event.T{CreatedAt: timestamp.Now(),
+		Kind: kind.EncryptedDirectMessage, Tags: tags.T{{"p", rl.RelayPubHex}},
+		Content: "stats"}
+	msg, err := EncryptDM(msg, sec, rl.RelayPubHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rl.Chat(context.Value(rl.Ctx, wsKey, ws), msg); err != nil {
+		t.Fatal(err)
+	}
+	// the challenge is sent back on the connection of the sender
+	chk.E(client.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	var b []byte
+	if _, b, err = client.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	env, _, err := envelopes.ProcessEnvelope(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ee, ok := env.(*eventenvelope.T)
+	if !ok || ee.SubscriptionID != "dms" {
+		t.Fatalf("expected an event for subscription dms, got %s", b)
+	}
+	var content string
+	if content, err = DecryptDM(ee.Event, sec, rl.RelayPubHex); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "AUTH_") ||
+		!strings.Contains(content, ws.Challenge()) {
+		t.Errorf("expected the auth challenge in the reply, got:\n%s",
+			content)
+	}
+}
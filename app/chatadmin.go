@@ -0,0 +1,277 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/bech32encoding"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/units"
+	"github.com/fasthttp/websocket"
+)
+
+// ExportTimeFormat is the timestamp format used in the names of the files
+// written by the export command.
+const ExportTimeFormat = "20060102-150405"
+
+// adminOnly returns a reply refusing the command if the sender of the event is
+// not an owner or admin of the relay.
+func adminOnly(rl *Relay, ev *event.T, cmd *Command) (reply *event.T) {
+	role := rl.ACL.GetRole(ev.PubKey)
+	if role != acl.Owner && role != acl.Admin {
+		reply = MakeReply(ev, fmt.Sprintf(
+			"only owners and admins can use the %s command", cmd.Name))
+	}
+	return
+}
+
+// wrongArgs returns a reply with the help for a command that was invoked with
+// the wrong number of parameters.
+func wrongArgs(ev *event.T, cmd *Command, args ...string) (reply *event.T) {
+	replyString := fmt.Sprintf(
+		"wrong number of parameters, got: %d '%s'\n\n%s",
+		len(args), strings.Join(args, " "), cmd.Help)
+	return MakeReply(ev, replyString)
+}
+
+// ExportDir returns the directory the export command writes files to, creating
+// it if necessary. Files are never written inside the badger event store.
+func (rl *Relay) ExportDir() (dir string, err error) {
+	db := filepath.Clean(rl.Badger.Path)
+	if dir = rl.Config().ExportDir; dir == "" {
+		dir = db + "-exports"
+	}
+	if dir, err = filepath.Abs(dir); chk.E(err) {
+		return
+	}
+	var abs string
+	if abs, err = filepath.Abs(db); chk.E(err) {
+		return
+	}
+	if rel, rErr := filepath.Rel(abs, dir); rErr == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		err = log.E.Err("export directory %s is inside the event store %s",
+			dir, abs)
+		return
+	}
+	if err = os.MkdirAll(dir, 0700); chk.E(err) {
+		return
+	}
+	return
+}
+
+func stats(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
+	if reply = adminOnly(rl, ev, cmd); reply != nil {
+		return
+	}
+	var conns, authed, subs int
+	rl.clients.Range(func(_ *websocket.Conn, ws *relayws.WebSocket) bool {
+		conns++
+		if ws.AuthPubKey() != "" {
+			authed++
+		}
+		return true
+	})
+	listeners.Range(func(_ *relayws.WebSocket, l ListenerMap) bool {
+		subs += l.Size()
+		return true
+	})
+	replyString := fmt.Sprintf("connections: %d (%d authenticated)\n"+
+		"subscriptions: %d\nbanned: %d\n",
+		conns, authed, subs, len(rl.Spam.Banned()))
+	if rl.Badger == nil {
+		replyString += "\nno local event store"
+	} else {
+		unpruned, pruned, uTotal, pTotal, gErr := rl.Badger.GCCount()
+		if chk.E(gErr) {
+			reply = MakeReply(ev, fmt.Sprintf("failed to count events: %s",
+				gErr))
+			return
+		}
+		hw, lw := rl.Badger.GetEventHeadroom()
+		replyString += fmt.Sprintf("\nevents: %d using %0.3f MB\n"+
+			"pruned events: %d using %0.3f MB\n"+
+			"size limit: %0.3f MB high water: %0.3f MB low water: %0.3f MB\n",
+			len(unpruned), float64(uTotal)/units.Mb,
+			len(pruned), float64(pTotal)/units.Mb,
+			float64(rl.Badger.DBSizeLimit)/units.Mb,
+			float64(hw)/units.Mb, float64(lw)/units.Mb)
+	}
+	log.I.F("sending message to user\n%s", replyString)
+	reply = MakeReply(ev, replyString)
+	return
+}
+
+func gc(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
+	if reply = adminOnly(rl, ev, cmd); reply != nil {
+		return
+	}
+	if rl.Badger == nil {
+		reply = MakeReply(ev, "no local event store to garbage collect")
+		return
+	}
+	start := time.Now()
+	expired, evs, idxs, gErr := rl.Badger.GCRun()
+	if chk.E(gErr) {
+		reply = MakeReply(ev, fmt.Sprintf("garbage collection failed: %s",
+			gErr))
+		return
+	}
+	replyString := fmt.Sprintf("garbage collection deleted %d expired "+
+		"events, pruned %d events and %d indexes of pruned events in %v",
+		len(expired), len(evs), len(idxs), time.Now().Sub(start))
+	log.I.F("sending message to user\n%s", replyString)
+	reply = MakeReply(ev, replyString)
+	return
+}
+
+func ban(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
+	if reply = adminOnly(rl, ev, cmd); reply != nil {
+		return
+	}
+	if len(args) != 3 {
+		reply = wrongArgs(ev, cmd, args...)
+		return
+	}
+	address, name := args[1], args[1]
+	if ip := net.ParseIP(address); ip != nil {
+		address, name = ip.String(), ip.String()
+	} else {
+		if address, err = parsePubkey(args[1]); err != nil {
+			err = nil
+			reply = MakeReply(ev, fmt.Sprintf(
+				"'%s' is not an IP address or public key", args[1]))
+			return
+		}
+		if role := rl.ACL.GetRole(address); role == acl.Owner ||
+			(role == acl.Admin && rl.ACL.GetRole(ev.PubKey) != acl.Owner) {
+			reply = MakeReply(ev, fmt.Sprintf("cannot ban %s users",
+				acl.RoleStrings[role]))
+			return
+		}
+		name, _ = bech32encoding.HexToNpub(address)
+	}
+	d, dErr := time.ParseDuration(args[2])
+	if dErr != nil || d < 0 {
+		reply = MakeReply(ev, fmt.Sprintf("invalid duration '%s'\n\n%s",
+			args[2], cmd.Help))
+		return
+	}
+	var replyString string
+	if d == 0 {
+		rl.Spam.Unban(address)
+		replyString = fmt.Sprintf("lifted ban on %s", name)
+	} else {
+		by, _ := bech32encoding.HexToNpub(ev.PubKey)
+		sp := rl.Spam.Ban(address, time.Now().Add(d), "banned by "+by)
+		n := rl.Disconnect(func(ws *relayws.WebSocket) bool {
+			return RemoteHost(ws.RealRemote()) == address ||
+				ws.AuthPubKey() == address
+		})
+		replyString = fmt.Sprintf("banned %s until %s, %d offenses, "+
+			"closed %d connections", name,
+			sp.BannedUntil.UTC().Format(TimeFormat), sp.Offenses, n)
+	}
+	log.I.F("sending message to user\n%s", replyString)
+	reply = MakeReply(ev, replyString)
+	return
+}
+
+func kick(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
+	if reply = adminOnly(rl, ev, cmd); reply != nil {
+		return
+	}
+	if len(args) != 2 {
+		reply = wrongArgs(ev, cmd, args...)
+		return
+	}
+	var pub string
+	if pub, err = parsePubkey(args[1]); err != nil {
+		err = nil
+		reply = MakeReply(ev, fmt.Sprintf("invalid public key '%s'", args[1]))
+		return
+	}
+	n := rl.Disconnect(func(ws *relayws.WebSocket) bool {
+		return ws.AuthPubKey() == pub
+	})
+	npub, _ := bech32encoding.HexToNpub(pub)
+	replyString := fmt.Sprintf("closed %d connections of %s", n, npub)
+	log.I.F("sending message to user\n%s", replyString)
+	reply = MakeReply(ev, replyString)
+	return
+}
+
+func export(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
+	if reply = adminOnly(rl, ev, cmd); reply != nil {
+		return
+	}
+	if len(args) < 2 {
+		reply = wrongArgs(ev, cmd, args...)
+		return
+	}
+	if rl.Badger == nil {
+		reply = MakeReply(ev, "no local event store to export from")
+		return
+	}
+	f := &filter.T{}
+	if err = f.UnmarshalJSON([]byte(strings.Join(args[1:], " "))); err != nil {
+		reply = MakeReply(ev, fmt.Sprintf("invalid filter: %s", err))
+		err = nil
+		return
+	}
+	var dir string
+	if dir, err = rl.ExportDir(); chk.E(err) {
+		reply = MakeReply(ev, fmt.Sprintf("failed to create export "+
+			"directory: %s", err))
+		err = nil
+		return
+	}
+	var filename string
+	var fh *os.File
+	if fh, filename, err = createExportFile(dir, time.Now()); chk.E(err) {
+		reply = MakeReply(ev, fmt.Sprintf("failed to create export file: %s",
+			err))
+		err = nil
+		return
+	}
+	defer func() { chk.E(fh.Close()) }()
+	var n int
+	if n, err = rl.ExportFilter(rl.Badger, f, fh); chk.E(err) {
+		reply = MakeReply(ev, fmt.Sprintf("export failed after %d events: %s",
+			n, err))
+		err = nil
+		return
+	}
+	replyString := fmt.Sprintf("exported %d events matching %s to %s", n,
+		f.String(), filename)
+	log.I.F("sending message to user\n%s", replyString)
+	reply = MakeReply(ev, replyString)
+	return
+}
+
+// createExportFile creates a new file in dir named after the time of an export.
+// If a file of that name already exists, such as from another export in the
+// same second, a number is added to the name rather than overwriting it.
+func createExportFile(dir string, t time.Time) (fh *os.File, filename string,
+	err error) {
+
+	name := "export-" + t.UTC().Format(ExportTimeFormat)
+	for i := 0; ; i++ {
+		filename = filepath.Join(dir, name+".jsonl")
+		if i > 0 {
+			filename = filepath.Join(dir, fmt.Sprintf("%s-%d.jsonl", name, i))
+		}
+		fh, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+			0600)
+		if !os.IsExist(err) {
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestChatStats(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	runChatTests(t, rl, []chatTest{
+		{u.writer, "stats", "only owners and admins can use the stats"},
+		{u.admin, "stats", "connections: 0 (0 authenticated)"},
+		{u.admin, "stats", "events: 2 using"},
+	})
+}
+
+func TestChatGC(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	runChatTests(t, rl, []chatTest{
+		{u.writer, "gc", "only owners and admins can use the gc"},
+		{u.admin, "gc", "garbage collection deleted 0 expired events"},
+	})
+}
+
+func TestChatBan(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	admin2, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	if _, err := rl.SetRole(rl.Ctx, admin2, acl.Admin, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	runChatTests(t, rl, []chatTest{
+		{u.writer, "ban 10.1.2.3 1h", "only owners and admins can use the ban"},
+		{u.admin, "ban 10.1.2.3", "wrong number of parameters"},
+		{u.admin, "ban somewhere 1h", "is not an IP address or public key"},
+		{u.admin, "ban 10.1.2.3 soon", "invalid duration 'soon'"},
+		{u.admin, "ban 10.1.2.3 -1h", "invalid duration"},
+		{u.admin, "ban " + u.owner + " 1h", "cannot ban owner users"},
+		{u.admin, "ban " + admin2 + " 1h", "cannot ban admin users"},
+		{u.admin, "ban 10.1.2.3 1h", "banned 10.1.2.3 until"},
+		{u.owner, "ban " + admin2 + " 1h", "closed 0 connections"},
+	})
+	if _, banned := rl.Spam.IsBanned("10.1.2.3"); !banned {
+		t.Errorf("10.1.2.3 was not banned")
+	}
+	if _, banned := rl.Spam.IsBanned(admin2); !banned {
+		t.Errorf("%s was not banned", admin2)
+	}
+	runChatTests(t, rl, []chatTest{
+		{u.admin, "ban 10.1.2.3 0", "lifted ban on 10.1.2.3"},
+	})
+	if _, banned := rl.Spam.IsBanned("10.1.2.3"); banned {
+		t.Errorf("ban on 10.1.2.3 was not lifted")
+	}
+}
+
+func TestChatKick(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	runChatTests(t, rl, []chatTest{
+		{u.writer, "kick " + u.admin, "only owners and admins can use the kick"},
+		{u.admin, "kick", "wrong number of parameters"},
+		{u.admin, "kick " + u.writer + " now", "wrong number of parameters"},
+		{u.admin, "kick abcd", "invalid public key 'abcd'"},
+		{u.admin, "kick " + u.writer, "closed 0 connections of npub"},
+	})
+}
+
+func TestChatExport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "exports")
+	rl, u := newChatRelay(t, &base.Config{ExportDir: dir})
+	sec := keys.GeneratePrivateKey()
+	note := &event.T{Kind: kind.TextNote, CreatedAt: timestamp.Now(),
+		Content: "exported"}
+	if err := note.Sign(sec); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Badger.SaveEvent(rl.Ctx, note); err != nil {
+		t.Fatal(err)
+	}
+	runChatTests(t, rl, []chatTest{
+		{u.writer, `export {"kinds":[1]}`,
+			"only owners and admins can use the export"},
+		{u.admin, "export", "wrong number of parameters"},
+		{u.admin, `export {"kinds":`, "invalid filter"},
+		{u.admin, `export {"kinds":[1]}`, "exported 1 events"},
+	})
+	files, err := filepath.Glob(filepath.Join(dir, "export-*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected an export file in %s, got %v %v", dir, files, err)
+	}
+	var b []byte
+	if b, err = os.ReadFile(files[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), note.ID.String()) {
+		t.Errorf("export does not contain the note:\n%s", b)
+	}
+	// files are never written into the event store
+	conf := *rl.Config()
+	conf.ExportDir = filepath.Join(rl.Badger.Path, "exports")
+	rl.SetConfig(&conf)
+	runChatTests(t, rl, []chatTest{
+		{u.admin, `export {"kinds":[1]}`, "is inside the event store"},
+	})
+}
+
+func TestCreateExportFile(t *testing.T) {
+	dir, now := t.TempDir(), time.Now()
+	names := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		fh, filename, err := createExportFile(dir, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fh.WriteString(filename); err != nil {
+			t.Fatal(err)
+		}
+		if err = fh.Close(); err != nil {
+			t.Fatal(err)
+		}
+		names[filename] = true
+	}
+	// exports in the same second must not overwrite each other
+	if len(names) != 3 {
+		t.Fatalf("expected 3 export files, got %v", names)
+	}
+	for filename := range names {
+		if b, err := os.ReadFile(filename); err != nil ||
+			string(b) != filename {
+			t.Errorf("export file %s was overwritten: '%s' %v", filename, b,
+				err)
+		}
+	}
+}
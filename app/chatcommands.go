@@ -2,9 +2,14 @@ package app
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/ec/schnorr"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/bech32encoding"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
 )
 
 var Commands []*Command
@@ -24,10 +29,12 @@ this relay chat bot understands the following commands:
 		},
 		{
 			Name: "set",
-			Help: `set <pubkey> [admin|writer|reader|none|denied]
+			Help: `set <npub|hex> <admin|writer|reader|none|denied>
 
 sets the permission for access by the user with <pubkey> to the relay
 
+only owner and admin users can use this command
+
 - admin : permission to change lower privilege levels on user accounts - only owners can change admins
 
 - writer : permission to request events and publish events to the relay
@@ -42,14 +49,68 @@ sets the permission for access by the user with <pubkey> to the relay
 		},
 		{
 			Name: "list",
-			Help: `list [admin|writer|reader|none|denied]
+			Help: `list [owner|admin|writer|reader|none|denied] [page]
 
 returns the list of pubkeys, optionally from a given privilege level
 
+entries are shown with the time they were created and last modified, 20 per page
+
 only owner and admin users can use this command
 `,
 			Func: list,
 		},
+		{
+			Name: "stats",
+			Help: `stats
+
+shows the number of connections and subscriptions and the size of the event store
+
+only owner and admin users can use this command
+`,
+			Func: stats,
+		},
+		{
+			Name: "gc",
+			Help: `gc
+
+runs the event store garbage collector and reports what was pruned
+
+only owner and admin users can use this command
+`,
+			Func: gc,
+		},
+		{
+			Name: "ban",
+			Help: `ban <ip|npub|hex> <duration>
+
+bans an IP address or pubkey from the relay for a duration such as 30m or 24h and closes its connections
+
+a duration of 0 lifts the ban
+
+only owner and admin users can use this command, and only owners can ban admins
+`,
+			Func: ban,
+		},
+		{
+			Name: "kick",
+			Help: `kick <npub|hex>
+
+closes all connections authenticated as the pubkey
+
+only owner and admin users can use this command
+`,
+			Func: kick,
+		},
+		{
+			Name: "export",
+			Help: `export <filter-json>
+
+writes the events matching a filter to a JSONL file in the export directory on the relay server and replies with the count and file name
+
+only owner and admin users can use this command
+`,
+			Func: export,
+		},
 	}
 }
 
@@ -117,6 +178,38 @@ func commandHelp(cmd *Command, args ...string) (replyString string) {
 	return
 }
 
+// ListPageSize is the number of ACL entries shown in one reply of the list
+// command.
+const ListPageSize = 20
+
+// TimeFormat is the format used for printing timestamps in chat replies.
+const TimeFormat = "2006-01-02 15:04:05 UTC"
+
+// parsePubkey accepts a public key in npub or hex format and returns the hex.
+func parsePubkey(s string) (pub string, err error) {
+	if strings.HasPrefix(s, bech32encoding.NpubHRP) {
+		var prefix string
+		if prefix, pub, err = bech32encoding.DecodeToString(s); chk.D(err) {
+			return
+		}
+		if prefix != bech32encoding.NpubHRP {
+			err = log.D.Err("not an npub: %s", s)
+		}
+		return
+	}
+	var b []byte
+	if b, err = hex.Dec(s); chk.D(err) {
+		return
+	}
+	if len(b) != schnorr.PubKeyBytesLen {
+		err = log.D.Err("public key must be %d bytes, got %d",
+			schnorr.PubKeyBytesLen, len(b))
+		return
+	}
+	pub = s
+	return
+}
+
 func set(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
 	var replyString string
 	if len(args) < 3 {
@@ -128,13 +221,91 @@ func set(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (r
 		reply = MakeReply(ev, replyString)
 		return
 	}
+	var pub string
+	if pub, err = parsePubkey(args[1]); err != nil {
+		err = nil
+		reply = MakeReply(ev, fmt.Sprintf("invalid public key '%s'", args[1]))
+		return
+	}
+	role, ok := acl.ParseRole(args[2])
+	if !ok || role == acl.Owner {
+		reply = MakeReply(ev, fmt.Sprintf("invalid role '%s'\n\n%s", args[2],
+			cmd.Help))
+		return
+	}
+	// only owners may add, change or remove administrators.
+	requester := rl.ACL.GetRole(ev.PubKey)
+	current := rl.ACL.GetRole(pub)
+	switch {
+	case requester != acl.Owner && requester != acl.Admin:
+		replyString = "only owners and admins can change roles"
+	case current == acl.Owner:
+		replyString = "owners can only be changed in the relay configuration"
+	case requester != acl.Owner && (role == acl.Admin || current == acl.Admin):
+		replyString = "only owners can change the role of admins"
+	}
+	if replyString != "" {
+		reply = MakeReply(ev, replyString)
+		return
+	}
+	if _, err = rl.SetRole(rl.Ctx, pub, role, 0, ""); err != nil {
+		reply = MakeReply(ev, fmt.Sprintf("failed to set role: %s", err))
+		err = nil
+		return
+	}
+	npub, _ := bech32encoding.HexToNpub(pub)
+	replyString = fmt.Sprintf("role of %s changed from %s to %s", npub,
+		acl.RoleStrings[current], acl.RoleStrings[role])
+	log.I.F("sending message to user\n%s", replyString)
 	reply = MakeReply(ev, replyString)
 	return
 }
 
 func list(rl *Relay, prefix string, ev *event.T, cmd *Command, args ...string) (reply *event.T, err error) {
-	replyString := fmt.Sprintf("list not implemented yet\n args: %v\nevent: %s\nprefix: %s", args,
-		ev.ToObject().String(), prefix)
+	requester := rl.ACL.GetRole(ev.PubKey)
+	if requester != acl.Owner && requester != acl.Admin {
+		reply = MakeReply(ev, "only owners and admins can list roles")
+		return
+	}
+	var roles []acl.Role
+	page := 1
+	for _, arg := range args[1:] {
+		if role, ok := acl.ParseRole(arg); ok {
+			roles = append(roles, role)
+		} else if p, perr := strconv.Atoi(arg); perr == nil && p > 0 {
+			page = p
+		} else {
+			reply = MakeReply(ev, fmt.Sprintf("invalid parameter '%s'\n\n%s",
+				arg, cmd.Help))
+			return
+		}
+	}
+	entries := rl.ACL.Entries(roles...)
+	pages := (len(entries) + ListPageSize - 1) / ListPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	start := (page - 1) * ListPageSize
+	end := start + ListPageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	replyString := fmt.Sprintf("%d entries, page %d of %d\n", len(entries),
+		page, pages)
+	for _, e := range entries[start:end] {
+		npub, _ := bech32encoding.HexToNpub(e.Pubkey)
+		replyString += fmt.Sprintf("\n%s %s\n created %s\n modified %s\n",
+			npub, acl.RoleStrings[e.Role],
+			e.Created.Time().UTC().Format(TimeFormat),
+			e.LastModified.Time().UTC().Format(TimeFormat))
+		if e.Expires > 0 {
+			replyString += fmt.Sprintf(" expires %s\n",
+				e.Expires.Time().UTC().Format(TimeFormat))
+		}
+	}
 	log.I.F("sending message to user\n%s", replyString)
 	reply = MakeReply(ev, replyString)
 	return
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+// chatUsers are the pubkeys of an owner, an admin and a writer of a relay with
+// the chat commands set up.
+type chatUsers struct {
+	owner, admin, writer string
+}
+
+// newChatRelay returns a relay with a badger event store, the chat commands and
+// an owner, admin and writer in its ACL.
+func newChatRelay(t *testing.T, conf *base.Config) (rl *Relay, u chatUsers) {
+	u.owner, _ = keys.GetPublicKey(keys.GeneratePrivateKey())
+	u.admin, _ = keys.GetPublicKey(keys.GeneratePrivateKey())
+	u.writer, _ = keys.GetPublicKey(keys.GeneratePrivateKey())
+	conf.SecKey = keys.GeneratePrivateKey()
+	conf.Owners = []string{u.owner}
+	rl, _ = newBadgerRelay(t, conf)
+	rl.Init()
+	for pub, role := range map[string]acl.Role{u.admin: acl.Admin,
+		u.writer: acl.Writer} {
+		if _, err := rl.SetRole(rl.Ctx, pub, role, 0, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+// runCommand runs a chat command sent by a pubkey and returns the content of
+// the reply.
+func runCommand(t *testing.T, rl *Relay, from, cmd string) string {
+	args := strings.Fields(cmd)
+	ev := &event.T{PubKey: from, CreatedAt: timestamp.Now(),
+		Kind: kind.EncryptedDirectMessage, Content: cmd}
+	for _, c := range Commands {
+		if c.Name != args[0] {
+			continue
+		}
+		reply, err := c.Func(rl, "", ev, c, args...)
+		if err != nil {
+			t.Fatalf("%s: %s", cmd, err)
+		}
+		if reply == nil {
+			t.Fatalf("%s: no reply", cmd)
+		}
+		return reply.Content
+	}
+	t.Fatalf("unknown command %s", args[0])
+	return ""
+}
+
+// chatTest is a chat command sent by a pubkey and a string expected in the
+// reply.
+type chatTest struct {
+	from, cmd, reply string
+}
+
+func runChatTests(t *testing.T, rl *Relay, tests []chatTest) {
+	for i, test := range tests {
+		if reply := runCommand(t, rl, test.from, test.cmd); !strings.Contains(
+			reply, test.reply) {
+			t.Errorf("%d: %s: expected reply containing '%s', got '%s'", i,
+				test.cmd, test.reply, reply)
+		}
+	}
+}
+
+func TestChatSet(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	user, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	runChatTests(t, rl, []chatTest{
+		{u.writer, "set " + user + " reader", "only owners and admins"},
+		{u.admin, "set " + user, "wrong number of parameters"},
+		{u.admin, "set abcd reader", "invalid public key"},
+		{u.admin, "set " + user + " owner", "invalid role 'owner'"},
+		{u.admin, "set " + user + " superuser", "invalid role"},
+		{u.admin, "set " + u.owner + " denied", "owners can only be changed"},
+		{u.admin, "set " + user + " admin", "only owners can change"},
+		{u.admin, "set " + u.admin + " reader", "only owners can change"},
+		{u.admin, "set " + user + " reader", "from none to reader"},
+		{u.owner, "set " + user + " admin", "from reader to admin"},
+	})
+	if role := rl.ACL.GetRole(user); role != acl.Admin {
+		t.Errorf("expected admin, got %s", acl.RoleStrings[role])
+	}
+}
+
+func TestChatList(t *testing.T) {
+	rl, u := newChatRelay(t, &base.Config{})
+	runChatTests(t, rl, []chatTest{
+		{u.writer, "list", "only owners and admins"},
+		{u.admin, "list sometimes", "invalid parameter 'sometimes'"},
+		{u.admin, "list", "3 entries, page 1 of 1"},
+		{u.admin, "list writer", "1 entries, page 1 of 1"},
+		{u.owner, "list reader 2", "0 entries, page 1 of 1"},
+	})
+}
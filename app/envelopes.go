@@ -2,7 +2,6 @@ package app
 
 import (
 	"errors"
-	"fmt"
 	"strings"
 	"sync"
 
@@ -28,20 +27,6 @@ func (rl *Relay) processEventEnvelope(msg []byte, env *eventenvelope.T,
 	if !rl.IsAuthed(c, "EVENT") {
 		return
 	}
-	// reject old dated events according to nip11
-	if env.Event.CreatedAt <= rl.Info.Limitation.Oldest {
-		log.D.F("rejecting event with date: %s %s %s",
-			env.Event.CreatedAt.Time().String(), ws.RealRemote(),
-			ws.AuthPubKey())
-		chk.E(ws.WriteEnvelope(&okenvelope.T{
-			ID: env.Event.ID,
-			OK: false,
-			Reason: fmt.Sprintf(
-				"invalid: relay limit disallows timestamps older than %d",
-				rl.Info.Limitation.Oldest),
-		}))
-		return
-	}
 	// check id
 	evs := env.Event.ToCanonical().Bytes()
 	hash := sha256.Sum256(evs)
@@ -59,6 +44,17 @@ func (rl *Relay) processEventEnvelope(msg []byte, env *eventenvelope.T,
 		}))
 		return
 	}
+	// reject events that exceed the limitations advertised in nip11
+	if ok, reason := rl.CheckEventLimits(env.Event); !ok {
+		log.D.F("rejecting event %s %s %s: %s", env.Event.ID,
+			ws.RealRemote(), ws.AuthPubKey(), reason)
+		chk.E(ws.WriteEnvelope(&okenvelope.T{
+			ID:     env.Event.ID,
+			OK:     false,
+			Reason: reason,
+		}))
+		return
+	}
 	// check signature
 	if ok, err = env.Event.CheckSignature(); chk.E(err) {
 		chk.E(ws.WriteEnvelope(&okenvelope.T{
@@ -89,7 +85,6 @@ func (rl *Relay) processEventEnvelope(msg []byte, env *eventenvelope.T,
 		if strings.HasPrefix(reason, auth.Required) {
 			log.I.Ln("requesting auth")
 			RequestAuth(c, env.Label())
-			ok = true
 		}
 		if strings.HasPrefix(reason, "duplicate") {
 			ok = true
@@ -119,13 +114,37 @@ func (rl *Relay) processCountEnvelope(msg []byte, env *countenvelope.Request,
 		}))
 		return
 	}
+	if ok, reason := rl.CheckReqLimits(env.ID, env.Filters); !ok {
+		chk.E(ws.WriteEnvelope(&closedenvelope.T{
+			ID:     env.ID,
+			Reason: reason,
+		}))
+		return
+	}
 	var total int
+	var approximate bool
 	for _, f := range env.Filters {
-		total += rl.handleCountRequest(c, env.ID, ws, f)
+		var subtotal int
+		var approx bool
+		if subtotal, approx, err = rl.handleCountRequest(c, env.ID, ws,
+			f); err != nil {
+			reason := err.Error()
+			if strings.HasPrefix(reason, auth.Required) {
+				RequestAuth(c, env.Label())
+			}
+			chk.E(ws.WriteEnvelope(&closedenvelope.T{
+				ID:     env.ID,
+				Reason: reason,
+			}))
+			return nil
+		}
+		total += subtotal
+		approximate = approximate || approx
 	}
 	chk.E(ws.WriteEnvelope(&countenvelope.Response{
-		ID:    env.ID,
-		Count: total,
+		ID:          env.ID,
+		Count:       total,
+		Approximate: approximate,
 	}))
 	return
 }
@@ -136,9 +155,26 @@ func (rl *Relay) processReqEnvelope(msg []byte, env *reqenvelope.T,
 	if !rl.IsAuthed(c, "REQ") {
 		return
 	}
+	if ok, reason := rl.CheckReqLimits(env.SubscriptionID,
+		env.Filters); !ok {
+		chk.E(ws.WriteEnvelope(&closedenvelope.T{
+			ID:     env.SubscriptionID,
+			Reason: reason,
+		}))
+		return
+	}
 	wg := sync.WaitGroup{}
 	// a context just for the "stored events" request handler
 	reqCtx, cancelReqCtx := context.CancelCause(c)
+	if err = SetListener(env.SubscriptionID.String(), ws, env.Filters,
+		cancelReqCtx, rl.Info().Limitation.MaxSubscriptions); err != nil {
+		chk.E(ws.WriteEnvelope(&closedenvelope.T{
+			ID:     env.SubscriptionID,
+			Reason: err.Error(),
+		}))
+		cancelReqCtx(err)
+		return nil
+	}
 	// expose subscription id in the context
 	reqCtx = context.Value(reqCtx, subscriptionIdKey, env.SubscriptionID)
 	// handle each filter separately -- dispatching events as they're loaded
@@ -151,20 +187,25 @@ func (rl *Relay) processReqEnvelope(msg []byte, env *reqenvelope.T,
 			ws,
 			f,
 		})
+		if errors.Is(err, ErrShadowRejected) {
+			// end the subscription without telling the client
+			RemoveListenerId(ws, env.SubscriptionID.String())
+			cancelReqCtx(err)
+			chk.E(ws.WriteEnvelope(&eoseenvelope.T{Sub: env.SubscriptionID}))
+			return nil
+		}
 		if log.T.Chk(err) {
 			// fail everything if any filter is rejected
 			reason := err.Error()
 			if strings.HasPrefix(reason, auth.Required) {
 				RequestAuth(c, env.Label())
 			}
-			if strings.HasPrefix(reason, "blocked") {
-				return
-			}
 			chk.E(ws.WriteEnvelope(&closedenvelope.T{
 				ID:     env.SubscriptionID,
 				Reason: reason,
 			}))
 			log.I.Ln("cancelling req context")
+			RemoveListenerId(ws, env.SubscriptionID.String())
 			cancelReqCtx(errors.New("filter rejected"))
 			return
 		}
@@ -177,7 +218,6 @@ func (rl *Relay) processReqEnvelope(msg []byte, env *reqenvelope.T,
 		cancelReqCtx(nil)
 		chk.E(ws.WriteEnvelope(&eoseenvelope.T{Sub: env.SubscriptionID}))
 	}()
-	SetListener(env.SubscriptionID.String(), ws, env.Filters, cancelReqCtx)
 	return
 }
 
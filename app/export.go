@@ -3,14 +3,17 @@ package app
 import (
 	"bytes"
 	"encoding/gob"
+	"io"
 	"os"
 	"sync"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
 	bdb "github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
 )
 
 // Export prints the JSON of all events or writes them to a file.
@@ -61,3 +64,52 @@ func (rl *Relay) Export(db *badger.Backend, filename string, wg *sync.WaitGroup)
 		return nil
 	}))
 }
+
+// ExportFilter writes the JSON of the events in the event store that match a
+// filter to a writer, one per line, and returns the number of events written.
+//
+// Events that have been pruned to the L2 are skipped, and the filter limit, if
+// any, caps the number of events written.
+func (rl *Relay) ExportFilter(db *badger.Backend, f *filter.T,
+	w io.Writer) (n int, err error) {
+
+	prf := []byte{index.Event.B()}
+	err = db.View(func(txn *bdb.Txn) (err error) {
+		it := txn.NewIterator(bdb.IteratorOptions{Prefix: prf})
+		defer it.Close()
+		for it.Rewind(); it.ValidForPrefix(prf); it.Next() {
+			select {
+			case <-rl.Ctx.Done():
+				return
+			default:
+			}
+			if f.Limit != nil && n >= *f.Limit {
+				return
+			}
+			var b []byte
+			if b, err = it.Item().ValueCopy(nil); chk.E(err) {
+				err = nil
+				continue
+			}
+			// pruned events only have their hash stored
+			if len(b) == sha256.Size {
+				continue
+			}
+			var ev *event.T
+			if ev, err = nostrbinary.Unmarshal(b); chk.E(err) {
+				err = nil
+				continue
+			}
+			if !f.Matches(ev) {
+				continue
+			}
+			if _, err = w.Write(append(ev.ToObject().Bytes(),
+				'\n')); chk.E(err) {
+				return
+			}
+			n++
+		}
+		return
+	})
+	return
+}
@@ -1,9 +1,14 @@
 package app
 
 import (
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/auth"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
 )
@@ -16,17 +21,21 @@ import (
 // being on the ACL.
 //
 // If the message is a private message, only authenticated users may get these
-// events who also match one of the parties in the conversation.
+// events who also match one of the parties in the conversation, and gift
+// wrapped messages are only served to the recipient.
 func (rl *Relay) FilterPrivileged(c context.T, id subscriptionid.T,
 	f *filter.T) (reject bool, msg string) {
 
 	ws := GetConnection(c)
-	authRequired := rl.Info.Limitation.AuthRequired
+	if kinds.IsGiftWrap(f.Kinds...) {
+		return rl.filterGiftWraps(ws, f)
+	}
+	authRequired := rl.Info().Limitation.AuthRequired
 	if !authRequired {
 		return
 	}
 	var allow bool
-	for _, v := range rl.Config.AllowIPs {
+	for _, v := range rl.Config().AllowIPs {
 		if ws.RealRemote() == v {
 			allow = true
 			break
@@ -63,3 +72,53 @@ func (rl *Relay) FilterPrivileged(c context.T, id subscriptionid.T,
 			"party in privileged message type"
 	}
 }
+
+// filterGiftWraps only permits filters for gift wraps that are tagged to the
+// authenticated user, whether or not the relay requires authentication, as the
+// author of a gift wrap is a throwaway key and the recipient is the only party
+// to it.
+func (rl *Relay) filterGiftWraps(ws *relayws.WebSocket,
+	f *filter.T) (reject bool, msg string) {
+
+	if rl.isAllowedIP(ws) {
+		return
+	}
+	if ws.AuthPubKey() == "" {
+		return true, normalize.Reason("gift wrapped messages are only "+
+			"served to their authenticated recipient", auth.Required)
+	}
+	receivers := append(tag.T{}, f.Tags["#p"]...)
+	receivers = append(receivers, f.Tags["p"]...)
+	if len(receivers) == 0 {
+		return true, normalize.Reason("gift wrapped messages can only be "+
+			"requested by their recipient with a p tag",
+			okenvelope.Restricted.S())
+	}
+	for _, r := range receivers {
+		if r != ws.AuthPubKey() {
+			return true, normalize.Reason("gift wrapped messages are only "+
+				"served to their recipient", okenvelope.Restricted.S())
+		}
+	}
+	return
+}
+
+// IsGiftWrapRecipient returns true if the user on a websocket may be sent a
+// gift wrapped event, which is only when they are the recipient tagged in it.
+func (rl *Relay) IsGiftWrapRecipient(ws *relayws.WebSocket, ev *event.T) bool {
+	if rl.isAllowedIP(ws) {
+		return true
+	}
+	return ws.AuthPubKey() != "" && ev.Tags.ContainsAny("p", ws.AuthPubKey())
+}
+
+// isAllowedIP returns true if a websocket is connected from one of the
+// AllowIPs.
+func (rl *Relay) isAllowedIP(ws *relayws.WebSocket) bool {
+	for _, v := range rl.Config().AllowIPs {
+		if ws.RealRemote() == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/count"
+	"github.com/Hubmakerlabs/replicatr/pkg/units"
+)
+
+// DefaultGCReportTop is the number of authors listed in a garbage collector
+// report if no number is given.
+const DefaultGCReportTop = 20
+
+// GC writes a report of what the garbage collector of the badger event store
+// would prune, and prunes it if the command says to apply it.
+func (rl *Relay) GC(db *badger.Backend, cmd *base.GCCmd,
+	w io.Writer) (err error) {
+
+	log.D.Ln("running gc subcommand")
+	var plan *badger.GCPlan
+	if plan, err = db.GCPlan(); chk.E(err) {
+		return
+	}
+	top := cmd.Top
+	if top <= 0 {
+		top = DefaultGCReportTop
+	}
+	if err = WriteGCReport(w, db, plan, top); chk.E(err) {
+		return
+	}
+	if !cmd.Apply {
+		_, err = fmt.Fprintln(w, "\ndry run, nothing was pruned: use --apply "+
+			"to prune")
+		return
+	}
+	if len(plan.PruneEvents) == 0 && len(plan.PruneIndexes) == 0 {
+		return
+	}
+	if err = db.GCSweep(plan.PruneEvents, plan.PruneIndexes); chk.E(err) {
+		return
+	}
+	// the relay exits without closing the database after a subcommand
+	if err = db.DB.Sync(); chk.E(err) {
+		return
+	}
+	_, err = fmt.Fprintf(w, "\npruned %d events and the indexes of %d "+
+		"pruned events\n", len(plan.PruneEvents), len(plan.PruneIndexes))
+	return
+}
+
+// gcTally is the number and size of the events of a kind or author in a
+// garbage collector report.
+type gcTally struct {
+	name   string
+	events int
+	size   int
+}
+
+// tally adds an event to the tally of a kind or author.
+func tally(m map[string]*gcTally, name string, size int) {
+	t, ok := m[name]
+	if !ok {
+		t = &gcTally{name: name}
+		m[name] = t
+	}
+	t.events++
+	t.size += size
+}
+
+// sortTallies sorts the tallies by size, largest first.
+func sortTallies(m map[string]*gcTally) (tallies []*gcTally) {
+	for _, t := range m {
+		tallies = append(tallies, t)
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].size != tallies[j].size {
+			return tallies[i].size > tallies[j].size
+		}
+		return tallies[i].name < tallies[j].name
+	})
+	return
+}
+
+// mb formats a number of bytes as megabytes.
+func mb(size int) string {
+	return fmt.Sprintf("%0.3f MB", float64(size)/units.Mb)
+}
+
+// WriteGCReport writes the totals of a garbage collector plan, and the events
+// it would prune by kind and by the top authors.
+func WriteGCReport(w io.Writer, db *badger.Backend, plan *badger.GCPlan,
+	top int) (err error) {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := func(format string, a ...any) { _, _ = fmt.Fprintf(tw, format, a...) }
+	p("garbage collector report for %s\n\n", db.Path)
+	if db.DBSizeLimit > 0 {
+		hw, lw := db.GetEventHeadroom()
+		p("size limit\t%s, high water %s, low water %s\n",
+			mb(db.DBSizeLimit), mb(hw), mb(lw))
+	} else {
+		p("size limit\tnone, only retention rules prune events\n")
+	}
+	bySerial := make(map[uint64]*count.Item, len(plan.Unpruned))
+	for _, it := range plan.Unpruned {
+		bySerial[it.Serial] = it
+	}
+	kinds := make(map[string]*gcTally)
+	authors := make(map[string]*gcTally)
+	var pruneSize int
+	for _, ser := range plan.PruneEvents {
+		it, ok := bySerial[ser]
+		if !ok {
+			continue
+		}
+		size := int(it.Size)
+		pruneSize += size
+		tally(kinds, fmt.Sprintf("%d %s", it.Kind, it.Kind.Name()), size)
+		tally(authors, hex.EncodeToString(it.PubKey[:]), size)
+	}
+	p("events\t%d using %s\n", len(plan.Unpruned), mb(plan.UnprunedTotal))
+	p("events to prune\t%d using %s, leaving %s\n", len(plan.PruneEvents),
+		mb(pruneSize), mb(plan.UnprunedTotal-pruneSize))
+	if db.HasL2 {
+		prunedBySerial := make(map[uint64]int, len(plan.Pruned))
+		for _, it := range plan.Pruned {
+			prunedBySerial[it.Serial] = int(it.Size)
+		}
+		var indexSize int
+		for _, ser := range plan.PruneIndexes {
+			indexSize += prunedBySerial[ser]
+		}
+		p("pruned events in L2\t%d using %s\n", len(plan.Pruned),
+			mb(plan.PrunedTotal))
+		p("L2 indexes to delete\t%d using %s\n", len(plan.PruneIndexes),
+			mb(indexSize))
+	}
+	if len(kinds) > 0 {
+		p("\nevents to prune by kind\n")
+		p("KIND\tEVENTS\tSIZE\n")
+		for _, t := range sortTallies(kinds) {
+			p("%s\t%d\t%s\n", t.name, t.events, mb(t.size))
+		}
+		p("\nevents to prune by author\n")
+		p("PUBKEY\tEVENTS\tSIZE\n")
+		tallies := sortTallies(authors)
+		for i, t := range tallies {
+			if i == top {
+				p("and %d more authors\n", len(tallies)-top)
+				break
+			}
+			p("%s\t%d\t%s\n", t.name, t.events, mb(t.size))
+		}
+	}
+	return tw.Flush()
+}
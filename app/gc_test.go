@@ -0,0 +1,130 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestGC(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	db := badger.GetBackend(c, &sync.WaitGroup{}, t.TempDir(), false, 0)
+	db.ManualGC = true
+	db.Retention = &badger.Retention{
+		MaxAge: map[kind.T]time.Duration{kind.TextNote: time.Hour},
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		db.WG.Wait()
+		db.Close()
+	}()
+	sec := keys.GeneratePrivateKey()
+	pub, _ := keys.GetPublicKey(sec)
+	now := timestamp.Now()
+	for _, ev := range []*event.T{
+		{Kind: kind.TextNote, CreatedAt: now - 7200, Content: "old"},
+		{Kind: kind.TextNote, CreatedAt: now, Content: "new"},
+		{Kind: kind.ProfileMetadata, CreatedAt: now - 7200, Content: "{}"},
+	} {
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveEvent(c, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rl := &Relay{}
+	for _, apply := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := rl.GC(db, &base.GCCmd{Apply: apply}, &buf); err != nil {
+			t.Fatal(err)
+		}
+		report := buf.String()
+		for _, s := range []string{"events to prune  1 ", "1 TextNote", pub} {
+			if !strings.Contains(report, s) {
+				t.Errorf("report does not contain '%s':\n%s", s, report)
+			}
+		}
+		if strings.Contains(report, "dry run") == apply {
+			t.Errorf("apply %v report:\n%s", apply, report)
+		}
+		plan, err := db.GCPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the dry run changes nothing
+		if expected := map[bool]int{false: 3, true: 2}[apply]; len(
+			plan.Unpruned) != expected {
+			t.Errorf("expected %d events after apply %v, got %d", expected,
+				apply, len(plan.Unpruned))
+		}
+	}
+}
+
+func TestGCPinRoles(t *testing.T) {
+	conf := &base.Config{SecKey: keys.GeneratePrivateKey(),
+		Retention: base.Retention{PinRoles: []string{"writer"},
+			MaxAge: map[int]time.Duration{1: time.Hour}}}
+	rl, db := newBadgerRelay(t, conf)
+	writerSec, otherSec := keys.GeneratePrivateKey(), keys.GeneratePrivateKey()
+	writer, _ := keys.GetPublicKey(writerSec)
+	if _, err := rl.SetRole(rl.Ctx, writer, acl.Writer, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	old := timestamp.Now() - 7200
+	kept := &event.T{Kind: kind.TextNote, CreatedAt: old, Content: "kept"}
+	pruned := &event.T{Kind: kind.TextNote, CreatedAt: old, Content: "pruned"}
+	for ev, sec := range map[*event.T]string{kept: writerSec,
+		pruned: otherSec} {
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveEvent(rl.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the gc subcommand starts with only the owners from the configuration
+	// and loads the stored ACL
+	gc := NewRelay(rl.Ctx, rl.Cancel, &relayinfo.T{}, conf)
+	gc.QueryEvents = append(gc.QueryEvents, db.QueryEvents)
+	if err := gc.LoadACL(gc.Ctx); err != nil {
+		t.Fatal(err)
+	}
+	db.SetRetention(gc.NewRetention(conf))
+	var buf bytes.Buffer
+	if err := gc.GC(db, &base.GCCmd{Apply: true}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for ev, count := range map[*event.T]int{kept: 1, pruned: 0} {
+		ch, err := db.QueryEvents(db.Ctx, &filter.T{
+			IDs: tag.T{ev.ID.String()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for range ch {
+			n++
+		}
+		if n != count {
+			t.Errorf("expected %d '%s' events, got %d:\n%s", count,
+				ev.Content, n, buf.String())
+		}
+	}
+}
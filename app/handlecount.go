@@ -1,7 +1,9 @@
 package app
 
 import (
+	"errors"
 	"strings"
+	"time"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/noticeenvelope"
@@ -10,10 +12,26 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
 )
 
+// ExactCount adapts the CountEvents method of an event store that always
+// counts exactly to the CountEvents hook.
+func ExactCount(count func(c context.T, f *filter.T) (int,
+	error)) CountEvents {
+
+	return func(c context.T, f *filter.T) (cnt int, approx bool, err error) {
+		cnt, err = count(c, f)
+		return
+	}
+}
+
 func (rl *Relay) handleCountRequest(c context.T, id subscriptionid.T,
-	ws *relayws.WebSocket, f *filter.T) (subtotal int) {
+	ws *relayws.WebSocket, f *filter.T) (subtotal int, approx bool, err error) {
 
 	log.T.Ln("running count method")
+	if ok, reason := rl.CanRead(ws); !ok {
+		err = errors.New(reason)
+		log.D.Ln(err, ws.RealRemote(), ws.AuthPubKey())
+		return
+	}
 	// overwrite the filter (for example, to eliminate some kinds or tags that we
 	// know we don't support)
 	for _, ovw := range rl.OverwriteCountFilter {
@@ -22,21 +40,29 @@ func (rl *Relay) handleCountRequest(c context.T, id subscriptionid.T,
 	// then check if we'll reject this filter
 	for _, reject := range rl.RejectCountFilter {
 		if rej, msg := reject(c, id, f); rej {
+			if IsShadowRejected(msg) {
+				return 0, false, nil
+			}
 			chk.E(ws.WriteEnvelope(&noticeenvelope.T{Text: msg}))
-			return 0
+			return 0, false, nil
 		}
 	}
 	// run the functions to count (generally it will be just one)
-	var err error
 	var res int
 	for _, count := range rl.CountEvents {
-		if res, err = count(c, f); err != nil {
-			if strings.HasSuffix(err.Error(), "No events found") {
-				log.E.Ln(err.Error())
+		var a bool
+		var cErr error
+		start := time.Now()
+		res, a, cErr = count(c, f)
+		rl.Metrics.Observe("count", start)
+		if cErr != nil {
+			if strings.HasSuffix(cErr.Error(), "No events found") {
+				log.E.Ln(cErr.Error())
 			}
-			chk.E(ws.WriteEnvelope(&noticeenvelope.T{Text: err.Error()}))
+			chk.E(ws.WriteEnvelope(&noticeenvelope.T{Text: cErr.Error()}))
 		}
 		subtotal += res
+		approx = approx || a
 	}
 	return
 }
@@ -1,81 +1,151 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
 )
 
-// handleDeleteRequest handles a delete event (kind 5)
+// handleDeleteRequest handles a delete event (kind 5) as per NIP-09.
+//
+// The events referenced by `e` tags, and the versions of replaceable events
+// referenced by `a` tags that were created up to the time of the deletion
+// event, are deleted along with all their indexes. The event stores keep a
+// tombstone so they will not be accepted again, and the deletion event itself
+// is stored. Events referenced by `e` tags that are not in the event store get
+// a tombstone that only rejects them if they are by the author of the deletion
+// event, as their author is not known.
+//
+// Users can only delete their own events, except owners and admins, according
+// to CanDelete. If any of the referenced events cannot be deleted, nothing is
+// deleted. Nothing is deleted either if AddEvent does not accept the deletion
+// event, which checks that the user can write to the relay.
 func (rl *Relay) handleDeleteRequest(c context.T, evt *event.T) (err error) {
-	// log.I.Ln("event delete", evt.ToObject().String())
-	// event deletion -- nip-09
-	go func() {
-		for _, t := range evt.Tags {
-			if len(t) >= 2 && t[0] == "e" {
-				// log.I.Ln("delete event tag", t)
-				// first we fetch the event
-				for _, query := range rl.QueryEvents {
-					var ch chan *event.T
-					if ch, err = query(c, &filter.T{IDs: tag.T{t[1]}}); chk.E(err) {
-						continue
-					}
-					// log.I.Ln("waiting for result")
-					target := <-ch
-					if target == nil {
-						continue
-					}
-					// log.I.Ln("got result", ok, target.ToObject().String())
-					// got the event, now check if the user can delete it
-					acceptDeletion := target.PubKey == evt.PubKey
-					// todo: enable administrators to do this
-					var msg string
-					if acceptDeletion == false {
-						msg = "you are not the author of this event"
-					}
-					// but if we have a function to override this outcome, use that
-					// instead
-					for _, odo := range rl.OverrideDeletion {
-						var override bool
-						override, msg = odo(c, target, evt)
-						// if any override rejects, do not delete
-						acceptDeletion = acceptDeletion && override
-					}
-					// at this point only if the pubkey matches AND the overrides
-					// all accept will the deletion be performed.
-					if acceptDeletion {
-						// delete it
-						for _, del := range rl.DeleteEvent {
-							chk.E(del(c, target))
-						}
-					} else {
-						// fail and stop here
-						err = fmt.Errorf("blocked: %s", msg)
-						// log.E.Ln(err)
-						return
-					}
-					// don't try to query this same event again
-					break
+	var targets []*event.T
+	var addresses []string
+	var missing []eventid.T
+	for _, t := range evt.Tags {
+		if len(t) < 2 {
+			continue
+		}
+		switch t[0] {
+		case "e":
+			// an invalid event ID cannot match any event
+			evID, idErr := eventid.New(t[1])
+			if idErr != nil {
+				continue
+			}
+			found := rl.queryAll(c, &filter.T{IDs: tag.T{t[1]}})
+			var exists bool
+			for _, target := range found {
+				if target.ID != evID {
+					continue
+				}
+				exists = true
+				// deleting a deletion has no effect
+				if target.Kind == kind.Deletion {
+					continue
+				}
+				if !rl.CanDelete(evt.PubKey, target.PubKey) {
+					return errors.New(normalize.Reason(fmt.Sprintf(
+						"you are not the author of event %s", target.ID),
+						okenvelope.Blocked.S()))
+				}
+				targets = append(targets, target)
+			}
+			if !exists {
+				missing = append(missing, evID)
+			}
+		case "a":
+			k, pkb, d := eventstore.GetAddrTagElements(t[1])
+			ki := kind.T(k)
+			if pkb == nil || !(ki.IsReplaceable() ||
+				ki.IsParameterizedReplaceable()) {
+				return errors.New(normalize.Reason(fmt.Sprintf(
+					"'%s' is not the address of a replaceable event", t[1]),
+					okenvelope.Invalid.S()))
+			}
+			pub := hex.Enc(pkb)
+			if !rl.CanDelete(evt.PubKey, pub) {
+				return errors.New(normalize.Reason(fmt.Sprintf(
+					"you are not the author of %s", t[1]),
+					okenvelope.Blocked.S()))
+			}
+			found := rl.queryAll(c, &filter.T{
+				Kinds:   kinds.T{ki},
+				Authors: tag.T{pub},
+				Until:   evt.CreatedAt.Ptr(),
+			})
+			address := fmt.Sprintf("%d:%s:%s", k, pub, d)
+			for _, target := range found {
+				if eventstore.GetAddress(target) != address {
+					continue
 				}
+				targets = append(targets, target)
 			}
+			addresses = append(addresses, address)
 		}
-	}()
-	return nil
+	}
+	// if we have functions to override the outcome, any of them can veto the
+	// deletion
+	for _, target := range targets {
+		for _, odo := range rl.OverrideDeletion {
+			if ok, msg := odo(c, target, evt); !ok {
+				return errors.New(normalize.Reason(msg, okenvelope.Blocked.S()))
+			}
+		}
+	}
+	// store the deletion event first so that the deletion is not performed if
+	// the event is rejected.
+	if err = rl.AddEvent(c, evt); err != nil {
+		return
+	}
+	for _, target := range targets {
+		log.D.F("deleting event %s by %s at request of %s", target.ID,
+			target.PubKey, evt.PubKey)
+		for _, del := range rl.DeleteEvent {
+			chk.E(del(c, target))
+		}
+	}
+	for _, evID := range missing {
+		log.D.F("tombstoning event %s not in the event store at request of %s",
+			evID, evt.PubKey)
+		for _, tomb := range rl.TombstoneEventID {
+			chk.E(tomb(c, evID, evt.PubKey))
+		}
+	}
+	for _, address := range addresses {
+		for _, tomb := range rl.TombstoneAddress {
+			chk.E(tomb(c, address, evt.CreatedAt))
+		}
+	}
+	return
 }
 
-// OverrideDelete decides whether to veto a delete event.
-//
-// Temporarily removing delete functionality until a proper tombstone/indexing
-// strategy is devised to filter out these events from database results.
-func (rl *Relay) OverrideDelete(c context.T, tgt, del *event.T) (ok bool,
-	msg string) {
-	log.T.Ln("overriding delete")
-	msg = normalize.Reason("not actually deleting", okenvelope.Blocked.S())
-	ok = false
+// queryAll runs a filter on all the QueryEvents functions and returns all of
+// the results. Errors from individual queries are logged and skipped.
+func (rl *Relay) queryAll(c context.T, f *filter.T) (evs []*event.T) {
+	for _, query := range rl.QueryEvents {
+		ch, err := query(c, f)
+		if chk.E(err) {
+			continue
+		}
+		for ev := range ch {
+			if ev != nil {
+				evs = append(evs, ev)
+			}
+		}
+	}
 	return
 }
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestDeleteMissingTarget(t *testing.T) {
+	rl, db := newBadgerRelay(t, &base.Config{})
+	rl.TombstoneEventID = append(rl.TombstoneEventID, db.TombstoneEventID)
+	author, other := keys.GeneratePrivateKey(), keys.GeneratePrivateKey()
+	authorPub, _ := keys.GetPublicKey(author)
+	now := timestamp.Now()
+	sign := func(sec string, ev *event.T) *event.T {
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	// a note that reaches the relay only after its deletion
+	late := sign(author, &event.T{Kind: kind.TextNote, CreatedAt: now,
+		Content: "late"})
+	// and one deleted by someone who did not write it
+	notTheirs := sign(author, &event.T{Kind: kind.TextNote, CreatedAt: now + 1,
+		Content: "not theirs"})
+	ws := &relayws.WebSocket{}
+	ws.SetAuthPubKey(authorPub)
+	c := context.Value(rl.Ctx, wsKey, ws)
+	for _, del := range []*event.T{
+		sign(author, &event.T{Kind: kind.Deletion, CreatedAt: now + 2,
+			Tags: tags.T{{"e", late.ID.String()}}}),
+		sign(other, &event.T{Kind: kind.Deletion, CreatedAt: now + 2,
+			Tags: tags.T{{"e", notTheirs.ID.String()}}}),
+	} {
+		if err := rl.handleDeleteRequest(c, del); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveEvent(rl.Ctx, late); !errors.Is(err,
+		eventstore.ErrEventDeleted) {
+		t.Errorf("expected event deleted before it arrived to be rejected, "+
+			"got %v", err)
+	}
+	if err := db.SaveEvent(rl.Ctx, notTheirs); err != nil {
+		t.Errorf("expected event deleted by another user to be saved, got %v",
+			err)
+	}
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eventenvelope"
@@ -13,6 +14,7 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/search"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
 )
@@ -41,16 +43,26 @@ func (rl *Relay) handleFilter(h handleFilterParams) (err error) {
 	if !rl.IsAuthed(h.c, "filter") {
 		return
 	}
+	if ok, reason := rl.CanRead(h.ws); !ok {
+		err = errors.New(reason)
+		log.D.Ln(err, h.ws.RealRemote(), h.ws.AuthPubKey())
+		return
+	}
 	// then check if we'll reject this filter (we apply this after overwriting
 	// because we may, for example, remove some things from the incoming filters
 	// that we know we don't support, and then if the end result is an empty
 	// filter we can just reject it)
 	for _, reject := range rl.RejectFilter {
 		if rej, msg := reject(h.c, h.id, h.f); rej {
+			if IsShadowRejected(msg) {
+				return ErrShadowRejected
+			}
 			return log.D.Err("%s %s", normalize.Reason(msg, "blocked"),
 				h.ws.AuthPubKey())
 		}
 	}
+	// search results from spammers are hidden unless asked for
+	hideSpam := h.f.Search != "" && !search.Parse(h.f.Search).IncludeSpam
 	// run the functions to query events (generally just one, but we might be
 	// fetching stuff from multiple places)
 	for _, query := range rl.QueryEvents {
@@ -63,6 +75,7 @@ func (rl *Relay) handleFilter(h handleFilterParams) (err error) {
 				kindStrings = append(kindStrings, kind.GetString(ks))
 			}
 		}
+		start := time.Now()
 		if ch, err = query(h.c, h.f); chk.E(err) {
 			h.ws.OffenseCount.Inc()
 			chk.E(h.ws.WriteEnvelope(&noticeenvelope.T{Text: err.Error()}))
@@ -77,15 +90,23 @@ func (rl *Relay) handleFilter(h handleFilterParams) (err error) {
 					// accessing the nonexistent event's fields
 					if ev == nil {
 						// log.T.Ln("query result channel closed")
+						rl.Metrics.Observe("req", start)
 						break out
 					}
 					log.T.Ln("received result", ev.ToObject().String())
+					if hideSpam && rl.IsSpammer(ev.PubKey) {
+						continue
+					}
 					for _, ovw := range rl.OverwriteResponseEvent {
 						ovw(h.c, ev)
 					}
-					if kinds.IsPrivileged(ev.Kind) && rl.Info.Limitation.AuthRequired {
+					if kinds.IsGiftWrap(ev.Kind) &&
+						!rl.IsGiftWrapRecipient(h.ws, ev) {
+						continue
+					}
+					if kinds.IsPrivileged(ev.Kind) && rl.Info().Limitation.AuthRequired {
 						var allow bool
-						for _, v := range rl.Config.AllowIPs {
+						for _, v := range rl.Config().AllowIPs {
 							if h.ws.RealRemote() == v {
 								allow = true
 								break
@@ -113,7 +134,7 @@ func (rl *Relay) handleFilter(h handleFilterParams) (err error) {
 								parties = append(parties, pTags[i][1])
 							}
 							if !parties.Contains(h.ws.AuthPubKey()) &&
-								rl.Info.Limitation.AuthRequired {
+								rl.Info().Limitation.AuthRequired {
 								log.D.Ln("not broadcasting privileged event to",
 									h.ws.RealRemote(), h.ws.AuthPubKey(),
 									"not party to event")
@@ -10,7 +10,7 @@ func (rl *Relay) HandleNIP11(w http.ResponseWriter, r *http.Request) {
 	var err error
 	log.T.Ln("NIP-11 request", getServiceBaseURL(r))
 	w.Header().Set("Content-Type", "application/nostr+json")
-	info := rl.Info
+	info := rl.Info()
 	for _, ovw := range rl.OverwriteRelayInfo {
 		info = ovw(r.Context(), r, info)
 	}
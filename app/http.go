@@ -52,6 +52,9 @@ func (rl *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else if r.Header.Get("Accept") == "application/nostr+json" {
 		cors.AllowAll().Handler(http.HandlerFunc(rl.HandleNIP11)).
 			ServeHTTP(w, r)
+	} else if r.Header.Get("Content-Type") == ManagementContentType {
+		cors.AllowAll().Handler(http.HandlerFunc(rl.HandleManagement)).
+			ServeHTTP(w, r)
 	} else {
 		rl.serveMux.ServeHTTP(w, r)
 	}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filters"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+// CheckEventLimits checks an event against the limitations advertised in the
+// relay information document, returning a machine readable reason if it
+// exceeds any of them. Limits that are zero are not enforced.
+//
+// The created_at_lower_limit is an absolute timestamp, while the
+// created_at_upper_limit is the number of seconds into the future an event may
+// be dated.
+//
+// The min_pow_difficulty is not checked here, as it is only advertised for
+// unauthenticated clients, and is enforced by RequirePoW.
+func (rl *Relay) CheckEventLimits(ev *event.T) (ok bool, reason string) {
+	lim := rl.Info().Limitation
+	switch {
+	case ev.CreatedAt <= lim.Oldest:
+		return false, normalize.Reason(fmt.Sprintf(
+			"relay limit disallows timestamps older than %d", lim.Oldest),
+			okenvelope.Invalid.S())
+	case lim.Newest > 0 && ev.CreatedAt > timestamp.Now()+lim.Newest:
+		return false, normalize.Reason(fmt.Sprintf(
+			"relay limit disallows timestamps more than %d seconds in the "+
+				"future", lim.Newest), okenvelope.Invalid.S())
+	case lim.MaxEventTags > 0 && len(ev.Tags) > lim.MaxEventTags:
+		return false, normalize.Reason(fmt.Sprintf(
+			"relay limit disallows more than %d tags, event has %d",
+			lim.MaxEventTags, len(ev.Tags)), okenvelope.Invalid.S())
+	case lim.MaxContentLength > 0 &&
+		utf8.RuneCountInString(ev.Content) > lim.MaxContentLength:
+		return false, normalize.Reason(fmt.Sprintf(
+			"relay limit disallows content longer than %d characters",
+			lim.MaxContentLength), okenvelope.Invalid.S())
+	}
+	return true, ""
+}
+
+// CheckReqLimits checks the subscription ID and filters of a REQ or COUNT
+// against the limitations advertised in the relay information document,
+// returning a machine readable reason if they exceed any of them. Filter limits
+// above the max_limit are clamped to it. Limits that are zero are not
+// enforced.
+func (rl *Relay) CheckReqLimits(id subscriptionid.T,
+	ff filters.T) (ok bool, reason string) {
+
+	lim := rl.Info().Limitation
+	if lim.MaxSubidLength > 0 && len(id) > lim.MaxSubidLength {
+		return false, normalize.Reason(fmt.Sprintf(
+			"relay limit disallows subscription ids longer than %d",
+			lim.MaxSubidLength), okenvelope.Invalid.S())
+	}
+	if lim.MaxFilters > 0 && len(ff) > lim.MaxFilters {
+		return false, normalize.Reason(fmt.Sprintf(
+			"relay limit disallows more than %d filters, request has %d",
+			lim.MaxFilters, len(ff)), okenvelope.Invalid.S())
+	}
+	if lim.MaxLimit > 0 {
+		for _, f := range ff {
+			if f.Limit != nil && *f.Limit > lim.MaxLimit {
+				limit := lim.MaxLimit
+				f.Limit = &limit
+			}
+		}
+	}
+	return true, ""
+}
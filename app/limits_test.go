@@ -0,0 +1,145 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filters"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestCheckEventLimits(t *testing.T) {
+	rl := &Relay{}
+	rl.SetInfo(&relayinfo.T{Limitation: relayinfo.Limits{
+		MaxEventTags:     1,
+		MaxContentLength: 3,
+		MinPowDifficulty: 8,
+		Newest:           60,
+	}})
+	id := "00ff000000000000000000000000000000000000000000000000000000000000"
+	now := timestamp.Now()
+	for i, tc := range []struct {
+		ev     *event.T
+		prefix string
+	}{
+		{&event.T{ID: eventid.T(id), CreatedAt: now, Content: "äöü"}, ""},
+		{&event.T{ID: eventid.T(id), CreatedAt: now + 120}, "invalid: "},
+		{&event.T{ID: eventid.T(id), CreatedAt: now,
+			Tags: tags.T{{"t", "a"}, {"t", "b"}}}, "invalid: "},
+		{&event.T{ID: eventid.T(id), CreatedAt: now, Content: "abcd"},
+			"invalid: "},
+		// proof of work is checked by RequirePoW
+		{&event.T{ID: eventid.T("ff" + id[2:]), CreatedAt: now}, ""},
+	} {
+		ok, reason := rl.CheckEventLimits(tc.ev)
+		if ok != (tc.prefix == "") || !strings.HasPrefix(reason, tc.prefix) {
+			t.Errorf("%d: expected '%s' got %v '%s'", i, tc.prefix, ok, reason)
+		}
+	}
+}
+
+func TestRequirePoW(t *testing.T) {
+	writer, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	relay, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	rl := &Relay{RelayPubHex: relay, ACL: &acl.T{}}
+	rl.SetConfig(&base.Config{
+		PowDifficulty: map[string]base.PowLimit{
+			"none":   {Difficulty: 8, Kinds: map[int]int{7: 0, 4: 16}},
+			"writer": {Kinds: map[int]int{4: 16}},
+		}})
+	if err := rl.ACL.AddEntry(&acl.Entry{Role: acl.Writer,
+		Pubkey: writer}); err != nil {
+		t.Fatal(err)
+	}
+	anon, member := &relayws.WebSocket{}, &relayws.WebSocket{}
+	member.SetAuthPubKey(writer)
+	id8 := "00ff000000000000000000000000000000000000000000000000000000000000"
+	id16 := "0000ff0000000000000000000000000000000000000000000000000000000000"
+	nonce := func(target string) tags.T {
+		return tags.T{{"nonce", "1", target}}
+	}
+	for i, tc := range []struct {
+		ws     *relayws.WebSocket
+		ev     *event.T
+		reject bool
+	}{
+		{anon, &event.T{ID: eventid.T(id8), Kind: 1, Tags: nonce("8")}, false},
+		{anon, &event.T{ID: eventid.T(id16), Kind: 1, Tags: nonce("8")}, false},
+		{anon, &event.T{ID: eventid.T("ff" + id8[2:]), Kind: 1,
+			Tags: nonce("8")}, true},
+		// the target must be committed to
+		{anon, &event.T{ID: eventid.T(id16), Kind: 1}, true},
+		{anon, &event.T{ID: eventid.T(id16), Kind: 1, Tags: nonce("4")}, true},
+		// kinds can have their own minimum
+		{anon, &event.T{ID: eventid.T("ff" + id8[2:]), Kind: 7}, false},
+		{anon, &event.T{ID: eventid.T(id8), Kind: 4, Tags: nonce("8")}, true},
+		{anon, &event.T{ID: eventid.T(id16), Kind: 4, Tags: nonce("16")},
+			false},
+		// members need proof of work only for the kinds configured for them
+		{member, &event.T{ID: eventid.T("ff" + id8[2:]), Kind: 1}, false},
+		{member, &event.T{ID: eventid.T(id8), Kind: 4, Tags: nonce("8")},
+			true},
+		// direct messages to the relay chat are exempt, but only when the
+		// relay is the only recipient
+		{anon, &event.T{ID: eventid.T(id8), Kind: 4,
+			Tags: tags.T{{"p", relay}}}, false},
+		{anon, &event.T{ID: eventid.T(id8), Kind: 4,
+			Tags: tags.T{{"p", relay}, {"p", writer}}}, true},
+	} {
+		c := context.Value(context.Bg(), wsKey, tc.ws)
+		reject, msg := rl.RequirePoW(c, tc.ev)
+		if reject != tc.reject || (reject && !strings.HasPrefix(msg,
+			"pow: ")) {
+			t.Errorf("%d: expected reject %v, got %v '%s'", i, tc.reject,
+				reject, msg)
+		}
+	}
+}
+
+func TestCheckReqLimits(t *testing.T) {
+	rl := &Relay{}
+	rl.SetInfo(&relayinfo.T{Limitation: relayinfo.Limits{
+		MaxFilters:     2,
+		MaxLimit:       10,
+		MaxSubidLength: 4,
+	}})
+	limit := 100
+	ff := filters.T{{Limit: &limit}}
+	if ok, reason := rl.CheckReqLimits("sub", ff); !ok {
+		t.Fatal(reason)
+	}
+	if *ff[0].Limit != 10 {
+		t.Fatalf("limit not clamped, got %d", *ff[0].Limit)
+	}
+	if ok, _ := rl.CheckReqLimits("subscription", ff); ok {
+		t.Fatal("long subscription id accepted")
+	}
+	if ok, _ := rl.CheckReqLimits("sub", filters.T{{}, {}, {}}); ok {
+		t.Fatal("too many filters accepted")
+	}
+}
+
+func TestSetListenerMax(t *testing.T) {
+	ws := &relayws.WebSocket{}
+	defer RemoveListener(ws)
+	_, cancel := context.CancelCause(context.Bg())
+	ff := filters.T{&filter.T{}}
+	for _, id := range []string{"a", "b", "a"} {
+		if err := SetListener(id, ws, ff, cancel, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetListener("c", ws, ff, cancel, 2); err == nil {
+		t.Fatal("subscription over the limit accepted")
+	}
+}
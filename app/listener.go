@@ -1,11 +1,14 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filters"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
 	"github.com/puzpuzpuz/xsync/v2"
 )
@@ -47,12 +50,28 @@ func GetListeningFilters() (respFilters filters.T) {
 	return
 }
 
-// SetListener adds a filter to a connection.
-func SetListener(id string, ws *relayws.WebSocket, f filters.T, c context.C) {
+// SetListener adds a filter to a connection, replacing any subscription with
+// the same id. If max is greater than zero and the connection already has max
+// other subscriptions, the filter is not added and an error with a machine
+// readable reason is returned.
+func SetListener(id string, ws *relayws.WebSocket, f filters.T, c context.C,
+	max int) (err error) {
+
 	subs, _ := listeners.LoadOrCompute(ws, func() ListenerMap {
 		return xsync.NewMapOf[*Listener]()
 	})
+	prev, replacing := subs.Load(id)
+	if max > 0 && !replacing && subs.Size() >= max {
+		return errors.New(normalize.Reason(fmt.Sprintf(
+			"relay limit disallows more than %d subscriptions", max),
+			okenvelope.Blocked.S()))
+	}
+	if replacing {
+		prev.cancel(fmt.Errorf("subscription replaced by client"))
+	}
 	subs.Store(id, &Listener{filters: f, cancel: c, ws: ws})
+	matches.Set(ws, id, f)
+	return
 }
 
 // RemoveListenerId removes a specific subscription id from listeners for a
@@ -62,6 +81,7 @@ func RemoveListenerId(ws *relayws.WebSocket, id string) {
 		if listener, ok := subs.LoadAndDelete(id); ok {
 			listener.cancel(fmt.Errorf("subscription closed by client"))
 		}
+		matches.Remove(ws, id)
 		if subs.Size() == 0 {
 			listeners.Delete(ws)
 		}
@@ -70,4 +90,11 @@ func RemoveListenerId(ws *relayws.WebSocket, id string) {
 
 // RemoveListener removes WebSocket conn from listeners (no need to cancel
 // contexts as they are all inherited from the main connection context)
-func RemoveListener(ws *relayws.WebSocket) { listeners.Delete(ws) }
+func RemoveListener(ws *relayws.WebSocket) {
+	if subs, ok := listeners.LoadAndDelete(ws); ok {
+		subs.Range(func(id string, _ *Listener) bool {
+			matches.Remove(ws, id)
+			return true
+		})
+	}
+}
@@ -0,0 +1,625 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/auth"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+)
+
+const (
+	// ManagementContentType is the content type of NIP-86 relay management
+	// requests.
+	ManagementContentType = "application/nostr+json+rpc"
+	// PermanentBan is the duration of bans made through the management API,
+	// which do not expire.
+	PermanentBan = 100 * 365 * 24 * time.Hour
+	// MaxModerationReports is the maximum number of the most recent reports
+	// that are searched for events needing moderation.
+	MaxModerationReports = 500
+)
+
+// ManagementRequest is a NIP-86 JSON-RPC request.
+type ManagementRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// ManagementResponse is the reply to a ManagementRequest, the Error is set if
+// the request failed.
+type ManagementResponse struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// The items of the lists returned by the management API.
+type (
+	PubkeyReason struct {
+		Pubkey string `json:"pubkey"`
+		Reason string `json:"reason,omitempty"`
+	}
+	EventReason struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason,omitempty"`
+	}
+	IPReason struct {
+		IP     string `json:"ip"`
+		Reason string `json:"reason,omitempty"`
+	}
+)
+
+// ManagementMethod is the implementation of a NIP-86 method, called with the
+// pubkey of the owner or admin that signed the request.
+type ManagementMethod func(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error)
+
+// ManagementMethods are the NIP-86 methods supported by the relay.
+var ManagementMethods map[string]ManagementMethod
+
+func init() {
+	ManagementMethods = map[string]ManagementMethod{
+		"supportedmethods":            supportedMethods,
+		"banpubkey":                   banPubkey,
+		"listbannedpubkeys":           listBannedPubkeys,
+		"allowpubkey":                 allowPubkey,
+		"listallowedpubkeys":          listAllowedPubkeys,
+		"listeventsneedingmoderation": listEventsNeedingModeration,
+		"banevent":                    banEvent,
+		"allowevent":                  allowEvent,
+		"listbannedevents":            listBannedEvents,
+		"allowkind":                   allowKind,
+		"disallowkind":                disallowKind,
+		"listallowedkinds":            listAllowedKinds,
+		"blockip":                     blockIP,
+		"unblockip":                   unblockIP,
+		"listblockedips":              listBlockedIPs,
+		"changerelayname":             changeRelayName,
+		"changerelaydescription":      changeRelayDescription,
+		"changerelayicon":             changeRelayIcon,
+	}
+}
+
+// HandleManagement serves the NIP-86 relay management API.
+//
+// Requests must carry a NIP-98 Authorization header signed by an owner or
+// admin of the relay for the URL and body of the request.
+func (rl *Relay) HandleManagement(w http.ResponseWriter, r *http.Request) {
+	respond := func(status int, res *ManagementResponse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		chk.E(json.NewEncoder(w).Encode(res))
+	}
+	if r.Method != http.MethodPost {
+		respond(http.StatusMethodNotAllowed,
+			&ManagementResponse{Error: "only POST is supported"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, rl.MaxMessageSize))
+	if chk.E(err) {
+		respond(http.StatusBadRequest,
+			&ManagementResponse{Error: "failed to read request"})
+		return
+	}
+	pub, ok, err := auth.ValidateHTTP(r.Header.Get("Authorization"),
+		getServiceBaseURL(r)+r.URL.Path, r.Method, body)
+	if !ok {
+		msg := "NIP-98 authorization required"
+		if err != nil {
+			msg = err.Error()
+		}
+		respond(http.StatusUnauthorized, &ManagementResponse{Error: msg})
+		return
+	}
+	if role := rl.ACL.GetRole(pub); role != acl.Owner && role != acl.Admin {
+		log.D.F("refusing management request from %s with role %s", pub,
+			acl.RoleStrings[role])
+		respond(http.StatusForbidden, &ManagementResponse{
+			Error: "only owners and admins can manage the relay"})
+		return
+	}
+	var req ManagementRequest
+	if err = json.Unmarshal(body, &req); chk.D(err) {
+		respond(http.StatusBadRequest,
+			&ManagementResponse{Error: "invalid request: " + err.Error()})
+		return
+	}
+	method, ok := ManagementMethods[strings.ToLower(req.Method)]
+	if !ok {
+		respond(http.StatusOK, &ManagementResponse{
+			Error: fmt.Sprintf("unsupported method '%s'", req.Method)})
+		return
+	}
+	log.I.F("management method %s called by %s", req.Method, pub)
+	var res ManagementResponse
+	if res.Result, err = method(rl, pub, req.Params); err != nil {
+		res.Result, res.Error = nil, err.Error()
+	}
+	respond(http.StatusOK, &res)
+}
+
+// param decodes the parameter at index i into v.
+func param(params []json.RawMessage, i int, v any) (err error) {
+	if i >= len(params) {
+		return log.D.Err("missing parameter %d", i+1)
+	}
+	if err = json.Unmarshal(params[i], v); err != nil {
+		return log.D.Err("invalid parameter %d: %s", i+1, err)
+	}
+	return
+}
+
+// optionalParam decodes the parameter at index i into v if it is present.
+func optionalParam(params []json.RawMessage, i int, v any) (err error) {
+	if i >= len(params) {
+		return
+	}
+	return param(params, i, v)
+}
+
+// pubkeyReasonParams decodes the pubkey and optional reason parameters.
+func pubkeyReasonParams(params []json.RawMessage) (pub, reason string,
+	err error) {
+
+	var s string
+	if err = param(params, 0, &s); err != nil {
+		return
+	}
+	if pub, err = parsePubkey(s); err != nil {
+		err = log.D.Err("invalid public key '%s'", s)
+		return
+	}
+	err = optionalParam(params, 1, &reason)
+	return
+}
+
+// saveConfig sets fields of the configuration and relay information document
+// files, if they are set, by their JSON names. The rest of the files are left
+// as they are, so values that only apply at runtime, such as those given on the
+// command line, are not written to them.
+func (rl *Relay) saveConfig(conf, info map[string]any) (err error) {
+	if rl.ConfigPath != "" && len(conf) > 0 {
+		if err = updateJSONFile(rl.ConfigPath, conf); chk.E(err) {
+			return
+		}
+	}
+	if rl.InfoPath != "" && len(info) > 0 {
+		if err = updateJSONFile(rl.InfoPath, info); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// updateJSONFile sets fields of the JSON object in a file, creating it if it
+// does not exist. Fields set to nil are removed.
+func updateJSONFile(path string, fields map[string]any) (err error) {
+	obj := make(map[string]json.RawMessage)
+	var b []byte
+	if b, err = os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(b, &obj); chk.E(err) {
+			return
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		chk.E(err)
+		return
+	}
+	for key, value := range fields {
+		var v []byte
+		if v, err = json.Marshal(value); chk.E(err) {
+			return
+		}
+		if string(v) == "null" {
+			delete(obj, key)
+			continue
+		}
+		obj[key] = v
+	}
+	if b, err = json.MarshalIndent(obj, "", "    "); chk.E(err) {
+		return
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+func supportedMethods(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	methods := make([]string, 0, len(ManagementMethods))
+	for name := range ManagementMethods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	return methods, nil
+}
+
+func banPubkey(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var pub, reason string
+	if pub, reason, err = pubkeyReasonParams(params); err != nil {
+		return
+	}
+	// only owners may ban administrators
+	if role := rl.ACL.GetRole(pub); role == acl.Owner ||
+		(role == acl.Admin && rl.ACL.GetRole(caller) != acl.Owner) {
+		err = log.D.Err("cannot ban %s users", acl.RoleStrings[role])
+		return
+	}
+	if _, err = rl.SetRole(rl.Ctx, pub, acl.Denied, 0, reason); err != nil {
+		return
+	}
+	rl.Disconnect(func(ws *relayws.WebSocket) bool {
+		return ws.AuthPubKey() == pub
+	})
+	return true, nil
+}
+
+func listBannedPubkeys(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	list := []PubkeyReason{}
+	for _, en := range rl.ACL.Entries(acl.Denied) {
+		if !en.Expired() {
+			list = append(list, PubkeyReason{en.Pubkey, en.Reason})
+		}
+	}
+	return list, nil
+}
+
+func allowPubkey(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var pub, reason string
+	if pub, reason, err = pubkeyReasonParams(params); err != nil {
+		return
+	}
+	switch rl.ACL.GetRole(pub) {
+	case acl.Owner, acl.Admin, acl.Writer:
+		// already allowed to write, don't demote them
+		return true, nil
+	}
+	if _, err = rl.SetRole(rl.Ctx, pub, acl.Writer, 0, reason); err != nil {
+		return
+	}
+	return true, nil
+}
+
+func listAllowedPubkeys(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	list := []PubkeyReason{}
+	for _, en := range rl.ACL.Entries(acl.Owner, acl.Admin, acl.Writer) {
+		if !en.Expired() {
+			list = append(list, PubkeyReason{en.Pubkey, en.Reason})
+		}
+	}
+	return list, nil
+}
+
+// listEventsNeedingModeration returns the events that have been reported with
+// NIP-56 reports and are still stored on the relay, with the reasons given in
+// the reports.
+func listEventsNeedingModeration(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	limit := MaxModerationReports
+	reports := rl.queryAll(rl.Ctx, &filter.T{Kinds: kinds.T{kind.Reporting},
+		Limit: &limit})
+	reasons := make(map[string][]string)
+	var ids tag.T
+	for _, rep := range reports {
+		for _, t := range rep.Tags.GetAll("e") {
+			if len(t) < 2 {
+				continue
+			}
+			id := t[1]
+			if _, ok := reasons[id]; !ok {
+				ids = append(ids, id)
+			}
+			reason := rep.Content
+			if len(t) > 2 && t[2] != "" {
+				reason = strings.TrimSpace(t[2] + " " + reason)
+			}
+			if reason != "" {
+				reasons[id] = append(reasons[id], reason)
+			} else if _, ok := reasons[id]; !ok {
+				reasons[id] = nil
+			}
+		}
+	}
+	list := []EventReason{}
+	if len(ids) == 0 {
+		return list, nil
+	}
+	// reports of events that have since been deleted need no moderation
+	stored := make(map[string]bool)
+	for _, ev := range rl.queryAll(rl.Ctx, &filter.T{IDs: ids}) {
+		stored[ev.ID.String()] = true
+	}
+	for _, id := range ids {
+		if stored[id] {
+			list = append(list, EventReason{id,
+				strings.Join(reasons[id], "; ")})
+		}
+	}
+	return list, nil
+}
+
+func banEvent(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var id, reason string
+	if err = param(params, 0, &id); err != nil {
+		return
+	}
+	if err = optionalParam(params, 1, &reason); err != nil {
+		return
+	}
+	var target *event.T
+	for _, ev := range rl.queryAll(rl.Ctx, &filter.T{IDs: tag.T{id}}) {
+		if ev.ID.String() == id {
+			target = ev
+			break
+		}
+	}
+	if target == nil {
+		err = log.D.Err("event %s not found", id)
+		return
+	}
+	if !rl.CanDelete(caller, target.PubKey) {
+		err = log.D.Err("cannot ban events of %s users",
+			acl.RoleStrings[rl.ACL.GetRole(target.PubKey)])
+		return
+	}
+	log.I.F("deleting event %s by %s at request of %s: %s", target.ID,
+		target.PubKey, caller, reason)
+	for _, del := range rl.DeleteEvent {
+		if err = del(rl.Ctx, target); chk.E(err) {
+			return
+		}
+	}
+	rl.BannedEvents.Ban(id, time.Now().Add(PermanentBan), reason)
+	return true, nil
+}
+
+// allowEvent lifts the ban on an event so it can be published again. The event
+// is not restored, as it was deleted when it was banned.
+func allowEvent(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var s, reason string
+	if err = param(params, 0, &s); err != nil {
+		return
+	}
+	if err = optionalParam(params, 1, &reason); err != nil {
+		return
+	}
+	var id eventid.T
+	if id, err = eventid.New(s); err != nil {
+		err = log.D.Err("invalid event ID '%s'", s)
+		return
+	}
+	log.I.F("allowing event %s at request of %s: %s", id, caller, reason)
+	if rl.Badger != nil {
+		if err = rl.Badger.RemoveTombstone(rl.Ctx, id); chk.E(err) {
+			return
+		}
+	}
+	rl.BannedEvents.Unban(id.String())
+	return true, nil
+}
+
+func listBannedEvents(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	list := []EventReason{}
+	for _, sp := range rl.BannedEvents.Banned() {
+		list = append(list, EventReason{sp.Address, sp.Reason})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// kindParam decodes a kind number parameter.
+func kindParam(params []json.RawMessage) (k int, err error) {
+	if err = param(params, 0, &k); err != nil {
+		return
+	}
+	if k < 0 || k > 65535 {
+		err = log.D.Err("invalid kind %d", k)
+	}
+	return
+}
+
+// addKind adds a kind to a list if it is not in it already. The list is
+// copied, as it is shared with the current configuration.
+func addKind(list []int, k int) []int {
+	for _, v := range list {
+		if v == k {
+			return list
+		}
+	}
+	return append(list[:len(list):len(list)], k)
+}
+
+// removeKind removes a kind from a list.
+func removeKind(list []int, k int) (out []int) {
+	for _, v := range list {
+		if v != k {
+			out = append(out, v)
+		}
+	}
+	return
+}
+
+// kindFields returns the configuration file fields changed by allowKind and
+// disallowKind.
+func kindFields(conf *base.Config) map[string]any {
+	return map[string]any{
+		"allowed_kinds":    conf.AllowedKinds,
+		"disallowed_kinds": conf.DisallowedKinds,
+	}
+}
+
+func allowKind(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var k int
+	if k, err = kindParam(params); err != nil {
+		return
+	}
+	rl.configMx.Lock()
+	defer rl.configMx.Unlock()
+	conf := *rl.Config()
+	conf.AllowedKinds = addKind(conf.AllowedKinds, k)
+	conf.DisallowedKinds = removeKind(conf.DisallowedKinds, k)
+	rl.SetConfig(&conf)
+	if err = rl.saveConfig(kindFields(&conf), nil); err != nil {
+		return
+	}
+	return true, nil
+}
+
+func disallowKind(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var k int
+	if k, err = kindParam(params); err != nil {
+		return
+	}
+	rl.configMx.Lock()
+	defer rl.configMx.Unlock()
+	conf := *rl.Config()
+	conf.AllowedKinds = removeKind(conf.AllowedKinds, k)
+	conf.DisallowedKinds = addKind(conf.DisallowedKinds, k)
+	rl.SetConfig(&conf)
+	if err = rl.saveConfig(kindFields(&conf), nil); err != nil {
+		return
+	}
+	return true, nil
+}
+
+func listAllowedKinds(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	return append([]int{}, rl.Config().AllowedKinds...), nil
+}
+
+// ipParam decodes an IP address parameter.
+func ipParam(params []json.RawMessage) (ip string, err error) {
+	var s string
+	if err = param(params, 0, &s); err != nil {
+		return
+	}
+	parsed := net.ParseIP(s)
+	if parsed == nil {
+		err = log.D.Err("invalid IP address '%s'", s)
+		return
+	}
+	return parsed.String(), nil
+}
+
+func blockIP(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var ip, reason string
+	if ip, err = ipParam(params); err != nil {
+		return
+	}
+	if err = optionalParam(params, 1, &reason); err != nil {
+		return
+	}
+	rl.Spam.Ban(ip, time.Now().Add(PermanentBan), reason)
+	rl.Disconnect(func(ws *relayws.WebSocket) bool {
+		return RemoteHost(ws.RealRemote()) == ip
+	})
+	return true, nil
+}
+
+func unblockIP(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	var ip string
+	if ip, err = ipParam(params); err != nil {
+		return
+	}
+	rl.Spam.Unban(ip)
+	return true, nil
+}
+
+func listBlockedIPs(rl *Relay, caller string,
+	params []json.RawMessage) (result any, err error) {
+
+	list := []IPReason{}
+	for _, sp := range rl.Spam.Banned() {
+		if net.ParseIP(sp.Address) != nil {
+			list = append(list, IPReason{sp.Address, sp.Reason})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	return list, nil
+}
+
+// changeInfo returns a ManagementMethod that sets a field of copies of the
+// relay information document and the configuration, which replace them, and
+// the field of the given JSON name in their files.
+func changeInfo(field string, set func(inf *relayinfo.T, conf *base.Config,
+	value string)) ManagementMethod {
+
+	return func(rl *Relay, caller string,
+		params []json.RawMessage) (result any, err error) {
+
+		var value string
+		if err = param(params, 0, &value); err != nil {
+			return
+		}
+		rl.configMx.Lock()
+		defer rl.configMx.Unlock()
+		var inf *relayinfo.T
+		if inf, err = cloneInfo(rl.Info()); chk.E(err) {
+			return
+		}
+		conf := *rl.Config()
+		set(inf, &conf, value)
+		rl.SetConfig(&conf)
+		rl.SetInfo(inf)
+		fields := map[string]any{field: value}
+		if err = rl.saveConfig(fields, fields); err != nil {
+			return
+		}
+		return true, nil
+	}
+}
+
+var (
+	changeRelayName = changeInfo("name", func(inf *relayinfo.T,
+		conf *base.Config, name string) {
+		inf.Name, conf.Name = name, name
+	})
+	changeRelayDescription = changeInfo("description", func(inf *relayinfo.T,
+		conf *base.Config, description string) {
+		inf.Description, conf.Description = description, description
+	})
+	changeRelayIcon = changeInfo("icon", func(inf *relayinfo.T,
+		conf *base.Config, icon string) {
+		inf.Icon, conf.Icon = icon, icon
+	})
+)
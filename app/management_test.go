@@ -0,0 +1,178 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/auth"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestManagement(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	ownerSec := keys.GeneratePrivateKey()
+	owner, _ := keys.GetPublicKey(ownerSec)
+	userSec := keys.GeneratePrivateKey()
+	user, _ := keys.GetPublicKey(userSec)
+	rl := NewRelay(c, cancel, &relayinfo.T{}, &base.Config{
+		SecKey: keys.GeneratePrivateKey(),
+		Owners: []string{owner},
+	})
+	// a minimal event store for the reports and the reported events
+	var stored []*event.T
+	rl.QueryEvents = append(rl.QueryEvents,
+		func(c context.T, f *filter.T) (ch event.C, err error) {
+			ch = make(event.C, len(stored))
+			for _, ev := range stored {
+				if f.Matches(ev) {
+					ch <- ev
+				}
+			}
+			close(ch)
+			return
+		})
+	rl.DeleteEvent = append(rl.DeleteEvent,
+		func(c context.T, ev *event.T) (err error) {
+			for i := range stored {
+				if stored[i].ID == ev.ID {
+					stored = append(stored[:i], stored[i+1:]...)
+					break
+				}
+			}
+			return
+		})
+	sign := func(sec string, ev *event.T) *event.T {
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	note := sign(userSec, &event.T{CreatedAt: timestamp.Now(),
+		Kind: kind.TextNote, Content: "spam"})
+	stored = append(stored, note, sign(ownerSec, &event.T{
+		CreatedAt: timestamp.Now(), Kind: kind.Reporting,
+		Tags: tags.T{{"e", note.ID.String(), "spam"}, {"p", user}}}))
+	call := func(sec, method string, params ...any) (res ManagementResponse,
+		status int) {
+
+		body, _ := json.Marshal(map[string]any{"method": method,
+			"params": append([]any{}, params...)})
+		const u = "https://relay.example.com/"
+		r := httptest.NewRequest("POST", u, bytes.NewReader(body))
+		r.Header.Set("Content-Type", ManagementContentType)
+		r.Header.Set("Authorization", auth.HTTPHeader(
+			sign(sec, auth.CreateUnsignedHTTP(u, "POST", body))))
+		w := httptest.NewRecorder()
+		rl.ServeHTTP(w, r)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: invalid response '%s'", method, w.Body.String())
+		}
+		return res, w.Code
+	}
+	if _, status := call(userSec, "supportedmethods"); status !=
+		http.StatusForbidden {
+		t.Fatalf("expected non-admin to be refused, got status %d", status)
+	}
+	r := httptest.NewRequest("POST", "https://relay.example.com/",
+		bytes.NewReader([]byte(`{"method":"supportedmethods"}`)))
+	r.Header.Set("Content-Type", ManagementContentType)
+	w := httptest.NewRecorder()
+	if rl.ServeHTTP(w, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthenticated request to be refused, got "+
+			"status %d", w.Code)
+	}
+	// only the fields that are changed are written to the configuration file
+	rl.ConfigPath = filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(rl.ConfigPath,
+		[]byte(`{"name":"file relay","seckey":"from file"}`),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		method string
+		params []any
+		result string
+	}{
+		{"banpubkey", []any{user, "spamming"}, `true`},
+		{"listbannedpubkeys", nil,
+			`[{"pubkey":"` + user + `","reason":"spamming"}]`},
+		{"banpubkey", []any{owner}, ``},
+		{"listeventsneedingmoderation", nil,
+			`[{"id":"` + note.ID.String() + `","reason":"spam"}]`},
+		{"banevent", []any{note.ID.String(), "spam"}, `true`},
+		{"listeventsneedingmoderation", nil, `[]`},
+		{"listbannedevents", nil,
+			`[{"id":"` + note.ID.String() + `","reason":"spam"}]`},
+		{"allowevent", []any{"not an id"}, ``},
+		{"allowevent", []any{note.ID.String()}, `true`},
+		{"listbannedevents", nil, `[]`},
+		{"allowkind", []any{1}, `true`},
+		{"allowkind", []any{7}, `true`},
+		{"disallowkind", []any{7}, `true`},
+		{"listallowedkinds", nil, `[1]`},
+		{"blockip", []any{"10.1.2.3", "abuse"}, `true`},
+		{"listblockedips", nil, `[{"ip":"10.1.2.3","reason":"abuse"}]`},
+		{"unblockip", []any{"10.1.2.3"}, `true`},
+		{"listblockedips", nil, `[]`},
+		{"blockip", []any{"not an ip"}, ``},
+		{"changerelayname", []any{"managed relay"}, `true`},
+		{"nosuchmethod", nil, ``},
+	} {
+		res, status := call(ownerSec, test.method, test.params...)
+		if status != http.StatusOK {
+			t.Fatalf("test %d: %s status %d %s", i, test.method, status,
+				res.Error)
+		}
+		if test.result == "" {
+			if res.Error == "" {
+				t.Errorf("test %d: %s expected an error", i, test.method)
+			}
+			continue
+		}
+		got, _ := json.Marshal(res.Result)
+		if string(got) != test.result {
+			t.Errorf("test %d: %s expected %s, got %s %s", i, test.method,
+				test.result, got, res.Error)
+		}
+	}
+	if rl.ACL.GetRole(user) != acl.Denied {
+		t.Errorf("expected banned user to be denied")
+	}
+	if rl.Info().Name != "managed relay" || rl.Config().Name != "managed relay" {
+		t.Errorf("relay name not changed")
+	}
+	b, err := os.ReadFile(rl.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]any
+	if err = json.Unmarshal(b, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file) != 4 || file["name"] != "managed relay" ||
+		file["seckey"] != "from file" || file["allowed_kinds"] == nil ||
+		file["disallowed_kinds"] == nil {
+		t.Errorf("configuration file has more than the changes: %s", b)
+	}
+	for k, reject := range map[kind.T]bool{kind.TextNote: false,
+		kind.Reaction: true, kind.SetMetadata: true} {
+		if rej, _ := rl.RestrictKinds(c, &event.T{Kind: k}); rej != reject {
+			t.Errorf("kind %d expected reject %v", k, reject)
+		}
+	}
+}
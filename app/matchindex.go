@@ -0,0 +1,191 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filters"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+)
+
+// subscription identifies a Listener by its connection and subscription id.
+type subscription struct {
+	ws *relayws.WebSocket
+	id string
+}
+
+// matchIndex is an inverted index of the filters of the live listeners, so the
+// subscriptions that may match an event can be found without checking every
+// filter of every connection.
+//
+// Each filter is indexed under the values of its most selective field, in the
+// order ids, authors, single letter tags and kinds, so any event it matches
+// has at least one of those values. Filters with none of these fields are wide
+// and are candidates for every event.
+type matchIndex struct {
+	sync.RWMutex
+	// index is the subscriptions indexed under each key, the key of wide
+	// filters is the empty string.
+	index map[string]map[subscription]struct{}
+	// keys are the index keys of each subscription, for removing it.
+	keys map[subscription][]string
+}
+
+// matches is the matchIndex of the filters in listeners.
+var matches = newMatchIndex()
+
+func newMatchIndex() *matchIndex {
+	return &matchIndex{
+		index: make(map[string]map[subscription]struct{}),
+		keys:  make(map[subscription][]string),
+	}
+}
+
+// The prefixes of the index keys for each field.
+const (
+	idKey     = "i:"
+	authorKey = "a:"
+	kindKey   = "k:"
+	tagKey    = "t:"
+	wideKey   = ""
+)
+
+// filterKeys returns the index keys of the most selective field of a filter.
+func filterKeys(f *filter.T) (keys []string) {
+	switch {
+	case len(f.IDs) > 0:
+		for _, id := range f.IDs {
+			keys = append(keys, idKey+id)
+		}
+	case len(f.Authors) > 0:
+		for _, author := range f.Authors {
+			keys = append(keys, authorKey+author)
+		}
+	case hasSingleLetterTag(f.Tags):
+		// use the tag with the fewest values, all of the tags must match
+		var name string
+		var values []string
+		for k, v := range f.Tags {
+			k = strings.TrimPrefix(k, "#")
+			if len(k) != 1 || len(v) == 0 {
+				continue
+			}
+			if values == nil || len(v) < len(values) ||
+				(len(v) == len(values) && k < name) {
+				name, values = k, v
+			}
+		}
+		for _, v := range values {
+			keys = append(keys, tagKey+name+":"+v)
+		}
+	case len(f.Kinds) > 0:
+		for _, k := range f.Kinds {
+			keys = append(keys, kindKey+strconv.Itoa(int(k)))
+		}
+	default:
+		keys = append(keys, wideKey)
+	}
+	return
+}
+
+// hasSingleLetterTag returns true if a tag map has a single letter tag with
+// values to match.
+func hasSingleLetterTag(tags filter.TagMap) bool {
+	for k, v := range tags {
+		if len(strings.TrimPrefix(k, "#")) == 1 && len(v) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// eventKeys returns the index keys that a filter matching an event could be
+// indexed under.
+func eventKeys(ev *event.T) (keys []string) {
+	keys = append(keys, wideKey, idKey+ev.ID.String(), authorKey+ev.PubKey,
+		kindKey+strconv.Itoa(int(ev.Kind)))
+	for _, t := range ev.Tags {
+		if len(t) >= 2 && len(t[0]) == 1 {
+			keys = append(keys, tagKey+t[0]+":"+t[1])
+		}
+	}
+	return
+}
+
+// Set indexes the filters of a subscription, replacing any previous filters
+// with the same id on the connection.
+func (m *matchIndex) Set(ws *relayws.WebSocket, id string, ff filters.T) {
+	m.Lock()
+	defer m.Unlock()
+	sub := subscription{ws, id}
+	m.remove(sub)
+	seen := make(map[string]struct{})
+	for _, f := range ff {
+		for _, key := range filterKeys(f) {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			subs, ok := m.index[key]
+			if !ok {
+				subs = make(map[subscription]struct{})
+				m.index[key] = subs
+			}
+			subs[sub] = struct{}{}
+			m.keys[sub] = append(m.keys[sub], key)
+		}
+	}
+}
+
+// Remove removes a subscription from the index.
+func (m *matchIndex) Remove(ws *relayws.WebSocket, id string) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(subscription{ws, id})
+}
+
+// remove removes a subscription from the index, the lock must be held by the
+// caller.
+func (m *matchIndex) remove(sub subscription) {
+	for _, key := range m.keys[sub] {
+		if subs, ok := m.index[key]; ok {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(m.index, key)
+			}
+		}
+	}
+	delete(m.keys, sub)
+}
+
+// Candidates returns the subscription ids on each connection that have a
+// filter that may match an event. The filters must still be checked against
+// the event.
+func (m *matchIndex) Candidates(ev *event.T) (
+	candidates map[*relayws.WebSocket][]string) {
+
+	candidates = make(map[*relayws.WebSocket][]string)
+	seen := make(map[subscription]struct{})
+	m.RLock()
+	defer m.RUnlock()
+	for _, key := range eventKeys(ev) {
+		for sub := range m.index[key] {
+			if _, ok := seen[sub]; ok {
+				continue
+			}
+			seen[sub] = struct{}{}
+			candidates[sub.ws] = append(candidates[sub.ws], sub.id)
+		}
+	}
+	return
+}
+
+// Len returns the number of subscriptions in the index.
+func (m *matchIndex) Len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.keys)
+}
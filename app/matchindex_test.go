@@ -0,0 +1,108 @@
+package app
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filters"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+)
+
+func TestMatchIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pick := func(prefix string, n int) string {
+		return fmt.Sprintf("%s%d", prefix, rng.Intn(n))
+	}
+	randomFilter := func() (f *filter.T) {
+		f = &filter.T{}
+		if rng.Intn(10) == 0 {
+			f.IDs = tag.T{pick("id", 20)}
+		}
+		if rng.Intn(3) == 0 {
+			f.Authors = tag.T{pick("author", 10), pick("author", 10)}
+		}
+		if rng.Intn(3) == 0 {
+			f.Kinds = kinds.T{kind.T(rng.Intn(4))}
+		}
+		if rng.Intn(3) == 0 {
+			f.Tags = filter.TagMap{"#p": {pick("p", 10)}}
+		}
+		if rng.Intn(5) == 0 {
+			f.Tags = filter.TagMap{"#t": {pick("t", 5)}, "e": {pick("e", 5)}}
+		}
+		return
+	}
+	m := newMatchIndex()
+	type sub struct {
+		ws *relayws.WebSocket
+		id string
+		ff filters.T
+	}
+	var subs []sub
+	for i := 0; i < 20; i++ {
+		ws := &relayws.WebSocket{}
+		for j := 0; j < 10; j++ {
+			s := sub{ws, fmt.Sprint(j), filters.T{randomFilter()}}
+			if j%3 == 0 {
+				s.ff = append(s.ff, randomFilter())
+			}
+			m.Set(s.ws, s.id, s.ff)
+			subs = append(subs, s)
+		}
+	}
+	// replacing and removing subscriptions leaves no stale entries
+	m.Set(subs[0].ws, subs[0].id, filters.T{{Kinds: kinds.T{99}}})
+	m.Set(subs[0].ws, subs[0].id, subs[0].ff)
+	m.Remove(subs[1].ws, subs[1].id)
+	subs = append(subs[:1:1], subs[2:]...)
+	m.Remove(subs[1].ws, subs[1].id)
+	m.Set(subs[1].ws, subs[1].id, subs[1].ff)
+	var total, candidates int
+	for i := 0; i < 1000; i++ {
+		ev := &event.T{
+			ID:     eventid.T(pick("id", 20)),
+			PubKey: pick("author", 10),
+			Kind:   kind.T(rng.Intn(4)),
+			Tags: tags.T{{"p", pick("p", 10)}, {"t", pick("t", 5)},
+				{"e", pick("e", 5)}},
+		}
+		found := m.Candidates(ev)
+		for _, ids := range found {
+			candidates += len(ids)
+		}
+		for _, s := range subs {
+			total++
+			if !s.ff.Match(ev) {
+				continue
+			}
+			var ok bool
+			for _, id := range found[s.ws] {
+				if id == s.id {
+					ok = true
+				}
+			}
+			if !ok {
+				t.Fatalf("subscription %s matching event %v is not a candidate",
+					s.id, ev)
+			}
+		}
+	}
+	if candidates >= total/2 {
+		t.Errorf("expected the index to exclude most subscriptions, "+
+			"checked %d of %d", candidates, total)
+	}
+	for _, s := range subs {
+		m.Remove(s.ws, s.id)
+	}
+	if m.Len() != 0 || len(m.index) != 0 {
+		t.Errorf("index not empty after removing all subscriptions")
+	}
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/metrics"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/auth"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/interfaces/enveloper"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/fasthttp/websocket"
+)
+
+// RejectionReasons are the machine readable prefixes of OK rejections that
+// are counted separately, any other reason is counted as "other".
+var RejectionReasons = []string{
+	okenvelope.PoW.S(),
+	okenvelope.Duplicate.S(),
+	okenvelope.Blocked.S(),
+	okenvelope.RateLimited.S(),
+	okenvelope.Invalid.S(),
+	okenvelope.Error.S(),
+	okenvelope.Restricted.S(),
+	auth.Required,
+}
+
+// Metrics are the measurements of relay activity that are exposed on the
+// /metrics endpoint in the Prometheus text format.
+type Metrics struct {
+	*metrics.Registry
+	// Envelopes counts the envelopes received from and sent to clients by
+	// direction and type.
+	Envelopes *metrics.Counter
+	// Rejections counts OK responses to events that were not accepted by the
+	// prefix of the reason.
+	Rejections *metrics.Counter
+	// QueryLatency is the time taken to answer the filters of REQ and COUNT
+	// requests.
+	QueryLatency *metrics.Histogram
+	// SlowConsumers counts the slow consumer policies applied to connections
+	// whose send queue reached its high water mark.
+	SlowConsumers *metrics.Counter
+}
+
+// PollLagger is an event store that polls an L2 for new events.
+type PollLagger interface {
+	PollLag() time.Duration
+}
+
+// EnableMetrics adds the /metrics endpoint to the relay, and the
+// /debug/pprof endpoints, which are only available to the AllowIPs.
+//
+// The event store is used for the L2 poll lag if it polls an L2, and the
+// badger sizes and garbage collector runs are reported if the relay has a
+// badger event store, so this must be called after it is set.
+func (rl *Relay) EnableMetrics(store eventstore.Store) {
+	m := &Metrics{
+		Registry: metrics.NewRegistry(),
+		Envelopes: metrics.NewCounter("replicatr_envelopes_total",
+			"envelopes received and sent by direction and type",
+			"direction", "type"),
+		Rejections: metrics.NewCounter("replicatr_ok_rejections_total",
+			"events rejected by the prefix of the reason", "reason"),
+		QueryLatency: metrics.NewHistogram("replicatr_query_duration_seconds",
+			"time taken to answer a filter by request type",
+			metrics.DefaultBuckets, "type"),
+		SlowConsumers: metrics.NewCounter("replicatr_slow_consumers_total",
+			"slow consumer policies applied to connections", "policy"),
+	}
+	m.Register(m.Envelopes, m.Rejections, m.QueryLatency, m.SlowConsumers,
+		metrics.NewGaugeFunc("replicatr_connections",
+			"open websocket connections", func() float64 {
+				return float64(rl.clients.Size())
+			}),
+		metrics.NewGaugeFunc("replicatr_subscriptions",
+			"active subscriptions", func() float64 {
+				return float64(CountListeners())
+			}),
+		metrics.NewGaugeFunc("replicatr_send_queue_depth",
+			"messages waiting to be sent on all connections", func() float64 {
+				total, _ := rl.QueueDepths()
+				return float64(total)
+			}),
+		metrics.NewGaugeFunc("replicatr_send_queue_max_depth",
+			"messages waiting to be sent on the most backed up connection",
+			func() float64 {
+				_, largest := rl.QueueDepths()
+				return float64(largest)
+			}),
+	)
+	if rl.Badger != nil {
+		m.Register(
+			metrics.NewGaugeFunc("replicatr_badger_lsm_bytes",
+				"size of the badger LSM tree", func() float64 {
+					lsm, _ := rl.Badger.DB.Size()
+					return float64(lsm)
+				}),
+			metrics.NewGaugeFunc("replicatr_badger_vlog_bytes",
+				"size of the badger value log", func() float64 {
+					_, vlog := rl.Badger.DB.Size()
+					return float64(vlog)
+				}),
+			metrics.NewGaugeFunc("replicatr_gc_runs",
+				"garbage collector runs since startup", func() float64 {
+					return float64(rl.Badger.GCRuns.Load())
+				}),
+		)
+	}
+	if pl, ok := store.(PollLagger); ok {
+		m.Register(metrics.NewGaugeFunc("replicatr_l2_poll_lag_seconds",
+			"time since the last successful poll of the L2", func() float64 {
+				return pl.PollLag().Seconds()
+			}))
+	}
+	rl.Metrics = m
+	rl.serveMux.HandleFunc("/metrics", rl.AllowedIPsOnly(m.ServeHTTP))
+	rl.serveMux.HandleFunc("/debug/pprof/", rl.AllowedIPsOnly(pprof.Index))
+	rl.serveMux.HandleFunc("/debug/pprof/cmdline",
+		rl.AllowedIPsOnly(pprof.Cmdline))
+	rl.serveMux.HandleFunc("/debug/pprof/profile",
+		rl.AllowedIPsOnly(pprof.Profile))
+	rl.serveMux.HandleFunc("/debug/pprof/symbol",
+		rl.AllowedIPsOnly(pprof.Symbol))
+	rl.serveMux.HandleFunc("/debug/pprof/trace", rl.AllowedIPsOnly(pprof.Trace))
+}
+
+// AllowedIPsOnly wraps a http handler so it is only served to the AllowIPs.
+//
+// The address is that of the connection, or the one forwarded by one of the
+// TrustedProxies, as the X-Forwarded-For header is set by the client and anyone
+// could claim to be an allowed IP with it.
+func (rl *Relay) AllowedIPsOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rr := rl.RealRemote(r)
+		if MatchIP(rl.Config().AllowIPs, rr) {
+			h(w, r)
+			return
+		}
+		log.D.F("refusing %s from %s, not an allowed IP", r.URL.Path, rr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}
+}
+
+// Received counts an envelope received from a client.
+func (m *Metrics) Received(label string) {
+	if m == nil {
+		return
+	}
+	m.Envelopes.Inc("in", label)
+}
+
+// Sent counts an envelope sent to a client, and the reason if it is an OK
+// rejecting an event.
+func (m *Metrics) Sent(env enveloper.I) {
+	if m == nil {
+		return
+	}
+	m.Envelopes.Inc("out", env.Label())
+	if ok, isOK := env.(*okenvelope.T); isOK && !ok.OK {
+		m.Rejections.Inc(rejectionReason(ok.Reason))
+	}
+}
+
+// Observe records the time taken to answer a filter for a request type.
+func (m *Metrics) Observe(typ string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.QueryLatency.Observe(time.Since(start).Seconds(), typ)
+}
+
+// SlowConsumer counts a slow consumer policy applied to a connection.
+func (m *Metrics) SlowConsumer(p relayws.SlowConsumerPolicy) {
+	if m == nil {
+		return
+	}
+	m.SlowConsumers.Inc(p.String())
+}
+
+// rejectionReason returns the machine readable prefix of a reason if it is one
+// of the RejectionReasons.
+func rejectionReason(reason string) string {
+	prefix, _, found := strings.Cut(reason, ":")
+	if found {
+		for _, r := range RejectionReasons {
+			if prefix == r {
+				return r
+			}
+		}
+	}
+	return "other"
+}
+
+// CountListeners returns the number of active subscriptions on all
+// connections.
+func CountListeners() (n int) {
+	listeners.Range(func(_ *relayws.WebSocket, subs ListenerMap) bool {
+		n += subs.Size()
+		return true
+	})
+	return
+}
+
+// QueueDepths returns the total number of messages waiting to be sent on all
+// connections, and the largest number waiting on one connection.
+func (rl *Relay) QueueDepths() (total, largest int) {
+	rl.clients.Range(func(_ *websocket.Conn, ws *relayws.WebSocket) bool {
+		d := ws.QueueDepth()
+		total += d
+		largest = max(largest, d)
+		return true
+	})
+	return
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/fasthttp/websocket"
+	"github.com/puzpuzpuz/xsync/v2"
+)
+
+func TestMetrics(t *testing.T) {
+	rl := &Relay{
+		clients: xsync.NewTypedMapOf[*websocket.Conn,
+			*relayws.WebSocket](PointerHasher[websocket.Conn]),
+		serveMux: &http.ServeMux{},
+	}
+	rl.SetConfig(&base.Config{AllowIPs: []string{"10.0.0.1"},
+		TrustedProxies: []string{"10.0.0.3"}})
+	rl.EnableMetrics(nil)
+	rl.Metrics.Received("EVENT")
+	rl.Metrics.Sent(&okenvelope.T{OK: false, Reason: "blocked: no"})
+	rl.Metrics.Sent(&okenvelope.T{OK: false, Reason: "something else"})
+	rl.Metrics.Sent(&okenvelope.T{OK: true})
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	w := httptest.NewRecorder()
+	rl.serveMux.ServeHTTP(w, r)
+	body := w.Body.String()
+	for _, line := range []string{
+		`replicatr_connections 0`,
+		`replicatr_subscriptions 0`,
+		`replicatr_envelopes_total{direction="in",type="EVENT"} 1`,
+		`replicatr_envelopes_total{direction="out",type="OK"} 3`,
+		`replicatr_ok_rejections_total{reason="blocked"} 1`,
+		`replicatr_ok_rejections_total{reason="other"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing '%s' in\n%s", line, body)
+		}
+	}
+	for i, test := range []struct {
+		path, remote, forwardedFor string
+		code                       int
+	}{
+		{"/debug/pprof/", "10.0.0.1:4000", "", http.StatusOK},
+		{"/debug/pprof/", "10.0.0.2:4000", "", http.StatusForbidden},
+		// the forwarded address is chosen by the client
+		{"/debug/pprof/", "10.0.0.2:4000", "10.0.0.1", http.StatusForbidden},
+		// unless it is set by a trusted proxy
+		{"/debug/pprof/", "10.0.0.3:4000", "10.0.0.1", http.StatusOK},
+		{"/debug/pprof/", "10.0.0.3:4000", "10.0.0.2", http.StatusForbidden},
+		{"/metrics", "10.0.0.2:4000", "", http.StatusForbidden},
+		{"/metrics", "10.0.0.2:4000", "10.0.0.1", http.StatusForbidden},
+	} {
+		r = httptest.NewRequest("GET", test.path, nil)
+		r.RemoteAddr = test.remote
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		w = httptest.NewRecorder()
+		rl.serveMux.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%d: %s from %s expected status %d, got %d", i,
+				test.path, test.remote, test.code, w.Code)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package app
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"golang.org/x/exp/slices"
 )
@@ -110,3 +116,95 @@ func PreventTimestampsInTheFuture(thresholdSeconds timestamp.T) RejectEvent {
 		return false, ""
 	}
 }
+
+// LimitEphemeralEvents returns a RejectEvent that limits the number of
+// ephemeral events of each kind in perMinute a client can send each minute.
+// Clients are identified by their IP address, or the event pubkey if the event
+// was not received from a websocket. Kinds not in perMinute are not limited.
+func LimitEphemeralEvents(perMinute map[int]int) RejectEvent {
+	limiters := make(map[kind.T]*Limiter)
+	for k, n := range perMinute {
+		limiters[kind.T(k)] = NewLimiter(n, time.Minute)
+	}
+	return func(c context.T, ev *event.T) (reject bool, msg string) {
+		if !ev.Kind.IsEphemeral() {
+			return false, ""
+		}
+		l, ok := limiters[ev.Kind]
+		if !ok {
+			return false, ""
+		}
+		key := ev.PubKey
+		if ws := GetConnection(c); ws != nil {
+			key = RemoteHost(ws.RealRemote())
+		}
+		if !l.Allow(key) {
+			return true, normalize.Reason(fmt.Sprintf(
+				"too many events of kind %d, limit is %d per minute",
+				ev.Kind, perMinute[int(ev.Kind)]), okenvelope.RateLimited.S())
+		}
+		return false, ""
+	}
+}
+
+// RestrictKinds rejects events of the DisallowedKinds in the configuration, and
+// events of kinds other than the AllowedKinds if any are listed. The lists are
+// read for each event so changes made through the management API apply
+// immediately.
+//
+// Direct messages to the relay are always accepted so the chat control
+// interface remains available.
+func (rl *Relay) RestrictKinds(c context.T, ev *event.T) (reject bool,
+	msg string) {
+
+	if rl.IsChatMessage(ev) {
+		return false, ""
+	}
+	rl.configMx.Lock()
+	defer rl.configMx.Unlock()
+	if slices.Contains(rl.Config().DisallowedKinds, int(ev.Kind)) ||
+		(len(rl.Config().AllowedKinds) > 0 &&
+			!slices.Contains(rl.Config().AllowedKinds, int(ev.Kind))) {
+		return true, normalize.Reason(fmt.Sprintf(
+			"events of kind %d are not accepted", ev.Kind),
+			okenvelope.Blocked.S())
+	}
+	return false, ""
+}
+
+// RequirePoW rejects events with less NIP-13 proof of work than the
+// PowDifficulty configured for the ACL role of the client and the kind of the
+// event. The event must also commit to a target of at least that difficulty in
+// its nonce tag.
+//
+// Direct messages to the relay are always accepted so the chat control
+// interface remains available.
+func (rl *Relay) RequirePoW(c context.T, ev *event.T) (reject bool,
+	msg string) {
+
+	if rl.IsChatMessage(ev) {
+		return false, ""
+	}
+	role := acl.None
+	if ws := GetConnection(c); ws != nil {
+		role = rl.GetRole(ws)
+	}
+	rl.configMx.Lock()
+	required := rl.Config().PowDifficulty[acl.RoleStrings[role]].Required(
+		int(ev.Kind))
+	rl.configMx.Unlock()
+	if required <= 0 {
+		return false, ""
+	}
+	if d := ev.Difficulty(); d < required {
+		return true, normalize.Reason(fmt.Sprintf(
+			"difficulty %d is less than %d", d, required),
+			okenvelope.PoW.S())
+	}
+	if target, ok := ev.CommittedDifficulty(); !ok || target < required {
+		return true, normalize.Reason(fmt.Sprintf(
+			"nonce tag must commit to a target difficulty of at least %d",
+			required), okenvelope.PoW.S())
+	}
+	return false, ""
+}
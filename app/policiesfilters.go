@@ -19,7 +19,7 @@ func NoComplexFilters(c context.T, id subscriptionid.T, f *filter.T) (rej bool,
 }
 
 // NoEmptyFilters disallows filters that don't have at least a tag, a kind, an
-// author or an id, or since or until.
+// author or an id, since, until, limit or a search.
 func NoEmptyFilters(c context.T, id subscriptionid.T, f *filter.T) (reject bool, msg string) {
 	cf := len(f.Kinds) + len(f.IDs) + len(f.Authors)
 	for _, tagItems := range f.Tags {
@@ -34,6 +34,9 @@ func NoEmptyFilters(c context.T, id subscriptionid.T, f *filter.T) (reject bool,
 	if f.Limit != nil {
 		cf++
 	}
+	if f.Search != "" {
+		cf++
+	}
 	if cf == 0 {
 		return true, "can't handle empty filters"
 	}
@@ -0,0 +1,323 @@
+// Package policy runs an external policy plugin, a process that is sent each
+// event and filter the relay receives as a line of JSON on its standard input
+// and answers with a line of JSON on its standard output saying whether to
+// accept it, similar to the write policy plugins of strfry.
+//
+// Requests are sent one at a time, and each response must have the id of the
+// request it answers. Anything the plugin writes to its standard error is
+// logged.
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/slog"
+)
+
+var log, chk = slog.New(os.Stderr)
+
+// Action is the answer of the plugin to a request.
+type Action string
+
+const (
+	// Accept lets the event be stored or the filter be queried.
+	Accept Action = "accept"
+	// Reject refuses the event or filter with the message of the response.
+	Reject Action = "reject"
+	// ShadowReject pretends to accept the event, but it is not stored or
+	// broadcast, or answers the filter with no events.
+	ShadowReject Action = "shadowReject"
+)
+
+// The types of request sent to the plugin.
+const (
+	// NewEvent is the type of request for an event sent by a client.
+	NewEvent = "new"
+	// NewFilter is the type of request for a filter of a REQ or COUNT.
+	NewFilter = "req"
+)
+
+const (
+	// DefaultTimeout is how long the plugin has to answer a request if no
+	// timeout is configured.
+	DefaultTimeout = 2 * time.Second
+	// MaxTimeouts is the number of requests in a row the plugin can fail to
+	// answer in time before it is restarted.
+	MaxTimeouts = 3
+	// MinRestartDelay and MaxRestartDelay bound the time waited before
+	// restarting the plugin after it exits, which doubles each time it exits
+	// again within MaxRestartDelay of starting.
+	MinRestartDelay = time.Second
+	MaxRestartDelay = time.Minute
+)
+
+var (
+	ErrNotRunning = errors.New("policy plugin is not running")
+	ErrTimeout    = errors.New("policy plugin did not answer in time")
+)
+
+// Request is the message sent to the plugin for each event or filter.
+type Request struct {
+	// Type is NewEvent or NewFilter.
+	Type string `json:"type"`
+	// ID is the event id, or for filters, a number identifying the request.
+	ID string `json:"id"`
+	// Event is the event sent by the client, for NewEvent.
+	Event *event.T `json:"event,omitempty"`
+	// Subscription is the subscription id and Filter the filter sent by the
+	// client, for NewFilter.
+	Subscription string    `json:"subscription,omitempty"`
+	Filter       *filter.T `json:"filter,omitempty"`
+	// ReceivedAt is the unix timestamp the request was received.
+	ReceivedAt int64 `json:"receivedAt"`
+	// SourceType is IP4 or IP6, and SourceInfo the real remote address of
+	// the client.
+	SourceType string `json:"sourceType"`
+	SourceInfo string `json:"sourceInfo"`
+	// Authed is the pubkey the client has authenticated as, if any.
+	Authed string `json:"authed,omitempty"`
+	// Role is the ACL role of the authenticated pubkey.
+	Role string `json:"role"`
+}
+
+// Response is the message the plugin sends for each Request.
+type Response struct {
+	// ID is the ID of the Request.
+	ID string `json:"id"`
+	// Action is what to do with the event or filter.
+	Action Action `json:"action"`
+	// Msg is the reason sent to the client when it is rejected.
+	Msg string `json:"msg,omitempty"`
+}
+
+// process is a running instance of the plugin.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// lines are the lines written by the plugin to its standard output,
+	// closed when it exits.
+	lines chan []byte
+}
+
+// Plugin is a policy plugin process, which is restarted whenever it exits.
+type Plugin struct {
+	// Path is the executable of the plugin.
+	Path string
+	// busy holds a value while a request is sent and waiting for its
+	// response, so requests waiting their turn can give up at their deadline.
+	busy chan struct{}
+	// mx protects the running process and the count of timeouts.
+	mx       sync.Mutex
+	proc     *process
+	timeouts int
+}
+
+// New returns a Plugin that runs the executable at path.
+func New(path string) *Plugin {
+	return &Plugin{Path: path, busy: make(chan struct{}, 1)}
+}
+
+// Run starts the plugin and restarts it whenever it exits, until the context
+// is canceled, when it is stopped.
+//
+// This should be run in a goroutine.
+func (p *Plugin) Run(c context.T) {
+	delay := MinRestartDelay
+	for {
+		started := time.Now()
+		if err := p.run(c); err != nil {
+			log.E.F("policy plugin %s exited: %v", p.Path, err)
+		} else {
+			log.W.F("policy plugin %s exited", p.Path)
+		}
+		// a plugin that ran for a while gets restarted promptly, one that
+		// keeps failing is restarted less and less often
+		if time.Since(started) > MaxRestartDelay {
+			delay = MinRestartDelay
+		}
+		select {
+		case <-c.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, MaxRestartDelay)
+	}
+}
+
+// run starts one instance of the plugin and waits for it to exit.
+func (p *Plugin) run(c context.T) (err error) {
+	cmd := exec.CommandContext(c, p.Path)
+	proc := &process{cmd: cmd, lines: make(chan []byte, 1)}
+	if proc.stdin, err = cmd.StdinPipe(); chk.E(err) {
+		return
+	}
+	var stdout, stderr io.ReadCloser
+	if stdout, err = cmd.StdoutPipe(); chk.E(err) {
+		return
+	}
+	if stderr, err = cmd.StderrPipe(); chk.E(err) {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	log.I.F("started policy plugin %s pid %d", p.Path, cmd.Process.Pid)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.I.F("policy plugin: %s", scanner.Text())
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(proc.lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 4096), 1<<20)
+		for scanner.Scan() {
+			line := append([]byte{}, scanner.Bytes()...)
+			select {
+			case proc.lines <- line:
+			case <-done:
+				return
+			}
+		}
+	}()
+	p.mx.Lock()
+	p.proc, p.timeouts = proc, 0
+	p.mx.Unlock()
+	err = cmd.Wait()
+	close(done)
+	p.mx.Lock()
+	if p.proc == proc {
+		p.proc = nil
+	}
+	p.mx.Unlock()
+	return
+}
+
+// Running returns true if the plugin is ready for requests.
+func (p *Plugin) Running() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.proc != nil
+}
+
+// Query sends a request to the plugin and waits up to timeout for the
+// response, counted from the call, so it includes the time waiting for the
+// requests before it. Responses to earlier requests that timed out are
+// discarded, and the plugin is killed, to be restarted, after MaxTimeouts in a
+// row, or at once if the request cannot be written to it within the timeout.
+func (p *Plugin) Query(req *Request, timeout time.Duration) (res *Response,
+	err error) {
+
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	var b []byte
+	if b, err = json.Marshal(req); chk.E(err) {
+		return
+	}
+	b = append(b, '\n')
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case p.busy <- struct{}{}:
+		defer func() { <-p.busy }()
+	case <-deadline.C:
+		log.D.F("policy plugin busy, request %s timed out waiting", req.ID)
+		return nil, ErrTimeout
+	}
+	p.mx.Lock()
+	proc := p.proc
+	p.mx.Unlock()
+	if proc == nil {
+		return nil, ErrNotRunning
+	}
+	// a plugin that stops reading its input would block the write forever,
+	// so it is written in the background and also bounded by the timeout
+	written := make(chan error, 1)
+	go func() {
+		_, wErr := proc.stdin.Write(b)
+		written <- wErr
+	}()
+	select {
+	case err = <-written:
+		if err != nil {
+			log.E.F("writing to policy plugin, restarting it: %v", err)
+			p.kill(proc)
+			return nil, ErrNotRunning
+		}
+	case <-deadline.C:
+		log.W.F("policy plugin is not reading requests, restarting it")
+		p.kill(proc)
+		return nil, ErrTimeout
+	}
+	for {
+		select {
+		case line, ok := <-proc.lines:
+			if !ok {
+				// the plugin has exited
+				p.drop(proc)
+				return nil, ErrNotRunning
+			}
+			res = &Response{}
+			if jErr := json.Unmarshal(line, res); jErr != nil {
+				log.E.F("invalid response from policy plugin: %v: %s", jErr,
+					line)
+				continue
+			}
+			if res.ID != req.ID {
+				log.D.F("discarding late response from policy plugin for %s",
+					res.ID)
+				continue
+			}
+			p.mx.Lock()
+			p.timeouts = 0
+			p.mx.Unlock()
+			switch res.Action {
+			case Accept, Reject, ShadowReject:
+			default:
+				return nil, log.E.Err("unknown action from policy plugin '%s'",
+					res.Action)
+			}
+			return
+		case <-deadline.C:
+			p.mx.Lock()
+			p.timeouts++
+			timeouts := p.timeouts
+			p.mx.Unlock()
+			if timeouts >= MaxTimeouts {
+				log.W.F("policy plugin did not answer %d requests, "+
+					"restarting it", timeouts)
+				p.kill(proc)
+			}
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// drop stops sending requests to an instance of the plugin.
+func (p *Plugin) drop(proc *process) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.proc == proc {
+		p.proc = nil
+	}
+}
+
+// kill stops sending requests to an instance of the plugin and kills it, so
+// that Run starts it again.
+func (p *Plugin) kill(proc *process) {
+	p.drop(proc)
+	chk.E(proc.cmd.Process.Kill())
+}
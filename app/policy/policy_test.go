@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+)
+
+// pluginEnv makes the test binary act as a policy plugin when it is run by the
+// Plugin.
+const pluginEnv = "REPLICATR_TEST_POLICY_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		testPlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// testPlugin answers requests according to the content of the events.
+func testPlugin() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(1)
+		}
+		res := Response{ID: req.ID, Action: Accept}
+		switch req.Event.Content {
+		case "reject":
+			res.Action, res.Msg = Reject, "not wanted"
+		case "shadow":
+			res.Action = ShadowReject
+		case "hang":
+			continue
+		case "stall":
+			// stop reading requests
+			time.Sleep(time.Hour)
+		case "exit":
+			os.Exit(1)
+		case "closein":
+			// stop reading requests without exiting
+			os.Stdin.Close()
+			time.Sleep(time.Hour)
+		}
+		b, _ := json.Marshal(res)
+		os.Stdout.Write(append(b, '\n'))
+	}
+}
+
+func waitRunning(t *testing.T, p *Plugin, running bool) {
+	for i := 0; p.Running() != running; i++ {
+		if i == 1000 {
+			t.Fatalf("plugin running should be %v", running)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	t.Setenv(pluginEnv, "1")
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	p := New(os.Args[0])
+	query := func(content string) (*Response, error) {
+		return p.Query(&Request{Type: NewEvent, ID: content,
+			Event: &event.T{Content: content}}, 200*time.Millisecond)
+	}
+	if _, err := query("accept"); err != ErrNotRunning {
+		t.Fatalf("expected %v, got %v", ErrNotRunning, err)
+	}
+	go p.Run(c)
+	waitRunning(t, p, true)
+	for content, action := range map[string]Action{"accept": Accept,
+		"reject": Reject, "shadow": ShadowReject} {
+		res, err := query(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Action != action {
+			t.Errorf("expected %s for %s, got %s", action, content,
+				res.Action)
+		}
+	}
+	// the plugin is restarted after it stops answering
+	for i := 0; i < MaxTimeouts; i++ {
+		if _, err := query("hang"); err != ErrTimeout {
+			t.Fatalf("expected %v, got %v", ErrTimeout, err)
+		}
+	}
+	waitRunning(t, p, false)
+	waitRunning(t, p, true)
+	// and after it exits
+	if _, err := query("exit"); err != ErrNotRunning {
+		t.Fatalf("expected %v, got %v", ErrNotRunning, err)
+	}
+	waitRunning(t, p, false)
+	waitRunning(t, p, true)
+	// and at once when it stops reading requests
+	if _, err := query("stall"); err != ErrTimeout {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	if _, err := query(strings.Repeat("x", 1<<20)); err != ErrTimeout {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	waitRunning(t, p, false)
+	waitRunning(t, p, true)
+	if res, err := query("accept"); err != nil || res.Action != Accept {
+		t.Fatalf("restarted plugin did not accept: %v %v", res, err)
+	}
+	// the timeout includes the time waiting for the request before
+	go query("hang")
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	if _, err := p.Query(&Request{Type: NewEvent, ID: "waiting",
+		Event: &event.T{Content: "accept"}}, 50*time.Millisecond); err !=
+		ErrTimeout {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	if waited := time.Since(start); waited > 150*time.Millisecond {
+		t.Errorf("request waited %v for the one before it", waited)
+	}
+	time.Sleep(200 * time.Millisecond)
+	// a plugin that can't be written to is killed and restarted
+	if _, err := query("closein"); err != ErrTimeout {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	if _, err := query("accept"); err != ErrNotRunning {
+		t.Fatalf("expected %v, got %v", ErrNotRunning, err)
+	}
+	waitRunning(t, p, true)
+	if res, err := query("accept"); err != nil || res.Action != Accept {
+		t.Fatalf("restarted plugin did not accept: %v %v", res, err)
+	}
+}
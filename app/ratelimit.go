@@ -0,0 +1,194 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+)
+
+// Bucket is a token bucket that refills at a constant rate up to a maximum
+// burst size.
+type Bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets with the same rate and burst, one for each
+// key, such as an IP address or a pubkey.
+type Limiter struct {
+	sync.Mutex
+	// Rate is the number of tokens added per second.
+	Rate float64
+	// Burst is the maximum number of tokens a bucket can hold.
+	Burst float64
+	// buckets are the token buckets for each key.
+	buckets   map[string]*Bucket
+	lastPrune time.Time
+}
+
+// NewLimiter creates a Limiter that permits count operations per period for
+// each key, with bursts of up to count.
+func NewLimiter(count int, period time.Duration) (l *Limiter) {
+	return &Limiter{
+		Rate:    float64(count) / period.Seconds(),
+		Burst:   float64(count),
+		buckets: make(map[string]*Bucket),
+	}
+}
+
+// Allow takes a token from the bucket of the key, and returns false if there
+// was none left.
+func (l *Limiter) Allow(key string) (ok bool) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	l.prune(now)
+	b, found := l.buckets[key]
+	if !found {
+		b = &Bucket{tokens: l.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if b.tokens > l.Burst {
+		b.tokens = l.Burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune removes the buckets that have refilled, as they are the same as a new
+// bucket. It runs at most once a minute.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= l.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimits are the limiters for connections, and for EVENT and REQ messages
+// for each ACL role.
+type RateLimits struct {
+	Conns  *Limiter
+	Events map[acl.Role]*Limiter
+	Reqs   map[acl.Role]*Limiter
+}
+
+// NewRateLimits creates the limiters from the configuration. Roles without a
+// limit, or with a limit of zero, are not limited.
+func NewRateLimits(conf *base.Config) (rl *RateLimits) {
+	rl = &RateLimits{
+		Events: make(map[acl.Role]*Limiter),
+		Reqs:   make(map[acl.Role]*Limiter),
+	}
+	if conf.ConnRateLimit > 0 {
+		rl.Conns = NewLimiter(conf.ConnRateLimit, time.Minute)
+	}
+	for name, limit := range conf.RateLimits {
+		role, ok := acl.ParseRole(name)
+		if !ok {
+			log.W.F("unknown role '%s' in rate limits", name)
+			continue
+		}
+		if limit.Events > 0 {
+			rl.Events[role] = NewLimiter(limit.Events, time.Minute)
+		}
+		if limit.Reqs > 0 {
+			rl.Reqs[role] = NewLimiter(limit.Reqs, time.Minute)
+		}
+	}
+	return
+}
+
+// allow checks the limiter for a role, if there is one, for both the IP address
+// and the authenticated pubkey of a connection.
+func allow(limiters map[acl.Role]*Limiter, role acl.Role,
+	ws *relayws.WebSocket) (ok bool) {
+
+	l, limited := limiters[role]
+	if !limited {
+		return true
+	}
+	if !l.Allow(RemoteHost(ws.RealRemote())) {
+		return false
+	}
+	if pub := ws.AuthPubKey(); pub != "" && !l.Allow(pub) {
+		return false
+	}
+	return true
+}
+
+// AllowConnection returns false if an IP address has opened too many
+// connections recently.
+func (rl *Relay) AllowConnection(host string) (ok bool) {
+	limits := rl.Limits()
+	if limits == nil || limits.Conns == nil {
+		return true
+	}
+	return limits.Conns.Allow(host)
+}
+
+// AllowEvent returns false and a reason if the client on a websocket has
+// published too many events recently for its role.
+func (rl *Relay) AllowEvent(ws *relayws.WebSocket) (ok bool, reason string) {
+	limits := rl.Limits()
+	if limits == nil || allow(limits.Events, rl.GetRole(ws), ws) {
+		return true, ""
+	}
+	return false, normalize.Reason("slow down, too many events",
+		okenvelope.RateLimited.S())
+}
+
+// AllowReq returns false and a reason if the client on a websocket has made
+// too many REQ or COUNT requests recently for its role.
+func (rl *Relay) AllowReq(ws *relayws.WebSocket) (ok bool, reason string) {
+	limits := rl.Limits()
+	if limits == nil || allow(limits.Reqs, rl.GetRole(ws), ws) {
+		return true, ""
+	}
+	return false, normalize.Reason("slow down, too many requests",
+		okenvelope.RateLimited.S())
+}
+
+// Offend records a rate limit violation by the IP address and the
+// authenticated pubkey of a connection. When either has offended BanAfter
+// times it is banned and all of its connections are closed.
+func (rl *Relay) Offend(ws *relayws.WebSocket) {
+	// owners can't be banned, as with the ban command
+	if rl.GetRole(ws) == acl.Owner {
+		return
+	}
+	host, pub := RemoteHost(ws.RealRemote()), ws.AuthPubKey()
+	var banned bool
+	for _, address := range []string{host, pub} {
+		if address == "" {
+			continue
+		}
+		if until, b := rl.Spam.Strike(address, rl.Config().BanAfter,
+			rl.Config().BanDuration); b {
+			log.I.F("banned %s until %s for exceeding rate limits", address,
+				until.UTC().Format(TimeFormat))
+			banned = true
+		}
+	}
+	if !banned {
+		return
+	}
+	rl.Disconnect(func(w *relayws.WebSocket) bool {
+		return RemoteHost(w.RealRemote()) == host ||
+			(pub != "" && w.AuthPubKey() == pub)
+	})
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2, time.Hour)
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("burst not allowed")
+	}
+	if l.Allow("a") {
+		t.Fatal("limit exceeded")
+	}
+	if !l.Allow("b") {
+		t.Fatal("keys not limited separately")
+	}
+}
+
+func TestLimitEphemeralEvents(t *testing.T) {
+	rej := LimitEphemeralEvents(map[int]int{20001: 1})
+	c := context.Bg()
+	typing := &event.T{Kind: 20001, PubKey: "abcd"}
+	if reject, _ := rej(c, typing); reject {
+		t.Fatal("first event rejected")
+	}
+	reject, msg := rej(c, typing)
+	if !reject || !strings.HasPrefix(msg, "rate-limited: ") {
+		t.Fatalf("second event not rate limited: %s", msg)
+	}
+	for _, ev := range []*event.T{
+		{Kind: 20002, PubKey: "abcd"},
+		{Kind: kind.TextNote, PubKey: "abcd"},
+	} {
+		if reject, _ = rej(c, ev); reject {
+			t.Fatalf("kind %d should not be limited", ev.Kind)
+		}
+	}
+}
+
+func TestRateLimitsOffByDefault(t *testing.T) {
+	conf := base.GetDefaultConfig()
+	limits := NewRateLimits(conf)
+	if limits.Conns != nil || len(limits.Events) != 0 ||
+		len(limits.Reqs) != 0 {
+		t.Errorf("default configuration has rate limits: %+v", limits)
+	}
+	if _, banned := (&Spam{}).Strike("a", conf.BanAfter,
+		conf.BanDuration); banned {
+		t.Errorf("default configuration bans clients")
+	}
+}
+
+func TestRateLimitSpoofedAddress(t *testing.T) {
+	rl := &Relay{}
+	conf := &base.Config{TrustedProxies: []string{"10.0.0.1"},
+		RateLimits: map[string]base.RateLimit{"none": {Events: 1}}}
+	rl.SetConfig(conf)
+	rl.SetLimits(NewRateLimits(conf))
+	connect := func(remote, forwardedFor string) (ws *relayws.WebSocket) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		ws = &relayws.WebSocket{}
+		ws.SetRealRemote(rl.RealRemote(r))
+		return
+	}
+	if ok, _ := rl.AllowEvent(connect("10.0.0.2:4000", "1.1.1.1")); !ok {
+		t.Fatal("first event refused")
+	}
+	// a client can't get a new limit by claiming another address
+	if ok, _ := rl.AllowEvent(connect("10.0.0.2:4001", "2.2.2.2")); ok {
+		t.Error("event with spoofed forwarded address not limited")
+	}
+	// clients behind a trusted proxy are limited separately
+	if ok, _ := rl.AllowEvent(connect("10.0.0.1:4000", "3.3.3.3")); !ok {
+		t.Error("event from a client behind the proxy refused")
+	}
+	if ok, _ := rl.AllowEvent(connect("10.0.0.1:4001", "4.4.4.4")); !ok {
+		t.Error("event from another client behind the proxy refused")
+	}
+}
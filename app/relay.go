@@ -1,19 +1,25 @@
 package app
 
 import (
+	"encoding/json"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Hubmakerlabs/replicatr/app/acl"
 	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
-	"github.com/Hubmakerlabs/replicatr/pkg/nostr/acl"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/bech32encoding"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"github.com/Hubmakerlabs/replicatr/pkg/units"
 	"github.com/fasthttp/websocket"
 	"github.com/puzpuzpuz/xsync/v2"
@@ -45,9 +51,12 @@ type (
 	Hook                      func(c context.T)
 	OverwriteRelayInformation func(c context.T, r *http.Request,
 		info *relayinfo.T) *relayinfo.T
-	QueryEvents  func(c context.T, f *filter.T) (C event.C, err error)
-	CountEvents  func(c context.T, f *filter.T) (cnt int, err error)
-	OnEventSaved func(c context.T, ev *event.T)
+	QueryEvents func(c context.T, f *filter.T) (C event.C, err error)
+	CountEvents func(c context.T, f *filter.T) (cnt int, approx bool,
+		err error)
+	OnEventSaved     func(c context.T, ev *event.T)
+	TombstoneAddress func(c context.T, address string, until timestamp.T) error
+	TombstoneEventID func(c context.T, evID eventid.T, pubkey string) error
 )
 
 type Relay struct {
@@ -64,17 +73,33 @@ type Relay struct {
 	OverwriteRelayInfo     []OverwriteRelayInformation
 	StoreEvent             []Events
 	DeleteEvent            []Events
+	TombstoneAddress       []TombstoneAddress
+	TombstoneEventID       []TombstoneEventID
 	QueryEvents            []QueryEvents
 	CountEvents            []CountEvents
 	OnConnect              []Hook
 	OnDisconnect           []Hook
 	OnEventSaved           []OnEventSaved
-	Config                 *base.Config
-	Info                   *relayinfo.T
+	// config, info and limits are replaced as a whole when the configuration
+	// changes, and are read with Config, Info and Limits.
+	config atomic.Pointer[base.Config]
+	info   atomic.Pointer[relayinfo.T]
+	limits atomic.Pointer[RateLimits]
+	// ConfigPath and InfoPath are the files the configuration and relay
+	// information document are saved to when they are changed by the
+	// management API, if set.
+	ConfigPath, InfoPath string
+	// Args are the configuration values given on the command line, which are
+	// applied over the configuration file again when it is reloaded.
+	Args *base.Config
+	// configMx serializes changes to the configuration and relay information
+	// document while the relay is running.
+	configMx sync.Mutex
 	// for establishing websockets
 	upgrader websocket.Upgrader
 	// keep a connection reference to all connected clients for Server.Shutdown
-	clients *xsync.MapOf[*websocket.Conn, struct{}]
+	// and for finding the connections of a user
+	clients *xsync.MapOf[*websocket.Conn, *relayws.WebSocket]
 	// in case you call Server.Start
 	Addr       string
 	serveMux   *http.ServeMux
@@ -87,12 +112,21 @@ type Relay struct {
 	// PingPeriod is the tend pings to peer with this period. Must be less than
 	// pongWait.
 	PingPeriod     time.Duration
-	MaxMessageSize int64    // Maximum message size allowed from peer.
-	Whitelist      []string // whitelist of allowed IPs for access
+	MaxMessageSize int64 // Maximum message size allowed from peer.
 	RelayPubHex    string
 	RelayNpub      string
 	// ACL is the list of users and privileges on this relay
 	ACL *acl.T
+	// Spam is the list of banned IP addresses and pubkeys
+	Spam *Spam
+	// BannedEvents are the IDs of the events banned through the management
+	// API, with the reasons they were banned
+	BannedEvents *Spam
+	// Badger is the local event store, if one is in use, for maintenance
+	// commands
+	Badger *badger.Backend
+	// Metrics are the measurements of relay activity, if enabled
+	Metrics *Metrics
 }
 
 func NewRelay(c context.T, cancel context.F,
@@ -107,34 +141,34 @@ func NewRelay(c context.T, cancel context.F,
 	var npub string
 	npub, err = bech32encoding.HexToNpub(pubKey)
 	chk.E(err)
-	inf.Software = Software
-	inf.Version = Version
-	inf.PubKey = pubKey
+	setInfo(inf, conf, pubKey)
 	r = &Relay{
 		Ctx:    c,
 		Cancel: cancel,
-		Config: conf,
-		Info:   inf,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  ReadBufferSize,
 			WriteBufferSize: WriteBufferSize,
 			CheckOrigin:     func(r *http.Request) bool { return true },
 		},
 		clients: xsync.NewTypedMapOf[*websocket.Conn,
-			struct{}](PointerHasher[websocket.Conn]),
+			*relayws.WebSocket](PointerHasher[websocket.Conn]),
 		serveMux:       &http.ServeMux{},
 		WriteWait:      WriteWait,
 		PongWait:       PongWait,
 		PingPeriod:     PingPeriod,
 		MaxMessageSize: int64(maxMessageLength),
-		Whitelist:      conf.Whitelist,
 		RelayPubHex:    pubKey,
 		RelayNpub:      npub,
 		ACL:            &acl.T{},
+		Spam:           NewSpam(),
+		BannedEvents:   NewSpam(),
 	}
+	r.SetConfig(conf)
+	r.SetInfo(inf)
+	r.SetLimits(NewRateLimits(conf))
 	log.I.F("relay identity pubkey: %s %s\n", pubKey, npub)
 	// populate ACL with owners to start
-	for _, owner := range r.Config.Owners {
+	for _, owner := range conf.Owners {
 		if err = r.ACL.AddEntry(&acl.Entry{
 			Role:   acl.Owner,
 			Pubkey: owner,
@@ -145,3 +179,68 @@ func NewRelay(c context.T, cancel context.F,
 	}
 	return
 }
+
+// Config returns the current configuration of the relay. It is shared by all
+// the goroutines of the relay so it must not be modified, changes are made to a
+// copy which replaces it with SetConfig.
+func (rl *Relay) Config() *base.Config { return rl.config.Load() }
+
+// SetConfig replaces the configuration of the relay.
+func (rl *Relay) SetConfig(conf *base.Config) { rl.config.Store(conf) }
+
+// Info returns the current relay information document. Like the Config it must
+// not be modified, only replaced with SetInfo.
+func (rl *Relay) Info() *relayinfo.T { return rl.info.Load() }
+
+// SetInfo replaces the relay information document.
+func (rl *Relay) SetInfo(inf *relayinfo.T) { rl.info.Store(inf) }
+
+// Limits returns the rate limits on connections and messages from clients, or
+// nil if there are none.
+func (rl *Relay) Limits() *RateLimits { return rl.limits.Load() }
+
+// SetLimits replaces the rate limits.
+func (rl *Relay) SetLimits(l *RateLimits) { rl.limits.Store(l) }
+
+// Whitelist returns the IP addresses that are the only ones allowed to access
+// the relay, if there are any.
+func (rl *Relay) Whitelist() []string {
+	if conf := rl.Config(); conf != nil {
+		return conf.Whitelist
+	}
+	return nil
+}
+
+// cloneInfo returns a copy of a relay information document that can be changed
+// and then used to replace it.
+func cloneInfo(inf *relayinfo.T) (clone *relayinfo.T, err error) {
+	var b []byte
+	if b, err = json.Marshal(inf); chk.E(err) {
+		return
+	}
+	clone = &relayinfo.T{}
+	if err = json.Unmarshal(b, clone); chk.E(err) {
+		return
+	}
+	return
+}
+
+// setInfo sets the fields of the relay information document that are derived
+// from the software, the relay identity and the configuration.
+func setInfo(inf *relayinfo.T, conf *base.Config, pubKey string) {
+	inf.Software = Software
+	inf.Version = Version
+	inf.PubKey = pubKey
+	// advertise the limits that apply to unauthenticated clients
+	inf.Limitation.MaxEventsPerMinute = conf.RateLimits["none"].Events
+	inf.Limitation.MaxReqsPerMinute = conf.RateLimits["none"].Reqs
+	inf.Limitation.MaxConnectionsPerMinute = conf.ConnRateLimit
+	inf.Limitation.MinPowDifficulty =
+		conf.PowDifficulty[acl.RoleStrings[acl.None]].Difficulty
+	if conf.AuthRequired {
+		inf.Limitation.AuthRequired = true
+	}
+	if inf.Limitation.MinPowDifficulty > 0 {
+		inf.Limitation.RestrictedWrites = true
+	}
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+	"github.com/Hubmakerlabs/replicatr/pkg/slog"
+)
+
+// ReloadCheckInterval is how often WatchConfig checks the configuration files
+// for changes.
+const ReloadCheckInterval = 5 * time.Second
+
+// StartupSettings are the fields of the configuration that are only used when
+// the relay starts, and keep their values when the configuration is reloaded.
+var StartupSettings = []string{
+	"Listen",
+	"EventStore",
+	"CanisterAddr",
+	"CanisterId",
+	"SecKey",
+	"EphemeralRateLimits",
+	"ApproximateCount",
+	"PProf",
+	"Metrics",
+	"PollFrequency",
+	"PollOverlap",
+	"WritePolicy",
+}
+
+// secretFields are the JSON fields of the configuration whose values are not
+// logged.
+var secretFields = map[string]bool{"seckey": true}
+
+// Reload reads the configuration and relay information document from the
+// ConfigPath and InfoPath again and applies them to the running relay without
+// closing any connections.
+//
+// Nothing is changed if the new configuration is invalid. The StartupSettings
+// keep their current values, with a warning if they differ in the file, and
+// the values given on the command line in Args take precedence over the file,
+// as they do at startup.
+//
+// The configuration and relay information document are replaced as a whole,
+// so the goroutines of the relay see either the old or the new values.
+func (rl *Relay) Reload() (err error) {
+	if rl.ConfigPath == "" {
+		return log.E.Err("no configuration file to reload")
+	}
+	// fields missing from the file have their default values, as they do at
+	// startup
+	conf := base.GetDefaultConfig()
+	if err = conf.Load(rl.ConfigPath); chk.E(err) {
+		return
+	}
+	if rl.Args != nil {
+		conf.Override(rl.Args)
+	}
+	var inf *relayinfo.T
+	if rl.InfoPath != "" {
+		inf = &relayinfo.T{}
+		if err = inf.Load(rl.InfoPath); chk.E(err) {
+			return
+		}
+	}
+	rl.configMx.Lock()
+	defer rl.configMx.Unlock()
+	old := rl.Config()
+	conf.Profile = old.Profile
+	for _, name := range keepStartupSettings(conf, old) {
+		log.W.F("%s cannot be changed without a restart, keeping the "+
+			"current value", name)
+	}
+	if err = conf.Validate(); chk.E(err) {
+		return
+	}
+	if inf == nil {
+		// there is no file, so keep the current document with the new
+		// settings applied
+		if inf, err = cloneInfo(rl.Info()); chk.E(err) {
+			return
+		}
+	}
+	setInfo(inf, conf, rl.RelayPubHex)
+	changes := diffJSON("", old, conf)
+	changes = append(changes, diffJSON("info.", rl.Info(), inf)...)
+	if len(changes) == 0 {
+		log.D.Ln("configuration reloaded, nothing changed")
+		return
+	}
+	log.I.F("configuration reloaded:\n%s", strings.Join(changes, "\n"))
+	if !reflect.DeepEqual(conf.RateLimits, old.RateLimits) ||
+		conf.ConnRateLimit != old.ConnRateLimit {
+		rl.SetLimits(NewRateLimits(conf))
+	}
+	added, removed := rl.ACL.SetOwners(conf.Owners)
+	for _, pub := range added {
+		log.I.Ln("added owner pubkey", pub)
+	}
+	for _, pub := range removed {
+		log.I.Ln("removed owner pubkey", pub)
+	}
+	if rl.Badger != nil && (conf.DBSizeLimit != old.DBSizeLimit ||
+		conf.DBLowWater != old.DBLowWater ||
+		conf.DBHighWater != old.DBHighWater ||
+		conf.GCFrequency != old.GCFrequency) {
+		rl.Badger.SetGCParams(conf.DBSizeLimit, conf.DBLowWater,
+			conf.DBHighWater, time.Duration(conf.GCFrequency)*time.Second)
+	}
+	if rl.Badger != nil && !reflect.DeepEqual(conf.Retention, old.Retention) {
+		rl.Badger.SetRetention(rl.NewRetention(conf))
+	}
+	if conf.LogLevel != "" && conf.LogLevel != old.LogLevel {
+		for i := range slog.LevelSpecs {
+			if slog.LevelSpecs[i].Name[:1] == strings.ToLower(conf.LogLevel[:1]) {
+				slog.SetLogLevel(i)
+			}
+		}
+	}
+	if conf.GCRatio > 0 && conf.GCRatio != old.GCRatio {
+		debug.SetGCPercent(conf.GCRatio)
+	}
+	if conf.MemLimit > 0 && conf.MemLimit != old.MemLimit {
+		debug.SetMemoryLimit(conf.MemLimit)
+	}
+	if conf.MaxProcs > 0 && conf.MaxProcs != old.MaxProcs {
+		runtime.GOMAXPROCS(conf.MaxProcs)
+	}
+	rl.SetConfig(conf)
+	rl.SetInfo(inf)
+	return
+}
+
+// WatchConfig checks the ConfigPath and InfoPath for changes at an interval,
+// and reloads the configuration when either of them is modified, until the
+// relay is shut down.
+//
+// This should be run in a goroutine.
+func (rl *Relay) WatchConfig(interval time.Duration) {
+	stamp := func() (s string) {
+		for _, path := range []string{rl.ConfigPath, rl.InfoPath} {
+			if path == "" {
+				continue
+			}
+			if fi, err := os.Stat(path); err == nil {
+				s += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+			}
+		}
+		return
+	}
+	last := stamp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rl.Ctx.Done():
+			return
+		case <-ticker.C:
+			if s := stamp(); s != last {
+				last = s
+				log.D.Ln("configuration files changed, reloading")
+				chk.E(rl.Reload())
+			}
+		}
+	}
+}
+
+// keepStartupSettings copies the StartupSettings from the current
+// configuration to a new one and returns the names of the ones that were
+// different.
+func keepStartupSettings(conf, old *base.Config) (changed []string) {
+	nv, ov := reflect.ValueOf(conf).Elem(), reflect.ValueOf(old).Elem()
+	for _, name := range StartupSettings {
+		n, o := nv.FieldByName(name), ov.FieldByName(name)
+		if !reflect.DeepEqual(n.Interface(), o.Interface()) {
+			changed = append(changed, name)
+			n.Set(o)
+		}
+	}
+	return
+}
+
+// diffJSON returns a description of each field that differs between the JSON
+// encodings of two values, with the name of the field prefixed.
+func diffJSON(prefix string, a, b any) (changes []string) {
+	var am, bm map[string]json.RawMessage
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	if chk.E(json.Unmarshal(ab, &am)) || chk.E(json.Unmarshal(bb, &bm)) {
+		return
+	}
+	names := make(map[string]struct{})
+	for name := range am {
+		names[name] = struct{}{}
+	}
+	for name := range bm {
+		names[name] = struct{}{}
+	}
+	for name := range names {
+		av, bv := am[name], bm[name]
+		if bytes.Equal(av, bv) {
+			continue
+		}
+		if secretFields[name] {
+			changes = append(changes, fmt.Sprintf("%s%s changed", prefix,
+				name))
+			continue
+		}
+		if av == nil {
+			av = json.RawMessage("null")
+		}
+		if bv == nil {
+			bv = json.RawMessage("null")
+		}
+		changes = append(changes, fmt.Sprintf("%s%s: %s -> %s", prefix, name,
+			av, bv))
+	}
+	sort.Strings(changes)
+	return
+}
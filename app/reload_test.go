@@ -0,0 +1,153 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+)
+
+func TestReload(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	dir := t.TempDir()
+	owner1, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	owner2, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	conf := base.GetDefaultConfig()
+	conf.SecKey = keys.GeneratePrivateKey()
+	conf.Owners = []string{owner1}
+	inf := &relayinfo.T{Name: "before"}
+	rl := NewRelay(c, cancel, inf, conf)
+	rl.ConfigPath = filepath.Join(dir, "config.json")
+	rl.InfoPath = filepath.Join(dir, "info.json")
+	// write the changed configuration
+	changed := *conf
+	changed.Owners = []string{owner2}
+	changed.Listen = []string{"127.0.0.1:1"}
+	changed.Whitelist = []string{"10.0.0.1"}
+	changed.ConnRateLimit = 5
+	changed.DBLowWater, changed.DBHighWater = 50, 60
+	if err := changed.Save(rl.ConfigPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&relayinfo.T{Name: "after"}).Save(rl.InfoPath); err != nil {
+		t.Fatal(err)
+	}
+	// the relay keeps reading the configuration while it is reloaded
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = rl.Config().ConnRateLimit + len(rl.Whitelist()) +
+					rl.Info().Limitation.MaxConnectionsPerMinute
+			}
+		}
+	}()
+	limits := rl.Limits()
+	if err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rl.ACL.GetRole(owner1) != acl.None || rl.ACL.GetRole(owner2) != acl.Owner {
+		t.Errorf("owners not changed")
+	}
+	if rl.Config().Listen[0] != conf.Listen[0] {
+		t.Errorf("listen address changed without restart")
+	}
+	if len(rl.Whitelist()) != 1 || rl.Config().ConnRateLimit != 5 ||
+		rl.Limits() == limits {
+		t.Errorf("whitelist and rate limits not changed")
+	}
+	if rl.Info().Name != "after" || rl.Info().PubKey != rl.RelayPubHex ||
+		rl.Info().Limitation.MaxConnectionsPerMinute != 5 {
+		t.Errorf("relay information not changed: %+v", rl.Info())
+	}
+	// an invalid configuration is not applied
+	changed.DBSizeLimit, changed.DBLowWater = 1000, 70
+	if err := changed.Save(rl.ConfigPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Reload(); err == nil {
+		t.Errorf("expected invalid water marks to be refused")
+	}
+	if rl.Config().DBLowWater != 50 {
+		t.Errorf("invalid configuration was applied")
+	}
+	// the files are watched for changes
+	go rl.WatchConfig(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	changed.DBLowWater = 40
+	if err := changed.Save(rl.ConfigPath); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; rl.Config().DBLowWater != 40; i++ {
+		if i == 100 {
+			t.Fatal("changed configuration file was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// fields missing from the file have their default values
+	if err := os.WriteFile(rl.ConfigPath, []byte(`{"owners":["`+owner2+
+		`"],"name":"minimal"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	defaults := base.GetDefaultConfig()
+	if rl.Config().Name != "minimal" ||
+		rl.Config().SendQueueSize != defaults.SendQueueSize ||
+		rl.Config().DBHighWater != defaults.DBHighWater ||
+		rl.Config().SlowConsumerPolicy != defaults.SlowConsumerPolicy {
+		t.Errorf("missing fields do not have their default values: %+v",
+			rl.Config())
+	}
+	if len(rl.Config().Retention.KindPriority) !=
+		len(defaults.Retention.KindPriority) {
+		t.Errorf("expected default kind priorities, got %v",
+			rl.Config().Retention.KindPriority)
+	}
+	// maps in the file replace the defaults instead of adding to them
+	if err := os.WriteFile(rl.ConfigPath, []byte(`{"owners":["`+owner2+
+		`"],"rate_limits":{},"retention":{"kind_priority":{"7":2}}}`),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kp := rl.Config().Retention.KindPriority; len(kp) != 1 || kp[7] != 2 {
+		t.Errorf("expected only the kind priority in the file, got %v", kp)
+	}
+	if rl.Config().RateLimits == nil || len(rl.Config().RateLimits) != 0 {
+		t.Errorf("expected no rate limits, got %v", rl.Config().RateLimits)
+	}
+	// values given on the command line are kept when the file is reloaded
+	rl.Args = &base.Config{DBSizeLimit: 1000, Name: "from args"}
+	if err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rl.Config().DBSizeLimit != 1000 || rl.Config().Name != "from args" {
+		t.Errorf("command line values lost on reload: %d '%s'",
+			rl.Config().DBSizeLimit, rl.Config().Name)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	a := &base.Config{Name: "a", SecKey: "1"}
+	b := &base.Config{Name: "b", SecKey: "2"}
+	changes := strings.Join(diffJSON("", a, b), "\n")
+	if changes != "name: \"a\" -> \"b\"\nseckey changed" {
+		t.Fatalf("unexpected diff:\n%s", changes)
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+)
+
+// NewRetention creates the garbage collector retention rules of the badger
+// event store from the configuration. The events of members of the pinned ACL
+// roles are kept as the ACL changes, and the events of the relay itself, which
+// include the ACL, are always kept.
+func (rl *Relay) NewRetention(conf *base.Config) (r *badger.Retention) {
+	rc := conf.Retention
+	r = &badger.Retention{
+		PinKinds:    make(map[kind.T]bool),
+		Priority:    make(map[kind.T]int),
+		MaxAge:      make(map[kind.T]time.Duration),
+		AuthorQuota: rc.AuthorQuota,
+	}
+	for _, k := range rc.PinKinds {
+		r.PinKinds[kind.T(k)] = true
+	}
+	for k, p := range rc.KindPriority {
+		r.Priority[kind.T(k)] = p
+	}
+	for k, age := range rc.MaxAge {
+		r.MaxAge[kind.T(k)] = age
+	}
+	pubs := make(map[string]bool)
+	for _, pub := range rc.PinPubKeys {
+		pubs[pub] = true
+	}
+	if rl.RelayPubHex != "" {
+		pubs[rl.RelayPubHex] = true
+	}
+	roles := make(map[acl.Role]bool)
+	for _, name := range rc.PinRoles {
+		role, ok := acl.ParseRole(name)
+		if !ok {
+			log.W.F("unknown role '%s' in retention rules", name)
+			continue
+		}
+		roles[role] = true
+	}
+	r.Pinned = func(pub string) bool {
+		if pubs[pub] {
+			return true
+		}
+		if len(roles) == 0 || rl.ACL == nil {
+			return false
+		}
+		return roles[rl.ACL.GetRole(pub)]
+	}
+	return
+}
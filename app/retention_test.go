@@ -0,0 +1,48 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+)
+
+func TestNewRetention(t *testing.T) {
+	writer, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	pinned, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	other, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	relay, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	conf := &base.Config{Retention: base.Retention{
+		PinPubKeys:   []string{pinned},
+		PinRoles:     []string{"writer", "nobody"},
+		PinKinds:     []int{3},
+		KindPriority: map[int]int{30023: 1},
+		MaxAge:       map[int]time.Duration{7: time.Hour},
+		AuthorQuota:  100,
+	}}
+	rl := &Relay{RelayPubHex: relay, ACL: &acl.T{}}
+	rl.SetConfig(conf)
+	r := rl.NewRetention(conf)
+	if !r.PinKinds[kind.FollowList] || r.Priority[30023] != 1 ||
+		r.MaxAge[kind.Reaction] != time.Hour || r.AuthorQuota != 100 {
+		t.Fatalf("retention rules do not match the configuration")
+	}
+	if !r.Pinned(pinned) || r.Pinned(writer) || r.Pinned(other) {
+		t.Fatalf("only the pinned pubkey should be pinned")
+	}
+	// the ACL events of the relay are kept whatever the rules say
+	if !r.Pinned(relay) || !rl.NewRetention(&base.Config{}).Pinned(relay) {
+		t.Fatalf("the relay pubkey should always be pinned")
+	}
+	// members of pinned roles are pinned when they are added to the ACL
+	if err := rl.ACL.AddEntry(&acl.Entry{Role: acl.Writer,
+		Pubkey: writer}); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Pinned(writer) || r.Pinned(other) {
+		t.Errorf("writer should be pinned")
+	}
+}
@@ -1,13 +1,237 @@
 package app
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
+	"github.com/fasthttp/websocket"
+)
+
+const (
+	// StrikeExpiry is the time after which the rate limit violations of an
+	// address are forgotten if it has not offended again.
+	StrikeExpiry = time.Hour
+	// ForgetAfter is the time after a ban ends that the record of the
+	// offenses of an address is removed.
+	ForgetAfter = 30 * 24 * time.Hour
+	// MaxBanDoublings is the maximum number of times the ban duration is
+	// doubled for repeat offenders.
+	MaxBanDoublings = 10
+)
+
+// Spammer is the record of an IP address or public key that has been banned
+// from the relay.
 type Spammer struct {
-	Address     string
-	Offenses    int
-	BannedUntil time.Time
+	Address     string    `json:"address"`
+	Offenses    int       `json:"offenses"`
+	BannedUntil time.Time `json:"banned_until"`
+	// Reason is why the address was last banned.
+	Reason string `json:"reason,omitempty"`
+	// Strikes is the number of rate limit violations since the last ban.
+	Strikes    int       `json:"-"`
+	LastStrike time.Time `json:"-"`
 }
 
+// Spam is the list of IP addresses and public keys currently banned from the
+// relay.
 type Spam struct {
-	Spammers []Spam
+	sync.Mutex
+	Spammers map[string]*Spammer
+	// Path is the file the bans are saved to when they change, if set.
+	Path string
+}
+
+// NewSpam creates an empty ban list.
+func NewSpam() *Spam { return &Spam{Spammers: make(map[string]*Spammer)} }
+
+// Ban adds an offense to the address and bans it until the given time for a
+// reason. If the address is already banned until a later time, the later time
+// is kept.
+func (s *Spam) Ban(address string, until time.Time,
+	reason string) (sp *Spammer) {
+
+	s.Lock()
+	defer s.Unlock()
+	sp = s.ban(s.get(address), until, reason)
+	chk.E(s.save())
+	return
+}
+
+// get returns the record of an address, creating it if necessary.
+func (s *Spam) get(address string) (sp *Spammer) {
+	var ok bool
+	if sp, ok = s.Spammers[address]; !ok {
+		sp = &Spammer{Address: address}
+		s.Spammers[address] = sp
+	}
+	return
+}
+
+func (s *Spam) ban(sp *Spammer, until time.Time, reason string) *Spammer {
+	sp.Offenses++
+	sp.Reason = reason
+	sp.Strikes = 0
+	if until.After(sp.BannedUntil) {
+		sp.BannedUntil = until
+	}
+	return sp
+}
+
+// Strike records a rate limit violation by an address. After banAfter strikes
+// the address is banned for banDuration, doubled for each previous ban, and
+// the end of the ban is returned. A banAfter or banDuration of zero disables
+// banning.
+func (s *Spam) Strike(address string, banAfter int,
+	banDuration time.Duration) (until time.Time, banned bool) {
+
+	if banAfter <= 0 || banDuration <= 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	sp := s.get(address)
+	if now.Sub(sp.LastStrike) > StrikeExpiry {
+		sp.Strikes = 0
+	}
+	sp.Strikes++
+	sp.LastStrike = now
+	if sp.Strikes < banAfter {
+		return
+	}
+	until = now.Add(banDuration << min(sp.Offenses, MaxBanDoublings))
+	s.ban(sp, until, "exceeded rate limits")
+	chk.E(s.save())
+	return until, true
+}
+
+// Unban lifts the ban on an address, the offense count is retained.
+func (s *Spam) Unban(address string) {
+	s.Lock()
+	defer s.Unlock()
+	if sp, ok := s.Spammers[address]; ok {
+		sp.BannedUntil = time.Now()
+		chk.E(s.save())
+	}
+}
+
+// IsBanned returns true and the time the ban ends if any of the addresses
+// given are currently banned. Empty addresses are ignored.
+func (s *Spam) IsBanned(addresses ...string) (until time.Time, banned bool) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		if sp, ok := s.Spammers[address]; ok && sp.BannedUntil.After(now) {
+			if sp.BannedUntil.After(until) {
+				until = sp.BannedUntil
+			}
+			banned = true
+		}
+	}
+	return
+}
+
+// Banned returns the list of currently banned addresses.
+func (s *Spam) Banned() (spammers []Spammer) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, sp := range s.Spammers {
+		if sp.BannedUntil.After(now) {
+			spammers = append(spammers, *sp)
+		}
+	}
+	return
+}
+
+// Load reads the bans saved in a file and sets the Path that changes are saved
+// to. A missing file is not an error.
+func (s *Spam) Load(path string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.Path = path
+	var b []byte
+	if b, err = os.ReadFile(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if chk.E(err) {
+		return
+	}
+	var spammers []*Spammer
+	if err = json.Unmarshal(b, &spammers); chk.E(err) {
+		return
+	}
+	for _, sp := range spammers {
+		s.Spammers[sp.Address] = sp
+	}
+	log.D.F("loaded %d banned addresses from %s", len(spammers), path)
+	return
+}
+
+// save writes the records of addresses that have been banned to the Path, and
+// forgets the ones whose ban ended more than ForgetAfter ago. The lock must be
+// held by the caller.
+func (s *Spam) save() (err error) {
+	if s.Path == "" {
+		return
+	}
+	spammers := make([]*Spammer, 0, len(s.Spammers))
+	now := time.Now()
+	for address, sp := range s.Spammers {
+		if sp.Offenses == 0 {
+			continue
+		}
+		if now.Sub(sp.BannedUntil) > ForgetAfter {
+			delete(s.Spammers, address)
+			continue
+		}
+		spammers = append(spammers, sp)
+	}
+	var b []byte
+	if b, err = json.MarshalIndent(spammers, "", "    "); chk.E(err) {
+		return
+	}
+	return os.WriteFile(s.Path, b, 0600)
+}
+
+// RemoteHost strips the port from a remote address so it can be matched
+// against a banned IP address.
+func RemoteHost(remote string) (host string) {
+	var err error
+	if host, _, err = net.SplitHostPort(remote); err != nil {
+		host = remote
+	}
+	return
+}
+
+// IsBanned returns true and the time the ban ends if the IP address or the
+// authenticated pubkey of a connection is banned.
+func (rl *Relay) IsBanned(ws *relayws.WebSocket) (until time.Time, banned bool) {
+	return rl.Spam.IsBanned(RemoteHost(ws.RealRemote()), ws.AuthPubKey())
+}
+
+// Disconnect sends a websocket close control message to all connected clients
+// that match and closes them, returning the number of connections closed.
+func (rl *Relay) Disconnect(match func(ws *relayws.WebSocket) bool) (n int) {
+	rl.clients.Range(func(conn *websocket.Conn, ws *relayws.WebSocket) bool {
+		if !match(ws) {
+			return true
+		}
+		chk.E(conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""),
+			time.Now().Add(rl.WriteWait)))
+		chk.E(conn.Close())
+		n++
+		return true
+	})
+	return
 }
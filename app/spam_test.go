@@ -0,0 +1,69 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpam(t *testing.T) {
+	s := NewSpam()
+	if _, banned := s.IsBanned("1.2.3.4"); banned {
+		t.Fatal("address banned in empty ban list")
+	}
+	later := time.Now().Add(time.Hour)
+	s.Ban("1.2.3.4", later, "")
+	sp := s.Ban("1.2.3.4", time.Now().Add(time.Minute), "")
+	if sp.Offenses != 2 || !sp.BannedUntil.Equal(later) {
+		t.Fatalf("ban shortened or offenses not counted: %d %v",
+			sp.Offenses, sp.BannedUntil)
+	}
+	if until, banned := s.IsBanned("", "abcd",
+		RemoteHost("1.2.3.4:5678")); !banned || !until.Equal(later) {
+		t.Fatal("banned address with port not matched")
+	}
+	s.Ban("abcd", time.Now().Add(-time.Second), "")
+	if _, banned := s.IsBanned("abcd"); banned {
+		t.Fatal("expired ban still in force")
+	}
+	if len(s.Banned()) != 1 {
+		t.Fatalf("expected 1 banned address, got %d", len(s.Banned()))
+	}
+	s.Unban("1.2.3.4")
+	if _, banned := s.IsBanned("1.2.3.4"); banned {
+		t.Fatal("ban not lifted")
+	}
+}
+
+func TestSpamStrike(t *testing.T) {
+	s := NewSpam()
+	if err := s.Load(filepath.Join(t.TempDir(), "spam.json")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, banned := s.Strike("1.2.3.4", 3, time.Minute); banned {
+			t.Fatalf("banned after %d strikes", i+1)
+		}
+	}
+	first, banned := s.Strike("1.2.3.4", 3, time.Minute)
+	if !banned {
+		t.Fatal("not banned after 3 strikes")
+	}
+	s.Unban("1.2.3.4")
+	for i := 0; i < 3; i++ {
+		s.Strike("1.2.3.4", 3, time.Minute)
+	}
+	until, banned := s.IsBanned("1.2.3.4")
+	if !banned || until.Sub(first) < time.Minute {
+		t.Fatalf("second ban not escalated: %v %v", first, until)
+	}
+	// bans are restored from the file
+	s2 := NewSpam()
+	if err := s2.Load(s.Path); err != nil {
+		t.Fatal(err)
+	}
+	if until2, banned := s2.IsBanned("1.2.3.4"); !banned ||
+		!until2.Equal(until) || s2.Spammers["1.2.3.4"].Offenses != 2 {
+		t.Fatal("ban not persisted")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
 	"github.com/fasthttp/websocket"
 	"github.com/rs/cors"
 )
@@ -48,7 +49,7 @@ func (rl *Relay) Start(host string, port int,
 // Shutdown sends a websocket close control message to all connected clients.
 func (rl *Relay) Shutdown(c context.T) {
 	chk.E(rl.httpServer.Shutdown(c))
-	rl.clients.Range(func(conn *websocket.Conn, _ struct{}) bool {
+	rl.clients.Range(func(conn *websocket.Conn, _ *relayws.WebSocket) bool {
 		chk.E(conn.WriteControl(websocket.CloseMessage, nil,
 			time.Now().Add(time.Second)))
 		chk.E(conn.Close())
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/app/acl"
+	"github.com/Hubmakerlabs/replicatr/app/policy"
+	"github.com/Hubmakerlabs/replicatr/pkg/atomic"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/normalize"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/subscriptionid"
+)
+
+// ShadowRejected is the prefix of the message of a RejectEvent or RejectFilter
+// that pretends to accept an event or filter. The event is answered with OK
+// but is not stored or broadcast, and the subscription gets only an EOSE.
+const ShadowRejected = "shadow-rejected: "
+
+// ErrShadowRejected is returned by handleFilter for a shadow rejected filter.
+var ErrShadowRejected = errors.New("filter shadow rejected")
+
+// IsShadowRejected returns true if the message of a RejectEvent or
+// RejectFilter is a shadow rejection.
+func IsShadowRejected(msg string) bool {
+	return strings.HasPrefix(msg, ShadowRejected)
+}
+
+// policyRequests numbers the filter requests sent to the policy plugin.
+var policyRequests atomic.Uint64
+
+// newPolicyRequest returns a request to the policy plugin with the details of
+// the connection of a context.
+func (rl *Relay) newPolicyRequest(c context.T) (req *policy.Request) {
+	req = &policy.Request{ReceivedAt: time.Now().Unix(),
+		Role: acl.RoleStrings[acl.None]}
+	ws := GetConnection(c)
+	if ws == nil {
+		req.SourceType = "Internal"
+		return
+	}
+	req.SourceInfo = RemoteHost(ws.RealRemote())
+	req.SourceType = "IP4"
+	if ip := net.ParseIP(req.SourceInfo); ip != nil && ip.To4() == nil {
+		req.SourceType = "IP6"
+	}
+	req.Authed = ws.AuthPubKey()
+	req.Role = acl.RoleStrings[rl.GetRole(ws)]
+	return
+}
+
+// queryPolicy sends a request to the policy plugin and returns whether it is
+// rejected and the message to give the client. When the plugin cannot answer
+// the request is accepted, unless the relay is configured to fail closed.
+func (rl *Relay) queryPolicy(plugin *policy.Plugin,
+	req *policy.Request) (reject bool, msg string) {
+
+	rl.configMx.Lock()
+	timeout := rl.Config().WritePolicyTimeout
+	failClosed := rl.Config().WritePolicyFailClosed
+	rl.configMx.Unlock()
+	res, err := plugin.Query(req, timeout)
+	if err != nil {
+		if failClosed {
+			log.W.F("rejecting %s %s: %v", req.Type, req.ID, err)
+			return true, normalize.Reason("policy check is unavailable",
+				okenvelope.Error.S())
+		}
+		log.W.F("accepting %s %s: %v", req.Type, req.ID, err)
+		return false, ""
+	}
+	switch res.Action {
+	case policy.Reject:
+		if res.Msg == "" {
+			res.Msg = "rejected by relay policy"
+		}
+		return true, normalize.Reason(res.Msg, okenvelope.Blocked.S())
+	case policy.ShadowReject:
+		log.D.F("shadow rejected %s %s %s %s", req.Type, req.ID,
+			req.SourceInfo, req.Authed)
+		return true, ShadowRejected + res.Msg
+	}
+	return false, ""
+}
+
+// PolicyEvent returns a RejectEvent that sends each event to a policy plugin.
+func (rl *Relay) PolicyEvent(plugin *policy.Plugin) RejectEvent {
+	return func(c context.T, ev *event.T) (reject bool, msg string) {
+		req := rl.newPolicyRequest(c)
+		req.Type, req.ID, req.Event = policy.NewEvent, ev.ID.String(), ev
+		return rl.queryPolicy(plugin, req)
+	}
+}
+
+// PolicyFilter returns a RejectFilter that sends each filter to a policy
+// plugin.
+func (rl *Relay) PolicyFilter(plugin *policy.Plugin) RejectFilter {
+	return func(c context.T, id subscriptionid.T, f *filter.T) (reject bool,
+		msg string) {
+
+		req := rl.newPolicyRequest(c)
+		req.Type = policy.NewFilter
+		req.ID = strconv.FormatUint(policyRequests.Inc(), 10)
+		req.Subscription, req.Filter = id.String(), f
+		return rl.queryPolicy(plugin, req)
+	}
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/app/policy"
+	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayinfo"
+)
+
+func TestPolicyFailMode(t *testing.T) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	conf := base.GetDefaultConfig()
+	conf.SecKey = keys.GeneratePrivateKey()
+	rl := NewRelay(c, cancel, &relayinfo.T{}, conf)
+	// the plugin is never started
+	reject := rl.PolicyEvent(policy.New("/nonexistent"))
+	ev := &event.T{ID: "abc"}
+	if rej, msg := reject(c, ev); rej {
+		t.Errorf("fail open policy rejected event: %s", msg)
+	}
+	conf.WritePolicyFailClosed = true
+	rej, msg := reject(c, ev)
+	if !rej || IsShadowRejected(msg) {
+		t.Errorf("fail closed policy did not reject event: %s", msg)
+	}
+}
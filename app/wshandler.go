@@ -10,10 +10,47 @@ import (
 	"github.com/fasthttp/websocket"
 )
 
+// RealRemote returns the address of the client of a http request, as
+// forwarded by a reverse proxy if the request comes from one of the
+// TrustedProxies.
+//
+// The X-Forwarded-For header of any other request is ignored, as the client
+// sets it and could claim to be any address with it.
+func (rl *Relay) RealRemote(r *http.Request) (rr string) {
+	rr = r.RemoteAddr
+	if !MatchIP(rl.Config().TrustedProxies, rr) {
+		return
+	}
+	// the proxy appends the address it received the request from to any the
+	// client sent, so only the last one can be trusted.
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if last := strings.TrimSpace(forwarded[len(forwarded)-1]); last != "" {
+		rr = last
+	}
+	return
+}
+
 // HandleWebsocket is a http handler that accepts and manages websocket
 // connections.
 func (rl *Relay) HandleWebsocket(serviceURL string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		rr := rl.RealRemote(r)
+		if until, banned := rl.Spam.IsBanned(RemoteHost(rr)); banned {
+			log.T.F("refusing connection from banned address %s until %s", rr,
+				until.UTC().Format(TimeFormat))
+			http.Error(w, "banned", http.StatusForbidden)
+			return
+		}
+		if !rl.AllowConnection(RemoteHost(rr)) {
+			log.T.F("refusing connection from %s, too many connections", rr)
+			if until, banned := rl.Spam.Strike(RemoteHost(rr),
+				rl.Config().BanAfter, rl.Config().BanDuration); banned {
+				log.I.F("banned %s until %s for exceeding rate limits", rr,
+					until.UTC().Format(TimeFormat))
+			}
+			http.Error(w, "too many connections", http.StatusTooManyRequests)
+			return
+		}
 		var err error
 		var conn *websocket.Conn
 		conn, err = rl.upgrader.Upgrade(w, r, nil)
@@ -23,29 +60,25 @@ func (rl *Relay) HandleWebsocket(serviceURL string) func(w http.ResponseWriter,
 		}
 		conn.SetReadLimit(int64(MaxMessageSize))
 		conn.EnableWriteCompression(true)
-		rl.clients.Store(conn, struct{}{})
 		ticker := time.NewTicker(rl.PingPeriod)
-		rem := r.Header.Get("X-Forwarded-For")
-		splitted := strings.Split(rem, " ")
-		var rr string
-		if len(splitted) == 1 {
-			rr = splitted[0]
-		}
-		if len(splitted) == 2 {
-			rr = splitted[1]
-		}
-		// in case upstream doesn't set this or we are directly listening instead of
-		// via reverse proxy or just if the header field is missing, put the
-		// connection remote address into the websocket state data.
-		if rr == "" {
-			rr = r.RemoteAddr
-		}
 		ws := &relayws.WebSocket{
 			Conn:    conn,
 			Request: r,
 			Authed:  make(chan struct{}),
 		}
 		ws.SetRealRemote(rr)
+		if rl.Metrics != nil {
+			ws.OnWrite = rl.Metrics.Sent
+		}
+		policy, _ := relayws.ParseSlowConsumerPolicy(
+			rl.Config().SlowConsumerPolicy)
+		ws.StartWriter(relayws.QueueConfig{
+			Size:      rl.Config().SendQueueSize,
+			HighWater: rl.Config().SendQueueHighWater,
+			Policy:    policy,
+			OnSlow:    rl.Metrics.SlowConsumer,
+		})
+		rl.clients.Store(conn, ws)
 		// NIP-42 challenge
 		ws.GenerateChallenge()
 		c, cancel := context.Cancel(
@@ -54,9 +87,9 @@ func (rl *Relay) HandleWebsocket(serviceURL string) func(w http.ResponseWriter,
 				wsKey, ws,
 			),
 		)
-		if len(rl.Whitelist) > 0 {
-			for i := range rl.Whitelist {
-				if rr == rl.Whitelist[i] {
+		if len(rl.Whitelist()) > 0 {
+			for i := range rl.Whitelist() {
+				if rr == rl.Whitelist()[i] {
 					log.T.Ln("whitelisted inbound connection from", rr)
 				}
 			}
@@ -64,9 +97,9 @@ func (rl *Relay) HandleWebsocket(serviceURL string) func(w http.ResponseWriter,
 			log.T.Ln("inbound connection from", rr)
 		}
 		kill := func() {
-			if len(rl.Whitelist) > 0 {
-				for i := range rl.Whitelist {
-					if rr == rl.Whitelist[i] {
+			if len(rl.Whitelist()) > 0 {
+				for i := range rl.Whitelist() {
+					if rr == rl.Whitelist()[i] {
 						log.T.Ln("disconnecting whitelisted client from", rr)
 					}
 				}
@@ -77,6 +110,7 @@ func (rl *Relay) HandleWebsocket(serviceURL string) func(w http.ResponseWriter,
 				onDisconnect(c)
 			}
 			ticker.Stop()
+			ws.StopWriter()
 			cancel()
 			if _, ok := rl.clients.Load(conn); ok {
 				rl.clients.Delete(conn)
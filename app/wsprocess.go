@@ -6,9 +6,11 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/authenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/closedenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/closeenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/countenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eventenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/noticeenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/okenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/reqenvelope"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/interfaces/enveloper"
@@ -41,34 +43,35 @@ func (rl *Relay) wsProcessMessages(msg []byte, c context.T,
 			ws.RealRemote(), ws.AuthPubKey(), strMsg)
 		return
 	}
-	if len(msg) > rl.Info.Limitation.MaxMessageLength {
+	if rl.Info().Limitation.MaxMessageLength > 0 &&
+		len(msg) > rl.Info().Limitation.MaxMessageLength {
 		log.D.F("rejecting event with size: %d from %s %s",
 			len(msg), ws.RealRemote(), ws.AuthPubKey())
 		chk.E(ws.WriteEnvelope(&okenvelope.T{
 			OK: false,
-			Reason: normalize.Reason(okenvelope.Invalid.S(),
-				fmt.Sprintf(
-					"relay limit disallows messages larger than %d "+
-						"bytes, this message is %d bytes",
-					rl.Info.Limitation.MaxMessageLength, len(msg))),
+			Reason: normalize.Reason(fmt.Sprintf(
+				"relay limit disallows messages larger than %d "+
+					"bytes, this message is %d bytes",
+				rl.Info().Limitation.MaxMessageLength, len(msg)),
+				okenvelope.Invalid.S()),
 		}))
 		return
 	}
-	deny := true
-	if len(rl.Whitelist) > 0 {
-		for i := range rl.Whitelist {
-			if rl.Whitelist[i] == ws.RealRemote() {
-				deny = false
-			}
-		}
-	} else {
-		deny = false
-	}
-	if deny {
+	if !rl.Whitelisted(ws.RealRemote()) {
 		log.E.F("denying access to '%s' %s: dropping message", ws.RealRemote(),
 			ws.AuthPubKey())
 		return
 	}
+	if until, banned := rl.IsBanned(ws); banned {
+		log.T.F("dropping message from banned client %s %s", ws.RealRemote(),
+			ws.AuthPubKey())
+		chk.E(ws.WriteEnvelope(noticeenvelope.NewNoticeEnvelope(
+			normalize.Reason(fmt.Sprintf("banned until %s",
+				until.UTC().Format(TimeFormat)), okenvelope.Blocked.S()))))
+		kill()
+		chk.E(ws.Conn.Close())
+		return
+	}
 	var en enveloper.I
 	if en, _, err = envelopes.ProcessEnvelope(msg); log.E.Chk(err) {
 		chk.E(ws.WriteEnvelope(&okenvelope.T{
@@ -80,18 +83,36 @@ func (rl *Relay) wsProcessMessages(msg []byte, c context.T,
 		}))
 		return fmt.Errorf("invalid: error processing envelope: %s", err.Error())
 	}
+	rl.Metrics.Received(en.Label())
 	switch env := en.(type) {
 	case *eventenvelope.T:
+		if ok, reason := rl.AllowEvent(ws); !ok {
+			chk.E(ws.WriteEnvelope(&okenvelope.T{ID: env.Event.ID, OK: false,
+				Reason: reason}))
+			rl.Offend(ws)
+			return
+		}
 		if err = rl.processEventEnvelope(msg, env, c, ws,
 			serviceURL); err != nil {
 			return
 		}
 	case *countenvelope.Request:
+		if ok, reason := rl.AllowReq(ws); !ok {
+			chk.E(ws.WriteEnvelope(closedenvelope.New(env.ID, reason)))
+			rl.Offend(ws)
+			return
+		}
 		if err = rl.processCountEnvelope(msg, env, c, ws,
 			serviceURL); chk.E(err) {
 			return
 		}
 	case *reqenvelope.T:
+		if ok, reason := rl.AllowReq(ws); !ok {
+			chk.E(ws.WriteEnvelope(closedenvelope.New(env.SubscriptionID,
+				reason)))
+			rl.Offend(ws)
+			return
+		}
 		if err = rl.processReqEnvelope(msg, env, c, ws,
 			serviceURL); chk.E(err) {
 			return
@@ -25,17 +25,7 @@ func (rl *Relay) websocketReadMessages(p readParams) {
 			p.ws.RealRemote(), p.ws.AuthPubKey())
 		return
 	}
-	deny := true
-	if len(rl.Whitelist) > 0 {
-		for i := range rl.Whitelist {
-			if rl.Whitelist[i] == p.ws.RealRemote() {
-				deny = false
-			}
-		}
-	} else {
-		deny = false
-	}
-	if deny {
+	if !rl.Whitelisted(p.ws.RealRemote()) {
 		// log.T.F("denying access to '%s': dropping message",
 		// 	p.ws.RealRemote())
 		// p.kill()
@@ -3,6 +3,7 @@ module github.com/Hubmakerlabs/replicatr
 go 1.22
 
 require (
+	github.com/aead/chacha20 v0.0.0-20180709150244-8b13a72661da
 	github.com/alexflint/go-arg v1.5.0
 	github.com/aviate-labs/agent-go v0.3.4
 	github.com/aviate-labs/secp256k1 v0.0.0-5e6736a
@@ -32,7 +33,6 @@ require (
 
 require (
 	github.com/BurntSushi/toml v1.3.2 // indirect
-	github.com/aead/chacha20 v0.0.0-20180709150244-8b13a72661da // indirect
 	github.com/alexflint/go-scalar v1.2.0 // indirect
 	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/aviate-labs/leb128 v0.3.0 // indirect
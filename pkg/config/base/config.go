@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"reflect"
 	"time"
 
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/relayws"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"github.com/Hubmakerlabs/replicatr/pkg/slog"
+	"golang.org/x/exp/maps"
 )
 
 var log, chk = slog.New(os.Stderr)
@@ -21,6 +25,11 @@ type ImportCmd struct {
 	StartingFrom int      `arg:"--importfrom" help:"start scanning import file from this position in bytes"`
 }
 
+type GCCmd struct {
+	Apply bool `arg:"--apply" help:"prune the events and indexes in the report"`
+	Top   int  `arg:"--top" help:"number of authors to list in the report (default 20)"`
+}
+
 type InitCfg struct{}
 type WipeBDB struct{}
 type RescanAC struct{}
@@ -36,6 +45,49 @@ type RemoveRelay struct {
 type GetPermission struct {
 }
 
+// RateLimit is the number of EVENT and REQ (or COUNT) messages a client with an
+// ACL role can send per minute. Zero means no limit.
+type RateLimit struct {
+	Events int `json:"events"`
+	Reqs   int `json:"reqs"`
+}
+
+// PowLimit is the minimum NIP-13 proof of work difficulty of events from a
+// client with an ACL role, with different minimums for some kinds.
+type PowLimit struct {
+	Difficulty int         `json:"difficulty"`
+	Kinds      map[int]int `json:"kinds,omitempty"`
+}
+
+// Required returns the minimum difficulty of events of a kind.
+func (p PowLimit) Required(k int) int {
+	if d, ok := p.Kinds[k]; ok {
+		return d
+	}
+	return p.Difficulty
+}
+
+// Retention are the rules the garbage collector of the badger event store
+// applies to decide which events are pruned. Events that are not pinned are
+// pruned by the priority of their kind, then the least recently accessed first.
+type Retention struct {
+	// PinPubKeys are the public keys whose events are never pruned.
+	PinPubKeys []string `json:"pin_pubkeys,omitempty"`
+	// PinRoles are the ACL roles whose members' events are never pruned.
+	PinRoles []string `json:"pin_roles,omitempty"`
+	// PinKinds are the kinds of events that are never pruned.
+	PinKinds []int `json:"pin_kinds,omitempty"`
+	// KindPriority ranks kinds for pruning, events of kinds with a higher
+	// priority are pruned after those with a lower one. Kinds not listed have
+	// priority zero.
+	KindPriority map[int]int `json:"kind_priority,omitempty"`
+	// MaxAge is the age after which events of a kind are always pruned.
+	MaxAge map[int]time.Duration `json:"max_age,omitempty"`
+	// AuthorQuota is the most events of an author that are kept, the oldest
+	// beyond which are always pruned, or zero for no limit.
+	AuthorQuota int `json:"author_quota,omitempty"`
+}
+
 func GetDefaultConfig() *Config {
 	return &Config{
 		Listen:        []string{"0.0.0.0:3334"},
@@ -55,6 +107,16 @@ func GetDefaultConfig() *Config {
 		MemLimit:      500000000,
 		PollFrequency: 5 * time.Second,
 		PollOverlap:   4,
+		// rate limits and bans are off unless they are configured
+		SendQueueSize:      1024,
+		SendQueueHighWater: 768,
+		SlowConsumerPolicy: "drop",
+		WritePolicyTimeout: 2 * time.Second,
+		Retention: Retention{
+			PinRoles: []string{"owner", "admin", "writer"},
+			// profiles, follow lists, relay lists and long form articles
+			KindPriority: map[int]int{0: 1, 3: 1, 10002: 1, 30023: 1},
+		},
 	}
 }
 
@@ -67,6 +129,7 @@ type Config struct {
 	RemoveRelayCmd   *RemoveRelay   `arg:"subcommand:removerelay" json:"-" help:"remove a relay from the cluster"`
 	GetPermissionCmd *GetPermission `arg:"subcommand:getpermission" json:"-" help:"get permission of a relay"`
 	Wipe             *WipeBDB       `arg:"subcommand:wipebdb" json:"-" help:"empties local badger database (bdb)"`
+	GC               *GCCmd         `arg:"subcommand:gc" json:"-" help:"report what the garbage collector would prune from the badger database (bdb)"`
 	// Rescan           *RescanAC      `arg:"subcommand:rescan" json:"-" help:"clear and regenerate access counter records"`
 	Listen       []string `arg:"-l,--listen,separate" json:"listen" help:"network address to listen on"`
 	EventStore   string   `arg:"-e,--eventstore" json:"eventstore" help:"select event store backend [ic,badger,iconly]"`
@@ -89,6 +152,64 @@ type Config struct {
 	// developer, as these are stable, non-routeable addresses, this skips the
 	// requirement enforced by AuthRequired.
 	AllowIPs []string `arg:"-A,--allow,separate" json:"allow_ip" help:"IP addresses that are always allowed to access"`
+	// TrustedProxies are the addresses of reverse proxies whose
+	// X-Forwarded-For header is used as the address of a client. The header is
+	// ignored on connections from any other address, as it is set by the
+	// client and anyone could claim any address with it.
+	TrustedProxies []string `arg:"--trustedproxy,separate" json:"trusted_proxies,omitempty" help:"IP addresses of reverse proxies whose X-Forwarded-For header is trusted (can use flag repeatedly)"`
+	// NoEphemeral disables relaying ephemeral events to subscribers.
+	NoEphemeral bool `arg:"--noephemeral" json:"no_ephemeral" help:"do not relay ephemeral events (kinds 20000-29999) to subscribers"`
+	// EphemeralRateLimits is the number of ephemeral events of a kind a client
+	// can send per minute, kinds not listed are not limited.
+	EphemeralRateLimits map[int]int `arg:"--ephemeralrate" json:"ephemeral_rate_limits,omitempty" help:"limit ephemeral events of a kind per client per minute, as kind=count"`
+	// AllowedKinds are the only event kinds accepted by the relay if any are
+	// listed.
+	AllowedKinds []int `arg:"--allowkind,separate" json:"allowed_kinds,omitempty" help:"only accept events of these kinds (can use flag repeatedly)"`
+	// DisallowedKinds are event kinds that are never accepted by the relay.
+	DisallowedKinds []int `arg:"--disallowkind,separate" json:"disallowed_kinds,omitempty" help:"never accept events of these kinds (can use flag repeatedly)"`
+	// RateLimits are the limits on messages from clients of each ACL role, by
+	// the role name. Roles that are not listed are not limited.
+	RateLimits map[string]RateLimit `arg:"-" json:"rate_limits,omitempty"`
+	// PowDifficulty are the minimum proof of work difficulties of events from
+	// clients of each ACL role, by the role name, where unauthenticated
+	// clients are "none". Roles that are not listed need no proof of work.
+	PowDifficulty map[string]PowLimit `arg:"-" json:"pow_difficulty,omitempty"`
+	// ConnRateLimit is the number of connections an IP address can open per
+	// minute.
+	ConnRateLimit int `arg:"--connrate" json:"conn_rate_limit" help:"number of connections an IP address can open per minute (0 for no limit)"`
+	// BanAfter is the number of times a client can exceed the rate limits
+	// before it is banned.
+	BanAfter int `arg:"--banafter" json:"ban_after" help:"number of rate limit violations before a client is banned (0 to never ban)"`
+	// BanDuration is the duration of the first ban of a client, which doubles
+	// with each further ban. Clients are only banned if both BanAfter and
+	// BanDuration are set.
+	BanDuration time.Duration `arg:"--banduration" json:"ban_duration" help:"duration of the first ban for exceeding rate limits, doubling for each further ban (0 to never ban)"`
+	// SendQueueSize is the maximum number of messages waiting to be sent to a
+	// client.
+	SendQueueSize int `arg:"--sendqueue" json:"send_queue_size" help:"maximum number of messages waiting to be sent to a client"`
+	// SendQueueHighWater is the number of messages waiting to be sent to a
+	// client at which the SlowConsumerPolicy is applied.
+	SendQueueHighWater int `arg:"--sendqueuehighwater" json:"send_queue_high_water" help:"number of messages waiting to be sent to a client at which it is treated as a slow consumer"`
+	// SlowConsumerPolicy is what is done with clients that do not read their
+	// messages fast enough, either dropping events sent to them or closing the
+	// connection.
+	SlowConsumerPolicy string `arg:"--slowconsumer" json:"slow_consumer_policy" help:"what to do with clients that read too slowly [drop,close]"`
+	// WritePolicy is the path of an executable that is sent each event and
+	// filter received from clients, and answers whether to accept them.
+	WritePolicy string `arg:"--writepolicy" json:"write_policy,omitempty" help:"path of a policy plugin that decides whether to accept events and filters"`
+	// WritePolicyTimeout is how long the WritePolicy plugin has to answer.
+	WritePolicyTimeout time.Duration `arg:"--writepolicytimeout" json:"write_policy_timeout,omitempty" help:"time the policy plugin has to answer each event or filter"`
+	// WritePolicyFailClosed rejects events and filters when the WritePolicy
+	// plugin is not running or does not answer in time, instead of accepting
+	// them.
+	WritePolicyFailClosed bool `arg:"--writepolicyfailclosed" json:"write_policy_fail_closed,omitempty" help:"reject events and filters when the policy plugin does not answer"`
+	// ExportDir is the directory the export chat command writes files to,
+	// which must be outside the badger event store. If it is empty, a
+	// directory next to the event store named after it is used.
+	ExportDir string `arg:"--exportdir" json:"export_dir,omitempty" help:"directory the export chat command writes files to (default <profile>-exports next to the profile directory)"`
+	// Retention are the rules for which events are pruned from the badger
+	// event store.
+	Retention Retention `arg:"-" json:"retention"`
 	// DBSizeLimit configures a target maximum size to maintain the local
 	// event store cache at, in megabytes (1,000,000 bytes).
 	DBSizeLimit int `arg:"-S,--sizelimit" json:"db_size_limit" help:"set the maximum size of the badger event store in bytes"` // default:"0"
@@ -98,6 +219,9 @@ type Config struct {
 	// DBHighWater is the proportion of the DBSizeLimit at which a garbage
 	// collection run is triggered.
 	DBHighWater int `arg:"-H,--highwater" json:"db_high_water" help:"set garbage collection trigger percentage for database size during garbage collection"` // default:"92"
+	// ApproximateCount is the number of events matching a COUNT above which the
+	// count is estimated rather than exact, or zero to always count exactly.
+	ApproximateCount int `arg:"--approxcount" json:"approximate_count_above" help:"number of matching events above which COUNT results are approximate (0 to always count exactly)"`
 	// GCFrequency is the frequency to run a check on the database size and
 	// if it breaches DBHighWater to prune it back to DBLowWater percentage
 	// of DBSizeLimit in minutes.
@@ -105,8 +229,11 @@ type Config struct {
 	MaxProcs    int    `arg:"--maxprocs" json:"max_procs" help:"maximum number of goroutines to use"`                                               // default:"128"
 	LogLevel    string `arg:"--loglevel"  help:"set log level [off,fatal,error,warn,info,debug,trace] (can also use GODEBUG environment variable)"` // default:"info"
 	PProf       bool   `arg:"--pprof" help:"enable CPU and memory profiling"`
-	GCRatio     int    `arg:"--gcratio" help:"set GC percentage for triggering GC sweeps"`             // default:"100"
-	MemLimit    int64  `arg:"--memlimit" help:"set memory limit on process to constrain memory usage"` // default:"500000000"
+	// Metrics enables the /metrics endpoint and the /debug/pprof endpoints,
+	// which are only served to the AllowIPs.
+	Metrics  bool  `arg:"--metrics" json:"metrics" help:"serve prometheus metrics on /metrics and runtime profiles on /debug/pprof to allowed IPs"`
+	GCRatio  int   `arg:"--gcratio" help:"set GC percentage for triggering GC sweeps"`             // default:"100"
+	MemLimit int64 `arg:"--memlimit" help:"set memory limit on process to constrain memory usage"` // default:"500000000"
 	// PollFrequency is how often the L2 is queried for recent events
 	PollFrequency time.Duration `arg:"--pollfrequency" help:"if a level 2 event store is enabled how often it polls"`
 	// PollOverlap is the multiple of the PollFrequency within which polling the L2
@@ -141,8 +268,243 @@ func (c *Config) Load(filename string) (err error) {
 		return
 	}
 	// log.D.F("configuration\n%s", string(b))
+	// json.Unmarshal merges maps into the ones already set, such as the
+	// defaults, so they are cleared and only restored if the file has none.
+	// Otherwise a file could never remove an entry from a default map.
+	maps := mapFields(reflect.ValueOf(c).Elem())
+	defaults := make([]reflect.Value, len(maps))
+	for i, m := range maps {
+		defaults[i] = reflect.ValueOf(m.Interface())
+		m.SetZero()
+	}
 	if err = json.Unmarshal(b, c); chk.E(err) {
 		return
 	}
+	for i, m := range maps {
+		if m.IsNil() {
+			m.Set(defaults[i])
+		}
+	}
+	return
+}
+
+// mapFields returns the settable map fields of a struct and of the structs in
+// it.
+func mapFields(v reflect.Value) (maps []reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Map:
+			maps = append(maps, f)
+		case reflect.Struct:
+			maps = append(maps, mapFields(f)...)
+		}
+	}
+	return
+}
+
+// Override sets the fields of the configuration that were given on the command
+// line, which are the ones in args that are not empty.
+func (c *Config) Override(args *Config) {
+	if len(args.Listen) > 0 {
+		c.Listen = args.Listen
+	}
+	if args.Profile != "" {
+		c.Profile = args.Profile
+	}
+	if args.Name != "" {
+		c.Name = args.Name
+	}
+	if args.Description != "" {
+		c.Description = args.Description
+	}
+	if args.Pubkey != "" {
+		c.Pubkey = args.Pubkey
+	}
+	if args.Contact != "" {
+		c.Contact = args.Contact
+	}
+	if args.Icon != "" {
+		c.Icon = args.Icon
+	}
+	// CLI args on "separate" items add to the ones in the config
+	if len(args.Whitelist) == 0 {
+		c.Whitelist = append(c.Whitelist, args.Whitelist...)
+	}
+	if len(args.Owners) == 0 {
+		c.Owners = append(c.Owners, args.Owners...)
+	}
+	if args.SecKey != "" {
+		c.SecKey = args.SecKey
+	}
+	if args.DBSizeLimit != 0 {
+		c.DBSizeLimit = args.DBSizeLimit
+	}
+	if args.DBLowWater != 0 {
+		c.DBLowWater = args.DBLowWater
+	}
+	if args.DBHighWater != 0 {
+		c.DBHighWater = args.DBHighWater
+	}
+	if args.GCFrequency != 0 {
+		c.GCFrequency = args.GCFrequency
+	}
+	if args.ApproximateCount != 0 {
+		c.ApproximateCount = args.ApproximateCount
+	}
+	if args.Pubkey != "" {
+		c.Pubkey = args.Pubkey
+	}
+	if args.Whitelist != nil {
+		c.Whitelist = args.Whitelist
+	}
+	if len(args.TrustedProxies) > 0 {
+		c.TrustedProxies = args.TrustedProxies
+	}
+	if args.CanisterAddr != "" {
+		c.CanisterAddr = args.CanisterAddr
+	}
+	if args.CanisterId != "" {
+		c.CanisterId = args.CanisterId
+	}
+	if args.AuthRequired {
+		c.AuthRequired = true
+	}
+	if args.EventStore != "" {
+		c.EventStore = args.EventStore
+	}
+	if args.MemLimit > 0 {
+		c.MemLimit = args.MemLimit
+	}
+	if args.GCRatio > 0 {
+		c.GCRatio = args.GCRatio
+	}
+	if args.MaxProcs > 0 {
+		c.MaxProcs = args.MaxProcs
+	}
+	if args.PollFrequency > 0 {
+		c.PollFrequency = args.PollFrequency
+	}
+	if args.PollOverlap > 0 {
+		c.PollOverlap = args.PollOverlap
+	}
+	if args.ConnRateLimit > 0 {
+		c.ConnRateLimit = args.ConnRateLimit
+	}
+	if args.BanAfter > 0 {
+		c.BanAfter = args.BanAfter
+	}
+	if args.BanDuration > 0 {
+		c.BanDuration = args.BanDuration
+	}
+	if args.Metrics {
+		c.Metrics = true
+	}
+	if args.SendQueueSize > 0 {
+		c.SendQueueSize = args.SendQueueSize
+	}
+	if args.SendQueueHighWater > 0 {
+		c.SendQueueHighWater = args.SendQueueHighWater
+	}
+	if args.SlowConsumerPolicy != "" {
+		c.SlowConsumerPolicy = args.SlowConsumerPolicy
+	}
+	if args.WritePolicy != "" {
+		c.WritePolicy = args.WritePolicy
+	}
+	if args.WritePolicyTimeout > 0 {
+		c.WritePolicyTimeout = args.WritePolicyTimeout
+	}
+	if args.WritePolicyFailClosed {
+		c.WritePolicyFailClosed = true
+	}
+	if args.ExportDir != "" {
+		c.ExportDir = args.ExportDir
+	}
+	if len(args.AllowedKinds) > 0 {
+		c.AllowedKinds = args.AllowedKinds
+	}
+	if len(args.DisallowedKinds) > 0 {
+		c.DisallowedKinds = args.DisallowedKinds
+	}
+}
+
+// Validate checks that the values in a configuration can be used by the relay.
+func (c *Config) Validate() (err error) {
+	if c.SecKey != "" {
+		if _, err = keys.GetPublicKey(c.SecKey); err != nil {
+			return log.E.Err("invalid relay identity key: %s", err)
+		}
+	}
+	for _, owner := range c.Owners {
+		if !keys.IsValid32ByteHex(owner) {
+			return log.E.Err("invalid owner public key '%s'", owner)
+		}
+	}
+	if c.DBLowWater < 0 || c.DBLowWater > 100 ||
+		c.DBHighWater < 0 || c.DBHighWater > 100 {
+		return log.E.Err("db water marks must be percentages, got low %d "+
+			"high %d", c.DBLowWater, c.DBHighWater)
+	}
+	if c.DBSizeLimit > 0 && c.DBLowWater >= c.DBHighWater {
+		return log.E.Err("db low water %d must be below high water %d",
+			c.DBLowWater, c.DBHighWater)
+	}
+	if c.DBSizeLimit < 0 || c.GCFrequency < 0 || c.ConnRateLimit < 0 ||
+		c.BanAfter < 0 || c.BanDuration < 0 || c.ApproximateCount < 0 ||
+		c.WritePolicyTimeout < 0 {
+		return log.E.Err("negative limits are not valid")
+	}
+	if c.SendQueueSize < 0 || c.SendQueueHighWater < 0 ||
+		(c.SendQueueSize > 0 && c.SendQueueHighWater > c.SendQueueSize) {
+		return log.E.Err("send queue high water %d must be within the "+
+			"send queue size %d", c.SendQueueHighWater, c.SendQueueSize)
+	}
+	if c.SlowConsumerPolicy != "" {
+		if _, ok := relayws.ParseSlowConsumerPolicy(
+			c.SlowConsumerPolicy); !ok {
+			return log.E.Err("unknown slow consumer policy '%s'",
+				c.SlowConsumerPolicy)
+		}
+	}
+	for name, pow := range c.PowDifficulty {
+		for _, d := range append([]int{pow.Difficulty},
+			maps.Values(pow.Kinds)...) {
+			if d < 0 || d > 256 {
+				return log.E.Err("proof of work difficulty %d for '%s' "+
+					"must be between 0 and 256", d, name)
+			}
+		}
+	}
+	for _, pub := range c.Retention.PinPubKeys {
+		if !keys.IsValid32ByteHex(pub) {
+			return log.E.Err("invalid pinned public key '%s'", pub)
+		}
+	}
+	retentionKinds := append(maps.Keys(c.Retention.KindPriority),
+		maps.Keys(c.Retention.MaxAge)...)
+	for _, k := range append(retentionKinds, c.Retention.PinKinds...) {
+		if k < 0 || k > 65535 {
+			return log.E.Err("invalid kind %d in retention rules", k)
+		}
+	}
+	for k, age := range c.Retention.MaxAge {
+		if age <= 0 {
+			return log.E.Err("maximum age %v of kind %d must be positive",
+				age, k)
+		}
+	}
+	if c.Retention.AuthorQuota < 0 {
+		return log.E.Err("negative author quota %d",
+			c.Retention.AuthorQuota)
+	}
+	for name, limit := range c.RateLimits {
+		if limit.Events < 0 || limit.Reqs < 0 {
+			return log.E.Err("negative rate limit for '%s'", name)
+		}
+	}
 	return
 }
@@ -0,0 +1,57 @@
+package interrupt
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+)
+
+var (
+	// reloadSignals is the list of signals that request a reload of the
+	// configuration, there are none on platforms without SIGHUP.
+	reloadSignals []os.Signal
+
+	// reloadCh is used to receive reload signals.
+	reloadCh chan os.Signal
+
+	reloadMx              sync.Mutex
+	reloadCallbacks       []func()
+	reloadCallbackSources []string
+)
+
+// AddReloadHandler adds a handler to call when a SIGHUP is received. Handlers
+// are run in the order they were added, each time the signal is received.
+//
+// On platforms without SIGHUP the handlers are never called.
+func AddReloadHandler(handler func()) {
+	_, loc, line, _ := runtime.Caller(1)
+	msg := fmt.Sprintf("%s:%d", loc, line)
+	log.D.Ln("reload handler added by:", msg)
+	reloadMx.Lock()
+	defer reloadMx.Unlock()
+	reloadCallbacks = append(reloadCallbacks, handler)
+	reloadCallbackSources = append(reloadCallbackSources, msg)
+	if reloadCh == nil && len(reloadSignals) > 0 {
+		reloadCh = make(chan os.Signal, 1)
+		signal.Notify(reloadCh, reloadSignals...)
+		go reloadListener()
+	}
+}
+
+// reloadListener runs the reload handlers each time a reload signal is
+// received.
+func reloadListener() {
+	for sig := range reloadCh {
+		log.I.Ln("received reload signal", sig)
+		reloadMx.Lock()
+		callbacks := append([]func(){}, reloadCallbacks...)
+		sources := append([]string{}, reloadCallbackSources...)
+		reloadMx.Unlock()
+		for i := range callbacks {
+			log.D.Ln("running reload callback", i, sources[i])
+			callbacks[i]()
+		}
+	}
+}
@@ -10,4 +10,5 @@ import (
 func init() {
 
 	signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	reloadSignals = []os.Signal{syscall.SIGHUP}
 }
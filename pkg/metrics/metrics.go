@@ -0,0 +1,262 @@
+// Package metrics is a minimal implementation of counters, gauges and
+// histograms that are exposed in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector is a metric family that can write itself in the Prometheus text
+// format.
+type Collector interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+// Registry is a set of collectors that are served together.
+type Registry struct {
+	sync.Mutex
+	collectors map[string]Collector
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() (r *Registry) {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Register adds collectors to the registry, replacing any with the same name.
+func (r *Registry) Register(cs ...Collector) {
+	r.Lock()
+	defer r.Unlock()
+	for _, c := range cs {
+		r.collectors[c.Name()] = c
+	}
+}
+
+// Write writes all of the collectors in the registry, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.Lock()
+	cs := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		cs = append(cs, c)
+	}
+	r.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Name() < cs[j].Name() })
+	for _, c := range cs {
+		c.Write(w)
+	}
+}
+
+// ServeHTTP implements http.Handler for scraping the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// header writes the help and type lines of a metric family.
+func header(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labelString formats label names and values as a Prometheus label set.
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = names[i] + "=" + strconv.Quote(v)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value for each combination of label
+// values.
+type Counter struct {
+	name, help string
+	labels     []string
+	mx         sync.Mutex
+	values     map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter creates a Counter with the given label names.
+func NewCounter(name, help string, labels ...string) (c *Counter) {
+	return &Counter{name: name, help: help, labels: labels,
+		values: make(map[string]*counterValue)}
+}
+
+// Name returns the name of the counter.
+func (c *Counter) Name() string { return c.name }
+
+// Add adds a value to the counter with the given label values.
+func (c *Counter) Add(v float64, labels ...string) {
+	key := strings.Join(labels, "\xff")
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labels...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(labels ...string) { c.Add(1, labels...) }
+
+// Value returns the value of the counter with the given label values.
+func (c *Counter) Value(labels ...string) float64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if cv, ok := c.values[strings.Join(labels, "\xff")]; ok {
+		return cv.value
+	}
+	return 0
+}
+
+// Write writes the counter in the Prometheus text format.
+func (c *Counter) Write(w io.Writer) {
+	header(w, c.name, c.help, "counter")
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cv := c.values[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, cv.labels),
+			formatFloat(cv.value))
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are collected.
+type GaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates a GaugeFunc.
+func NewGaugeFunc(name, help string, fn func() float64) (g *GaugeFunc) {
+	return &GaugeFunc{name: name, help: help, fn: fn}
+}
+
+// Name returns the name of the gauge.
+func (g *GaugeFunc) Name() string { return g.name }
+
+// Write writes the gauge in the Prometheus text format.
+func (g *GaugeFunc) Write(w io.Writer) {
+	header(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// DefaultBuckets are the upper bounds of the histogram buckets for durations
+// in seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5,
+	1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets for each combination of label
+// values.
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mx         sync.Mutex
+	values     map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a Histogram with the given bucket upper bounds, which
+// must be sorted, and label names.
+func NewHistogram(name, help string, buckets []float64,
+	labels ...string) (h *Histogram) {
+
+	return &Histogram{name: name, help: help, labels: labels,
+		buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+// Name returns the name of the histogram.
+func (h *Histogram) Name() string { return h.name }
+
+// Observe records a value in the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := strings.Join(labels, "\xff")
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: append([]string(nil), labels...),
+			counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Write writes the histogram in the Prometheus text format.
+func (h *Histogram) Write(w io.Writer) {
+	header(w, h.name, h.help, "histogram")
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	names := append(append([]string(nil), h.labels...), "le")
+	for _, k := range keys {
+		hv := h.values[k]
+		values := append(append([]string(nil), hv.labels...), "")
+		for i, upper := range h.buckets {
+			values[len(values)-1] = formatFloat(upper)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				labelString(names, values), hv.counts[i])
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			labelString(names, values), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name,
+			labelString(h.labels, hv.labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name,
+			labelString(h.labels, hv.labels), hv.count)
+	}
+}
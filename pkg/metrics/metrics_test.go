@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter("test_total", "a counter", "kind")
+	h := NewHistogram("test_seconds", "a histogram", []float64{1, 2}, "type")
+	g := NewGaugeFunc("test_gauge", "a gauge", func() float64 { return 1.5 })
+	r.Register(c, h, g)
+	c.Inc("a")
+	c.Add(2, `"b"`)
+	h.Observe(0.5, "req")
+	h.Observe(1.5, "req")
+	h.Observe(3, "req")
+	var buf bytes.Buffer
+	r.Write(&buf)
+	expected := `# HELP test_gauge a gauge
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_seconds a histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{type="req",le="1"} 1
+test_seconds_bucket{type="req",le="2"} 2
+test_seconds_bucket{type="req",le="+Inf"} 3
+test_seconds_sum{type="req"} 5
+test_seconds_count{type="req"} 3
+# HELP test_total a counter
+# TYPE test_total counter
+test_total{kind="\"b\""} 2
+test_total{kind="a"} 1
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/minio/sha256-simd"
+)
+
+const (
+	// HTTPScheme is the scheme of the Authorization header that carries a
+	// NIP-98 event.
+	HTTPScheme = "Nostr"
+	// HTTPWindow is how far the created_at of a NIP-98 event may be from the
+	// current time.
+	HTTPWindow = time.Minute
+)
+
+// CreateUnsignedHTTP creates a NIP-98 event authorizing a request with a
+// method to a URL. If the request has a body its hash is added as the payload
+// tag.
+func CreateUnsignedHTTP(requestURL, method string, payload []byte) *event.T {
+	ev := &event.T{
+		CreatedAt: timestamp.Now(),
+		Kind:      kind.HTTPAuth,
+		Tags:      tags.T{{"u", requestURL}, {"method", method}},
+		Content:   "",
+	}
+	if len(payload) > 0 {
+		h := sha256.Sum256(payload)
+		ev.Tags = append(ev.Tags, tag.T{"payload", hex.Enc(h[:])})
+	}
+	return ev
+}
+
+// HTTPHeader returns the value of the Authorization header for a signed NIP-98
+// event.
+func HTTPHeader(ev *event.T) string {
+	b, _ := json.Marshal(ev)
+	return HTTPScheme + " " + base64.StdEncoding.EncodeToString(b)
+}
+
+// ValidateHTTP checks whether the value of an Authorization header is a valid
+// NIP-98 event for a request with a method to a URL with the given body. The
+// result of the validation is encoded in the ok bool.
+//
+// Only the host and path of the URL are compared, as the scheme seen by the
+// relay depends on whether TLS is terminated by a proxy.
+func ValidateHTTP(header, requestURL, method string,
+	payload []byte) (pubkey string, ok bool, err error) {
+
+	scheme, encoded, cut := strings.Cut(header, " ")
+	if !cut || !strings.EqualFold(scheme, HTTPScheme) {
+		err = log.E.Err("authorization header is not a %s event",
+			HTTPScheme)
+		log.D.Ln(err)
+		return
+	}
+	var b []byte
+	if b, err = base64.StdEncoding.DecodeString(
+		strings.TrimSpace(encoded)); chk.D(err) {
+		return
+	}
+	evt := &event.T{}
+	if err = json.Unmarshal(b, evt); chk.D(err) {
+		return
+	}
+	if evt.Kind != kind.HTTPAuth {
+		err = log.E.Err("event incorrect kind for HTTP auth: %d %s",
+			evt.Kind, kind.Map[evt.Kind])
+		log.D.Ln(err)
+		return
+	}
+	now := time.Now()
+	if evt.CreatedAt.Time().After(now.Add(HTTPWindow)) ||
+		evt.CreatedAt.Time().Before(now.Add(-HTTPWindow)) {
+		err = log.E.Err("HTTP auth event more than %v before or after "+
+			"current time", HTTPWindow)
+		log.D.Ln(err)
+		return
+	}
+	u := tagValue(evt.Tags, "u")
+	if u == "" {
+		err = log.E.Err("u tag missing from HTTP auth event")
+		log.D.Ln(err)
+		return
+	}
+	var expected, found *url.URL
+	if expected, err = parseURL(requestURL); chk.D(err) {
+		return
+	}
+	if found, err = parseURL(u); chk.D(err) {
+		return
+	}
+	if expected.Host != found.Host || expected.Path != found.Path {
+		err = log.E.Err("HTTP auth URL incorrect: expected '%s' got '%s'",
+			requestURL, u)
+		log.D.Ln(err)
+		return
+	}
+	m := tagValue(evt.Tags, "method")
+	if !strings.EqualFold(m, method) {
+		err = log.E.Err("HTTP auth method incorrect: expected '%s' got '%s'",
+			method, m)
+		log.D.Ln(err)
+		return
+	}
+	p := tagValue(evt.Tags, "payload")
+	if len(payload) > 0 || p != "" {
+		h := sha256.Sum256(payload)
+		if !strings.EqualFold(p, hex.Enc(h[:])) {
+			err = log.E.Err("HTTP auth payload hash does not match body")
+			log.D.Ln(err)
+			return
+		}
+	}
+	// save for last, as it is most expensive operation
+	if ok, err = evt.CheckSignature(); !ok {
+		if err == nil {
+			err = log.E.Err("HTTP auth event has invalid signature")
+		}
+		log.D.Ln(err)
+		return
+	}
+	pubkey = evt.PubKey
+	return
+}
+
+// tagValue returns the value of the first tag with a key, or an empty string if
+// there is none.
+func tagValue(t tags.T, key string) string {
+	if v := t.GetFirst([]string{key, ""}); v != nil {
+		return v.Value()
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestValidateHTTP(t *testing.T) {
+	sec := keys.GeneratePrivateKey()
+	pub, _ := keys.GetPublicKey(sec)
+	const u = "https://relay.example.com/"
+	body := []byte(`{"method":"supportedmethods","params":[]}`)
+	sign := func(ev *event.T) string {
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		return HTTPHeader(ev)
+	}
+	valid := sign(CreateUnsignedHTTP(u, "POST", body))
+	old := CreateUnsignedHTTP(u, "POST", body)
+	old.CreatedAt = timestamp.Now() - 120
+	wrongKind := CreateUnsignedHTTP(u, "POST", body)
+	wrongKind.Kind = kind.ClientAuthentication
+	for i, test := range []struct {
+		header, url, method string
+		body                []byte
+		ok                  bool
+	}{
+		{valid, u, "POST", body, true},
+		// the scheme and trailing slash are not significant
+		{valid, "http://relay.example.com", "POST", body, true},
+		{valid, "https://other.example.com/", "POST", body, false},
+		{valid, u, "GET", body, false},
+		{valid, u, "POST", []byte(`{}`), false},
+		{valid, u, "POST", nil, false},
+		{sign(old), u, "POST", body, false},
+		{sign(wrongKind), u, "POST", body, false},
+		{"Bearer abc", u, "POST", body, false},
+		{"Nostr !!!", u, "POST", body, false},
+	} {
+		got, ok, _ := ValidateHTTP(test.header, test.url, test.method,
+			test.body)
+		if ok != test.ok {
+			t.Errorf("test %d: expected ok %v, got %v", i, test.ok, ok)
+		}
+		if ok && got != pub {
+			t.Errorf("test %d: expected pubkey %s, got %s", i, pub, got)
+		}
+	}
+}
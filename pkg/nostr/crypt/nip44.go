@@ -0,0 +1,244 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/bech32"
+	secp "github.com/Hubmakerlabs/replicatr/pkg/ec/secp256k1"
+	b32 "github.com/Hubmakerlabs/replicatr/pkg/nostr/bech32encoding"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
+	"github.com/aead/chacha20/chacha"
+	"lukechampine.com/frand"
+)
+
+// The parameters of NIP-44 version 2 encryption.
+const (
+	// Nip44Version is the version byte of the payloads.
+	Nip44Version = 2
+	// MinPlaintextSize and MaxPlaintextSize are the bounds of the length of
+	// messages that can be encrypted.
+	MinPlaintextSize = 1
+	MaxPlaintextSize = 65535
+	// nonceSize is the size of the random nonce of each message.
+	nonceSize = 32
+	// macSize is the size of the HMAC-SHA256 of each message.
+	macSize = 32
+	// minPayloadSize and maxPayloadSize bound the length of base64 encoded
+	// payloads, and minDataSize and maxDataSize the decoded ones.
+	minPayloadSize = 132
+	maxPayloadSize = 87472
+	minDataSize    = 99
+	maxDataSize    = 65603
+)
+
+// nip44Salt is the HKDF salt of the conversation key.
+var nip44Salt = []byte("nip44-v2")
+
+var (
+	ErrUnknownVersion = errors.New("unknown encryption version")
+	ErrInvalidMAC     = errors.New("invalid MAC")
+	ErrInvalidPadding = errors.New("invalid padding")
+)
+
+// ConversationKey computes the NIP-44 conversation key shared by the owner of
+// a secret key and the owner of a public key, which is the same in either
+// direction, and can be kept for encrypting and decrypting all messages between
+// them.
+//
+// The keys may be hex or bech32 encoded as for ComputeSharedSecret, but unlike
+// there the secret key is refused if it is zero or not less than the order of
+// the curve, instead of being reduced.
+func ConversationKey(sec, pub string) (key []byte, err error) {
+	if err = checkSecretKey(sec); err != nil {
+		return
+	}
+	var shared []byte
+	if shared, err = ComputeSharedSecret(sec, pub); err != nil {
+		return
+	}
+	return hkdfExtract(shared, nip44Salt), nil
+}
+
+// checkSecretKey returns an error if a hex or bech32 encoded secret key is not
+// in the range 1 to n-1, n being the order of the curve.
+func checkSecretKey(sec string) (err error) {
+	var b []byte
+	if strings.HasPrefix(sec, b32.SecHRP) {
+		var b5 []byte
+		if _, b5, err = bech32.Decode(sec); err != nil {
+			return
+		}
+		if b, err = b32.ConvertFromBech32(b5); err != nil {
+			return
+		}
+	} else if b, err = hex.Dec(sec); err != nil {
+		return
+	}
+	var s secp.ModNScalar
+	if len(b) != 32 || s.SetByteSlice(b) || s.IsZero() {
+		return errf("invalid secret key")
+	}
+	return
+}
+
+// EncryptNip44 encrypts a message with a conversation key generated by
+// ConversationKey using NIP-44 version 2, returning the base64 payload.
+func EncryptNip44(message string, conversationKey []byte) (string, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := frand.Read(nonce); err != nil {
+		return "", errf("error creating nonce: %w", err)
+	}
+	return encryptNip44(message, conversationKey, nonce)
+}
+
+// encryptNip44 encrypts a message with the given nonce, which must never be
+// used twice with the same conversation key.
+func encryptNip44(message string, conversationKey, nonce []byte) (payload string,
+	err error) {
+
+	var chachaKey, chachaNonce, hmacKey []byte
+	if chachaKey, chachaNonce, hmacKey, err = messageKeys(conversationKey,
+		nonce); err != nil {
+		return
+	}
+	var padded []byte
+	if padded, err = pad(message); err != nil {
+		return
+	}
+	data := make([]byte, 1+nonceSize, 1+nonceSize+len(padded)+macSize)
+	data[0] = Nip44Version
+	copy(data[1:], nonce)
+	ciphertext := make([]byte, len(padded))
+	chacha.XORKeyStream(ciphertext, padded, chachaNonce, chachaKey, 20)
+	data = append(data, ciphertext...)
+	data = append(data, messageMAC(hmacKey, nonce, ciphertext)...)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecryptNip44 decrypts a NIP-44 version 2 payload with a conversation key
+// generated by ConversationKey.
+func DecryptNip44(payload string, conversationKey []byte) (message string,
+	err error) {
+
+	if len(payload) > 0 && payload[0] == '#' {
+		return "", ErrUnknownVersion
+	}
+	if len(payload) < minPayloadSize || len(payload) > maxPayloadSize {
+		return "", errf("invalid payload length %d", len(payload))
+	}
+	var data []byte
+	if data, err = base64.StdEncoding.DecodeString(payload); err != nil {
+		return "", errf("error decoding payload from base64: %w", err)
+	}
+	if len(data) < minDataSize || len(data) > maxDataSize {
+		return "", errf("invalid data length %d", len(data))
+	}
+	if data[0] != Nip44Version {
+		return "", ErrUnknownVersion
+	}
+	nonce := data[1 : 1+nonceSize]
+	ciphertext := data[1+nonceSize : len(data)-macSize]
+	mac := data[len(data)-macSize:]
+	var chachaKey, chachaNonce, hmacKey []byte
+	if chachaKey, chachaNonce, hmacKey, err = messageKeys(conversationKey,
+		nonce); err != nil {
+		return
+	}
+	if !hmac.Equal(mac, messageMAC(hmacKey, nonce, ciphertext)) {
+		return "", ErrInvalidMAC
+	}
+	padded := make([]byte, len(ciphertext))
+	chacha.XORKeyStream(padded, ciphertext, chachaNonce, chachaKey, 20)
+	return unpad(padded)
+}
+
+// messageKeys derives the keys for encrypting and authenticating a message
+// from the conversation key and the nonce of the message.
+func messageKeys(conversationKey, nonce []byte) (chachaKey, chachaNonce,
+	hmacKey []byte, err error) {
+
+	if len(conversationKey) != 32 {
+		err = errf("invalid conversation key length %d",
+			len(conversationKey))
+		return
+	}
+	if len(nonce) != nonceSize {
+		err = errf("invalid nonce length %d", len(nonce))
+		return
+	}
+	keys := hkdfExpand(conversationKey, nonce, 76)
+	return keys[:32], keys[32:44], keys[44:], nil
+}
+
+// messageMAC is the HMAC-SHA256 of the nonce and ciphertext of a message.
+func messageMAC(hmacKey, nonce, ciphertext []byte) []byte {
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write(nonce)
+	h.Write(ciphertext)
+	return h.Sum(nil)
+}
+
+// hkdfExtract is the HKDF-SHA256 extract function of RFC 5869.
+func hkdfExtract(ikm, salt []byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write(ikm)
+	return h.Sum(nil)
+}
+
+// hkdfExpand is the HKDF-SHA256 expand function of RFC 5869.
+func hkdfExpand(prk, info []byte, length int) (out []byte) {
+	h := hmac.New(sha256.New, prk)
+	var block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		h.Reset()
+		h.Write(block)
+		h.Write(info)
+		h.Write([]byte{counter})
+		block = h.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// calcPaddedLen returns the length a message is padded to before it is
+// encrypted, so the length of the payload reveals less about the message.
+func calcPaddedLen(l int) int {
+	if l <= 32 {
+		return 32
+	}
+	nextPower := 1
+	for nextPower < l {
+		nextPower <<= 1
+	}
+	chunk := 32
+	if nextPower > 256 {
+		chunk = nextPower / 8
+	}
+	return chunk * ((l-1)/chunk + 1)
+}
+
+// pad prefixes a message with its length and pads it with zeros.
+func pad(message string) (padded []byte, err error) {
+	l := len(message)
+	if l < MinPlaintextSize || l > MaxPlaintextSize {
+		return nil, errf("invalid plaintext length %d", l)
+	}
+	padded = make([]byte, 2+calcPaddedLen(l))
+	binary.BigEndian.PutUint16(padded, uint16(l))
+	copy(padded[2:], message)
+	return
+}
+
+// unpad returns the message from a padded plaintext.
+func unpad(padded []byte) (message string, err error) {
+	l := int(binary.BigEndian.Uint16(padded))
+	if l < MinPlaintextSize || len(padded) != 2+calcPaddedLen(l) {
+		return "", ErrInvalidPadding
+	}
+	return string(padded[2 : 2+l]), nil
+}
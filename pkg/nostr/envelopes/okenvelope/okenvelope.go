@@ -27,6 +27,7 @@ const (
 	RateLimited Reason = "rate-limited"
 	Invalid     Reason = "invalid"
 	Error       Reason = "error"
+	Restricted  Reason = "restricted"
 )
 
 var _ enveloper.I = (*T)(nil)
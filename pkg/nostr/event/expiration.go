@@ -0,0 +1,34 @@
+package event
+
+import (
+	"strconv"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+// ExpirationTag is the tag that marks the time after which an event should no
+// longer be served, as per NIP-40.
+const ExpirationTag = "expiration"
+
+// Expiration returns the timestamp in the first valid expiration tag of the
+// event, if it has one.
+func (ev *T) Expiration() (exp timestamp.T, ok bool) {
+	for _, t := range ev.Tags {
+		if len(t) < 2 || t[0] != ExpirationTag {
+			continue
+		}
+		n, err := strconv.ParseInt(t[1], 10, 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		return timestamp.T(n), true
+	}
+	return
+}
+
+// IsExpired returns true if the event has an expiration tag with a time that
+// has passed.
+func (ev *T) IsExpired() bool {
+	exp, ok := ev.Expiration()
+	return ok && exp <= timestamp.Now()
+}
@@ -0,0 +1,34 @@
+package event_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestExpiration(t *testing.T) {
+	now := timestamp.Now()
+	for i, tc := range []struct {
+		tags    tags.T
+		ok      bool
+		expired bool
+	}{
+		{nil, false, false},
+		{tags.T{{event.ExpirationTag, "bogus"}}, false, false},
+		{tags.T{{event.ExpirationTag, strconv.FormatInt(now.I64()+60, 10)}},
+			true, false},
+		{tags.T{{event.ExpirationTag, strconv.FormatInt(now.I64()-60, 10)}},
+			true, true},
+	} {
+		ev := &event.T{Tags: tc.tags}
+		if _, ok := ev.Expiration(); ok != tc.ok {
+			t.Errorf("%d: expected expiration found %v, got %v", i, tc.ok, ok)
+		}
+		if ev.IsExpired() != tc.expired {
+			t.Errorf("%d: expected expired %v", i, tc.expired)
+		}
+	}
+}
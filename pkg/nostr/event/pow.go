@@ -0,0 +1,35 @@
+package event
+
+import (
+	"math/bits"
+	"strconv"
+)
+
+// Difficulty returns the NIP-13 proof of work difficulty of the event, which is
+// the number of leading zero bits of the event ID.
+func (ev *T) Difficulty() (n int) {
+	for _, b := range ev.ID.Bytes() {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return
+}
+
+// CommittedDifficulty returns the target difficulty committed to in the third
+// field of the NIP-13 nonce tag of the event, and false if there is none.
+//
+// A relay can require the target to be at least its minimum difficulty, so an
+// event mined for less that happens to reach the minimum is not accepted.
+func (ev *T) CommittedDifficulty() (target int, ok bool) {
+	t := ev.Tags.GetFirst([]string{"nonce"})
+	if t == nil || len(*t) < 3 {
+		return
+	}
+	var err error
+	if target, err = strconv.Atoi((*t)[2]); err != nil || target < 0 {
+		return 0, false
+	}
+	return target, true
+}
@@ -0,0 +1,47 @@
+package event_test
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+)
+
+func TestDifficulty(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		diff int
+	}{
+		{"ff00000000000000000000000000000000000000000000000000000000000000", 0},
+		{"000000000e9d97a1ab09fc381030b346cdd7a142ad57e6df0b46dc9bef6c7e2d", 36},
+		{"0000000000000000000000000000000000000000000000000000000000000001", 255},
+	} {
+		ev := &event.T{ID: eventid.T(tc.id)}
+		if d := ev.Difficulty(); d != tc.diff {
+			t.Errorf("%s: expected difficulty %d, got %d", tc.id, tc.diff, d)
+		}
+	}
+}
+
+func TestCommittedDifficulty(t *testing.T) {
+	for _, tc := range []struct {
+		tags   tags.T
+		target int
+		ok     bool
+	}{
+		{tags.T{{"nonce", "776797", "20"}}, 20, true},
+		{tags.T{{"t", "pow"}, {"nonce", "1", "0"}}, 0, true},
+		{tags.T{{"nonce", "776797"}}, 0, false},
+		{tags.T{{"nonce", "776797", "many"}}, 0, false},
+		{tags.T{{"nonce", "776797", "-1"}}, 0, false},
+		{nil, 0, false},
+	} {
+		ev := &event.T{Tags: tc.tags}
+		target, ok := ev.CommittedDifficulty()
+		if target != tc.target || ok != tc.ok {
+			t.Errorf("%v: expected %d %v, got %d %v", tc.tags, tc.target,
+				tc.ok, target, ok)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package badger
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
@@ -10,6 +12,14 @@ import (
 	"github.com/dgraph-io/badger/v4"
 )
 
+// AccessFlushInterval is how often the access times gathered from queries are
+// written to the access counter records.
+const AccessFlushInterval = 5 * time.Second
+
+// AccessFlushRetries is the number of times writing access times is attempted
+// when it conflicts with a concurrent write before they are dropped.
+const AccessFlushRetries = 3
+
 type AccessEvent struct {
 	EvID eventid.T
 	Ts   timestamp.T
@@ -25,36 +35,121 @@ func (a AccessEvent) String() (s string) {
 	return fmt.Sprintf("[%s, %v, %d]", a.EvID.String(), a.Ts.Time(), a.Ser.Uint64())
 }
 
-// IncrementAccesses takes a list of event IDs of events that were accessed in a
-// query and updates their access counter records.
+// IncrementAccesses records the access of an event returned by a query. The
+// latest access time of each event is kept in memory and written to its access
+// counter record by the next FlushAccesses.
 func (b *Backend) IncrementAccesses(acc *AccessEvent) (err error) {
-out:
-	for {
-		err = b.Update(func(txn *badger.Txn) (err error) {
-			key := GetCounterKey(acc.Ser)
-			it := txn.NewIterator(badger.IteratorOptions{})
-			defer it.Close()
-			if it.Seek(key); it.ValidForPrefix(key) {
-				// update access record
-				if err = txn.Set(key, acc.Ts.Bytes()); chk.E(err) {
-					return
-				}
+	if acc == nil || acc.Ser == nil {
+		return errors.New("access event without serial")
+	}
+	ser := acc.Ser.Uint64()
+	b.accessMx.Lock()
+	defer b.accessMx.Unlock()
+	if b.accesses == nil {
+		b.accesses = make(map[uint64]timestamp.T)
+	}
+	if acc.Ts > b.accesses[ser] {
+		b.accesses[ser] = acc.Ts
+	}
+	return
+}
+
+// FlushAccesses writes the access times recorded since the last flush to the
+// access counter records.
+//
+// Only events that still have an access counter record are updated, so events
+// that were deleted since they were accessed do not get one back. The check and
+// the write are done in the same transaction, which is retried up to
+// AccessFlushRetries times if an event is deleted concurrently.
+func (b *Backend) FlushAccesses() (err error) {
+	b.flushMx.Lock()
+	defer b.flushMx.Unlock()
+	b.accessMx.Lock()
+	pending := b.accesses
+	b.accesses = nil
+	b.accessMx.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	sers := make([]uint64, 0, len(pending))
+	for ser := range pending {
+		sers = append(sers, ser)
+	}
+	var updated int
+	for len(sers) > 0 {
+		var done, written int
+		for i := 1; ; i++ {
+			if done, written, err = b.writeAccesses(sers,
+				pending); err == nil {
+				break
+			}
+			if !errors.Is(err, badger.ErrConflict) || i == AccessFlushRetries {
+				return log.E.Err("dropped %d access counter updates: %w %s",
+					len(sers), err, b.Path)
 			}
-			log.T.Ln("last access for", acc.Ser.Uint64(), acc.Ts.U64())
-			return nil
-		})
-		// retry if we failed, usually a txn conflict
-		if err == nil {
-			break out
+			log.D.F("failed to write access counters, attempt %d of %d: %v %s",
+				i, AccessFlushRetries, err, b.Path)
 		}
+		sers, updated = sers[done:], updated+written
 	}
+	log.T.F("updated %d access counters %s", updated, b.Path)
 	return
 }
 
+// writeAccesses writes the access times of as many of the serials as fit in a
+// transaction to the access counter records that exist, returning how many of
+// the serials were done and how many records were written.
+func (b *Backend) writeAccesses(sers []uint64,
+	pending map[uint64]timestamp.T) (done, written int, err error) {
+
+	err = b.Update(func(txn *badger.Txn) (err error) {
+		done, written = 0, 0
+		for _, ser := range sers {
+			key := GetCounterKey(serial.New(serial.Make(ser)))
+			if _, err = txn.Get(key); errors.Is(err, badger.ErrKeyNotFound) {
+				done++
+				continue
+			} else if chk.E(err) {
+				return
+			}
+			if err = txn.Set(key, pending[ser].Bytes()); errors.Is(err,
+				badger.ErrTxnTooBig) {
+				// the rest go in the next transaction
+				return nil
+			} else if chk.E(err) {
+				return
+			}
+			done++
+			written++
+		}
+		return nil
+	})
+	return
+}
+
+// AccessFlusher writes the recorded access times every AccessFlushInterval.
+//
+// This function should be invoked as a goroutine, and will terminate when the
+// backend context is canceled. Any remaining access times are written by Close.
+func (b *Backend) AccessFlusher() {
+	ticker := time.NewTicker(AccessFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.Ctx.Done():
+			return
+		case <-ticker.C:
+			chk.E(b.FlushAccesses())
+		}
+	}
+}
+
 // AccessLoop is meant to be run as a goroutine to gather access events in a
-// query and when it finishes, bump all the access records
+// query and record them to be written by the next FlushAccesses.
+//
+// The caller must add it to the WG before starting the goroutine, so Close
+// cannot miss it by waiting before it has started.
 func (b *Backend) AccessLoop(c context.T, accCh chan *AccessEvent) {
-	b.WG.Add(1)
 	defer b.WG.Done()
 	for {
 		select {
@@ -0,0 +1,90 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// accessTime returns the time in the access counter record of an event serial,
+// or false if it has none.
+func accessTime(t *testing.T, b *Backend, ser uint64) (ts timestamp.T,
+	found bool) {
+
+	if err := b.View(func(txn *badger.Txn) (err error) {
+		var item *badger.Item
+		item, err = txn.Get(GetCounterKey(serial.New(serial.Make(ser))))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return
+		}
+		found = true
+		return item.Value(func(val []byte) (err error) {
+			ts = timestamp.FromBytes(val)
+			return
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFlushAccesses(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	now := timestamp.Now()
+	ev := newTestEvent(t, sec, kind.TextNote, now, nil)
+	deleted := newTestEvent(t, sec, kind.TextNote, now-1, nil)
+	sers := make(map[eventid.T]*serial.T)
+	for _, e := range []*event.T{ev, deleted} {
+		if err := b.SaveEvent(b.Ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k, v := range storedEvents(t, b) {
+		e, err := nostrbinary.Unmarshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sers[e.ID] = serial.FromKey([]byte(k))
+	}
+	// the latest access of each event is kept
+	for _, acc := range []*AccessEvent{
+		{ev.ID, now + 100, sers[ev.ID]},
+		{ev.ID, now + 50, sers[ev.ID]},
+		{deleted.ID, now + 100, sers[deleted.ID]},
+	} {
+		if err := b.IncrementAccesses(acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.DeleteEvent(b.Ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if ts, _ := accessTime(t, b, sers[ev.ID].Uint64()); ts == now+100 {
+		t.Fatalf("access time was written before the flush")
+	}
+	if err := b.FlushAccesses(); err != nil {
+		t.Fatal(err)
+	}
+	if ts, found := accessTime(t, b, sers[ev.ID].Uint64()); !found ||
+		ts != now+100 {
+		t.Errorf("expected access time %d, got %d", now+100, ts)
+	}
+	if _, found := accessTime(t, b, sers[deleted.ID].Uint64()); found {
+		t.Errorf("access counter of deleted event was written")
+	}
+	b.accessMx.Lock()
+	defer b.accessMx.Unlock()
+	if len(b.accesses) != 0 {
+		t.Errorf("expected no pending accesses, got %d", len(b.accesses))
+	}
+}
@@ -2,117 +2,158 @@ package badger
 
 import (
 	"encoding/binary"
+	"errors"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
-	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/hll"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/createdat"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
-	"github.com/Hubmakerlabs/replicatr/pkg/nostr/wire/text"
 	"github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
 )
 
+// CountEvents returns the number of stored events that match a filter. See
+// CountEventsApproximate.
 func (b *Backend) CountEvents(c context.T, f *filter.T) (count int, err error) {
+	count, _, err = b.CountEventsApproximate(c, f)
+	return
+}
+
+// CountEventsApproximate returns the number of stored events that match a
+// filter, and whether the count is an estimate.
+//
+// Events are counted from the index keys alone unless the filter has fields
+// that the index used does not cover, in which case the events are decoded
+// and matched against them. Events found by more than one of the index queries
+// of the filter are counted once.
+//
+// If ApproximateCountAbove is set and more events than this match, counting
+// continues in a HyperLogLog sketch and the result is approximate.
+func (b *Backend) CountEventsApproximate(c context.T,
+	f *filter.T) (count int, approximate bool, err error) {
+
+	if f.Search != "" {
+		var results []searchResult
+		results, err = b.searchEvents(c, f, 0)
+		return len(results), false, err
+	}
 	var queries []query
 	var extraFilter *filter.T
 	var since uint64
 	if queries, extraFilter, since, err = PrepareQueries(f); chk.E(err) {
 		return
 	}
-	var found [][]byte
-	for _, q := range queries {
-		// log.I.Ln("running count query", i)
-		select {
-		case <-c.Done():
-			log.I.Ln("websocket closed")
-			return
-		case <-b.Ctx.Done():
-			log.I.Ln("backend context canceled")
-			return
-		default:
-		}
-		var counted bool
-		go func(q query) {
-			err := b.View(func(txn *badger.Txn) (err error) {
-				// iterate only through keys and in reverse order
-				opts := badger.IteratorOptions{
-					Reverse: true,
+	if isEmptyFilter(extraFilter) {
+		extraFilter = nil
+	}
+	err = b.View(func(txn *badger.Txn) (err error) {
+		// expired events that have not been swept yet are not counted
+		expired := expiredSerials(txn)
+		seen := make(map[uint64]struct{})
+		var sketch *hll.T
+		for _, q := range queries {
+			// queries for IDs or pubkeys that failed to decode are empty
+			if len(q.searchPrefix) == 0 {
+				continue
+			}
+			select {
+			case <-c.Done():
+				return context.Canceled
+			case <-b.Ctx.Done():
+				return context.Canceled
+			default:
+			}
+			// iterate only through keys and in reverse order
+			it := txn.NewIterator(badger.IteratorOptions{Reverse: true})
+			for it.Seek(q.start); it.ValidForPrefix(q.searchPrefix); it.Next() {
+				key := it.Item().Key()
+				// this is where the serial starts
+				serOffset := len(key) - serial.Len
+				// "id" indexes don't contain a timestamp
+				if !q.skipTS && binary.BigEndian.Uint64(
+					key[serOffset-createdat.Len:serOffset]) < since {
+					break
 				}
-				it := txn.NewIterator(opts)
-				defer it.Close()
-				for it.Seek(q.start); it.ValidForPrefix(q.searchPrefix); it.Next() {
-					select {
-					case <-c.Done():
-						log.I.Ln("websocket closed")
-						return
-					case <-b.Ctx.Done():
-						log.I.Ln("backend context canceled")
-						return
-					default:
-					}
-					item := it.Item()
-					key := item.Key()
-					// this is where the idx actually starts
-					idxOffset := len(key) - serial.Len
-					// "id" indexes don't contain a timestamp
-					if !q.skipTS {
-						createdAt := binary.BigEndian.Uint64(
-							key[idxOffset-createdat.Len : idxOffset])
-						if createdAt < since {
-							break
-						}
+				ser := binary.BigEndian.Uint64(key[serOffset:])
+				if _, ok := expired[ser]; ok {
+					continue
+				}
+				if sketch == nil {
+					if _, ok := seen[ser]; ok {
+						continue
 					}
-					if extraFilter == nil {
-						count++
-						counted = true
+				}
+				if extraFilter != nil {
+					var match bool
+					if match, err = b.matchSerial(txn, ser, extraFilter); err != nil {
+						it.Close()
 						return
 					}
-					ser := serial.FromKey(key)
-					found = append(found, index.Event.Key(ser))
+					if !match {
+						continue
+					}
 				}
-				return
-			})
-			chk.E(err)
-			log.I.Ln("closing results chan")
-			close(q.results)
-			for res := range q.results {
-				log.I.Ln("closing results chan", res.Ev.ToObject().String())
-			}
-			log.T.Ln("count results channel clear",
-				text.Trunc(q.queryFilter.ToObject().String()))
-		}(q)
-		if counted {
-			continue
-		}
-		// if there was an extra filter
-		for i := range found {
-			evt := &event.T{}
-			val := make([]byte, b.MaxLimit)
-			err = b.View(func(txn *badger.Txn) (err error) {
-				// iterate only through keys and in reverse order
-				opts := badger.IteratorOptions{
-					Reverse: true,
+				if sketch != nil {
+					sketch.Add(ser)
+					continue
 				}
-				it := txn.NewIterator(opts)
-				defer it.Close()
-				for it.Rewind(); it.ValidForPrefix(found[i]); it.Next() {
-					val, err = it.Item().ValueCopy(nil)
-					if evt, err = nostrbinary.Unmarshal(val); chk.E(err) {
-						return err
-					}
-					// check if this matches the other filters that were not
-					// part of the index
-					if extraFilter == nil || extraFilter.Matches(evt) {
-						count++
+				seen[ser] = struct{}{}
+				if b.ApproximateCountAbove > 0 &&
+					len(seen) > b.ApproximateCountAbove {
+					sketch = hll.New()
+					for s := range seen {
+						sketch.Add(s)
 					}
-					return
+					seen = nil
 				}
-				return
-			})
-			chk.E(err)
+			}
+			it.Close()
 		}
+		if sketch != nil {
+			count, approximate = int(sketch.Count()), true
+		} else {
+			count = len(seen)
+		}
+		return
+	})
+	if errors.Is(err, context.Canceled) {
+		err = nil
 	}
-	return count, err
+	return
+}
+
+// matchSerial decodes the event with a serial and checks it against a filter.
+// Events that have been pruned to the L2 cannot be matched and are not counted.
+func (b *Backend) matchSerial(txn *badger.Txn, ser uint64,
+	f *filter.T) (match bool, err error) {
+
+	key := index.Event.Key(serial.New(serial.Make(ser)))
+	var item *badger.Item
+	if item, err = txn.Get(key); errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	} else if chk.E(err) {
+		return
+	}
+	if item.ValueSize() == sha256.Size {
+		return
+	}
+	err = item.Value(func(val []byte) (err error) {
+		ev, uErr := nostrbinary.Unmarshal(val)
+		if chk.E(uErr) {
+			return
+		}
+		match = f.Matches(ev)
+		return
+	})
+	return
+}
+
+// isEmptyFilter returns true if a filter matches every event.
+func isEmptyFilter(f *filter.T) bool {
+	return f == nil || (len(f.IDs) == 0 && len(f.Authors) == 0 &&
+		len(f.Kinds) == 0 && len(f.Tags) == 0 && f.Since == nil &&
+		f.Until == nil && f.Search == "")
 }
@@ -0,0 +1,74 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestCountEvents(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	pub, _ := keys.GetPublicKey(sec)
+	now := timestamp.Now()
+	p1, p2 := keys.GeneratePrivateKey(), keys.GeneratePrivateKey()
+	for i := 0; i < 10; i++ {
+		k := kind.TextNote
+		if i%2 == 1 {
+			k = kind.Reaction
+		}
+		// every event tags p1, and every third one tags p2 as well
+		tt := tags.T{{"p", p1}}
+		if i%3 == 0 {
+			tt = append(tt, tag.T{"p", p2})
+		}
+		ev := newTestEvent(t, sec, k, now-timestamp.T(i), tt)
+		if err := b.SaveEvent(b.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since := (now - 4).Ptr()
+	for i, test := range []struct {
+		f *filter.T
+		n int
+	}{
+		{&filter.T{Kinds: kinds.T{kind.TextNote}}, 5},
+		{&filter.T{Kinds: kinds.T{kind.TextNote, kind.Reaction}}, 10},
+		{&filter.T{Authors: tag.T{pub}}, 10},
+		{&filter.T{Authors: tag.T{pub}, Since: since}, 5},
+		// events found by both tag values are counted once
+		{&filter.T{Tags: filter.TagMap{"#p": {p1, p2}}}, 10},
+		{&filter.T{Tags: filter.TagMap{"#p": {p2}}}, 4},
+		// kinds are not in the tag index and must be decoded
+		{&filter.T{Tags: filter.TagMap{"#p": {p2}},
+			Kinds: kinds.T{kind.TextNote}}, 2},
+		{&filter.T{Authors: tag.T{pub}, Kinds: kinds.T{kind.Reaction},
+			Tags: filter.TagMap{"#p": {p2}}}, 2},
+		{&filter.T{Since: since}, 5},
+	} {
+		count, approx, err := b.CountEventsApproximate(b.Ctx, test.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != test.n || approx {
+			t.Errorf("test %d: expected count %d, got %d approximate %v", i,
+				test.n, count, approx)
+		}
+	}
+	b.ApproximateCountAbove = 5
+	count, approx, err := b.CountEventsApproximate(b.Ctx,
+		&filter.T{Authors: tag.T{pub}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx || count != 10 {
+		t.Fatalf("expected approximate count of 10, got %d approximate %v",
+			count, approx)
+	}
+}
@@ -6,20 +6,29 @@ import (
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
-	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/id"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"github.com/dgraph-io/badger/v4"
 )
 
 var deleteCounter atomic.Uint32
 
+// DeleteEvent removes an event and all of its indexes from the event store, and
+// writes a tombstone for the event ID so that it will not be stored again if it
+// is published again or revived from an L2.
+//
+// The tombstone is written even if the event is not in the event store.
 func (b *Backend) DeleteEvent(c context.T, ev *event.T) (err error) {
 	deletionHappened := false
 
 	err = b.Update(func(txn *badger.Txn) (err error) {
+		if err = setTombstone(txn, GetTombstoneKey(ev),
+			timestamp.Now()); chk.E(err) {
+			return
+		}
 		idx := make([]byte, 1, 1+serial.Len)
 		idKey := index.Id.Key(id.New(ev.ID))
 		opts := badger.IteratorOptions{
@@ -38,7 +47,8 @@ func (b *Backend) DeleteEvent(c context.T, ev *event.T) (err error) {
 		it.Close()
 		// if no idx was found, end here, this event doesn't exist
 		if len(idx) == 1 {
-			return eventstore.ErrEventNotExists
+			log.T.Ln("tombstoned event not in event store", ev.ID, b.Path)
+			return
 		}
 		// set this so we'll run the GC later
 		deletionHappened = true
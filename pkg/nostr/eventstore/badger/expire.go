@@ -0,0 +1,95 @@
+package badger
+
+import (
+	"encoding/binary"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
+)
+
+// expiredKeys returns the expiration index keys of events that have expired as
+// of a given time.
+func expiredKeys(txn *badger.Txn, now timestamp.T) (keys [][]byte) {
+	prf := []byte{index.Expiration.B()}
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+	defer it.Close()
+	for it.Rewind(); it.ValidForPrefix(prf); it.Next() {
+		k := it.Item().KeyCopy(nil)
+		if len(k) != index.KeySizes[index.Expiration] {
+			continue
+		}
+		// keys are sorted by expiry so the rest have not expired yet.
+		if timestamp.T(binary.BigEndian.Uint64(k[1:])) > now {
+			break
+		}
+		keys = append(keys, k)
+	}
+	return
+}
+
+// expiredSerials returns the set of serials of events that have expired and not
+// yet been swept by the garbage collector.
+func expiredSerials(txn *badger.Txn) (sers map[uint64]struct{}) {
+	sers = make(map[uint64]struct{})
+	for _, k := range expiredKeys(txn, timestamp.Now()) {
+		sers[serial.FromKey(k).Uint64()] = struct{}{}
+	}
+	return
+}
+
+// GCExpired deletes all events that have passed their NIP-40 expiration time,
+// along with their indexes, and returns the serials of the events deleted.
+//
+// Events that have been pruned to the L2 only have their expiration index
+// removed, the rest is removed when the pruned event indexes are collected.
+func (b *Backend) GCExpired() (expired DelItems, err error) {
+	var keys [][]byte
+	if err = b.View(func(txn *badger.Txn) (err error) {
+		keys = expiredKeys(txn, timestamp.Now())
+		return
+	}); chk.E(err) {
+		return
+	}
+	for _, k := range keys {
+		ser := serial.FromKey(k)
+		if err = b.Update(func(txn *badger.Txn) (err error) {
+			evKey := index.Event.Key(ser)
+			var item *badger.Item
+			if item, err = txn.Get(evKey); err != nil ||
+				item.ValueSize() == sha256.Size {
+				// missing or pruned, just remove the expiration key
+				return txn.Delete(k)
+			}
+			var v []byte
+			if v, err = item.ValueCopy(nil); chk.E(err) {
+				return
+			}
+			ev, uErr := nostrbinary.Unmarshal(v)
+			if chk.E(uErr) {
+				return txn.Delete(k)
+			}
+			// the expiration key is also one of the event's index keys
+			for _, ik := range GetIndexKeysForEvent(ev, ser) {
+				if err = txn.Delete(ik); chk.E(err) {
+					return
+				}
+			}
+			if err = txn.Delete(GetCounterKey(ser)); chk.E(err) {
+				return
+			}
+			log.T.F("deleting expired event %s %s", ev.ID, b.Path)
+			return txn.Delete(evKey)
+		}); chk.E(err) {
+			return
+		}
+		expired = append(expired, ser.Uint64())
+	}
+	if len(expired) > 0 {
+		log.D.F("deleted %d expired events %s", len(expired), b.Path)
+	}
+	return
+}
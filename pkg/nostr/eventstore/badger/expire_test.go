@@ -0,0 +1,47 @@
+package badger
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestGCExpired(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	now := timestamp.Now()
+	expiry := func(ts timestamp.T) tags.T {
+		return tags.T{{event.ExpirationTag, strconv.FormatInt(ts.I64(), 10)}}
+	}
+	expired := newTestEvent(t, sec, kind.TextNote, now-20, expiry(now-10))
+	live := newTestEvent(t, sec, kind.TextNote, now-20, expiry(now+1000))
+	plain := newTestEvent(t, sec, kind.TextNote, now-20, nil)
+	for _, ev := range []*event.T{expired, live, plain} {
+		if err := b.SaveEvent(b.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countResults(t, b, &filter.T{Kinds: kinds.T{kind.TextNote}}); n != 2 {
+		t.Fatalf("expected 2 unexpired events, got %d", n)
+	}
+	swept, err := b.GCExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swept) != 1 {
+		t.Fatalf("expected 1 expired event swept, got %d", len(swept))
+	}
+	if swept, err = b.GCExpired(); err != nil || len(swept) != 0 {
+		t.Fatalf("expected nothing left to sweep, got %d %v", len(swept), err)
+	}
+	if n := countResults(t, b, &filter.T{Kinds: kinds.T{kind.TextNote}}); n != 2 {
+		t.Fatalf("expected 2 events after sweep, got %d", n)
+	}
+}
@@ -25,7 +25,7 @@ func (b *Backend) GarbageCollector() {
 		b.Path,
 	)
 	var err error
-	if err = b.GCRun(); chk.E(err) {
+	if _, _, _, err = b.GCRun(); chk.E(err) {
 	}
 	GCticker := time.NewTicker(b.GCFrequency)
 	// force sync to disk every so often, this might be normally about 10 minutes.
@@ -38,18 +38,40 @@ out:
 			break out
 		case <-GCticker.C:
 			// log.T.Ln("running GC", b.Path)
-			if err = b.GCRun(); chk.E(err) {
+			if _, _, _, err = b.GCRun(); chk.E(err) {
 			}
 		case <-syncTicker.C:
 			chk.E(b.DB.Sync())
+		case freq := <-b.gcFrequency:
+			GCticker.Reset(freq)
+			syncTicker.Reset(freq * 10)
 		}
 	}
 	log.I.Ln("closing badger event store garbage collector")
 }
 
-func (b *Backend) GCRun() (err error) {
+// GCRun deletes expired events, then marks and sweeps the events and indexes
+// that need to be pruned to bring the event store below its low water marks,
+// and returns the serials that were deleted and pruned.
+//
+// If there is no size limit only expired events, and those that the Retention
+// rules always prune, are deleted.
+func (b *Backend) GCRun() (expired, pruneEvents, pruneIndexes DelItems,
+	err error) {
+
+	b.gcMx.Lock()
+	defer b.gcMx.Unlock()
 	log.T.Ln("running GC", b.Path)
-	var pruneEvents, pruneIndexes DelItems
+	b.GCRuns.Inc()
+	if expired, err = b.GCExpired(); chk.E(err) {
+		return
+	}
+	if b.DBSizeLimit == 0 && !b.Retention.Enforced() {
+		return
+	}
+	// the least recently accessed events are pruned first, so the access times
+	// must be up to date
+	chk.E(b.FlushAccesses())
 	if pruneEvents, pruneIndexes, err = b.GCMark(); chk.E(err) {
 		return
 	}
@@ -62,3 +84,32 @@ func (b *Backend) GCRun() (err error) {
 	}
 	return
 }
+
+// SetGCParams changes the size limit, low and high water marks and frequency
+// of the garbage collector while it is running. The change waits for a garbage
+// collector run in progress to finish, and a frequency of zero leaves it
+// unchanged.
+func (b *Backend) SetGCParams(sizeLimit, lowWater, highWater int,
+	frequency time.Duration) {
+
+	b.gcMx.Lock()
+	defer b.gcMx.Unlock()
+	b.DBSizeLimit, b.DBLowWater, b.DBHighWater = sizeLimit, lowWater,
+		highWater
+	if frequency > 0 && frequency != b.GCFrequency {
+		b.GCFrequency = frequency
+		if b.gcFrequency != nil {
+			// only the latest frequency matters if the collector has not
+			// picked up the previous one yet
+			select {
+			case <-b.gcFrequency:
+			default:
+			}
+			b.gcFrequency <- frequency
+		}
+	}
+	log.I.F("garbage collector parameters changed: max size %0.3f MB; "+
+		"high water %d%%; low water %d%%; GC check frequency %v",
+		float32(b.DBSizeLimit)/units.Mb, b.DBHighWater, b.DBLowWater,
+		b.GCFrequency)
+}
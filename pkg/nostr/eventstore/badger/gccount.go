@@ -10,6 +10,7 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/createdat"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"github.com/Hubmakerlabs/replicatr/pkg/units"
 	"github.com/dgraph-io/badger/v4"
@@ -44,20 +45,30 @@ func (b *Backend) GCCount() (unpruned, pruned count.Items, unprunedTotal,
 		item.KeyCopy(key)
 		ser := serial.FromKey(key)
 		size := uint32(item.ValueSize())
-		totalCounter++
 		countMx.Lock()
+		totalCounter++
 		if size == sha256.Size {
 			pruned = append(pruned, &count.Item{
 				Serial: ser.Uint64(),
 				Size:   PrunedLen,
 			})
-		} else {
-			unpruned = append(unpruned, &count.Item{
-				Serial: ser.Uint64(),
-				Size:   size + KeyLen,
-			})
+			countMx.Unlock()
+			return
 		}
 		countMx.Unlock()
+		it := &count.Item{Serial: ser.Uint64(), Size: size + KeyLen}
+		// the retention rules need the author, kind and age of the event
+		chk.D(item.Value(func(v []byte) (err error) {
+			var pub []byte
+			if pub, it.CreatedAt, it.Kind, err = nostrbinary.Peek(v); err != nil {
+				return
+			}
+			copy(it.PubKey[:], pub)
+			return
+		}))
+		countMx.Lock()
+		unpruned = append(unpruned, it)
+		countMx.Unlock()
 		return
 	}
 	// started := time.Now()
@@ -74,12 +85,18 @@ func (b *Backend) GCCount() (unpruned, pruned count.Items, unprunedTotal,
 	// pruneStarted := time.Now()
 	counterStream := b.DB.NewStream()
 	counterStream.Prefix = []byte{index.Counter.B()}
-	v := make([]byte, createdat.Len)
 	countFresh = make(count.Freshes, 0, totalCounter)
 	counterStream.ChooseKey = func(item *badger.Item) (b bool) {
 		key := make([]byte, index.Len+serial.Len)
 		item.KeyCopy(key)
 		s64 := serial.FromKey(key).Uint64()
+		v := make([]byte, createdat.Len)
+		if item.ValueSize() != createdat.Len {
+			return
+		}
+		if _, err := item.ValueCopy(v); chk.E(err) {
+			return
+		}
 		countMx.Lock()
 		countFresh = append(countFresh,
 			&count.Fresh{
@@ -103,37 +120,42 @@ func (b *Backend) GCCount() (unpruned, pruned count.Items, unprunedTotal,
 		sort.Sort(prunedBySerial)
 	}
 	// both slices are now sorted by serial, so we can now iterate the freshness
-	// slice and write in the access timestamps to the unpruned
+	// slice and write in the access timestamps to the unpruned and pruned
 	//
 	// this provides the least amount of iteration and computation to essentially
-	// zip two tables together
+	// zip the tables together. Counters of events that no longer exist are
+	// skipped over.
 	var unprunedCursor, prunedCursor int
 	// we also need to create a map of serials to their respective array index, and
 	// we know how big it has to be so we can avoid allocations during the iteration.
 	//
 	// if there is no L2 this will be an empty map and have nothing added to it.
 	prunedMap := make(map[uint64]int, len(prunedBySerial))
-	for i := range countFresh {
-		// populate freshness of unpruned item
-		if len(unprunedBySerial) > i && countFresh[i].Serial ==
-			unprunedBySerial[unprunedCursor].Serial {
-			// add the counter record to the size
-			unprunedBySerial[unprunedCursor].Size += CounterLen
-			unprunedBySerial[unprunedCursor].Freshness = countFresh[i].Freshness
+	for i := range prunedBySerial {
+		prunedMap[prunedBySerial[i].Serial] = i
+	}
+	for _, f := range countFresh {
+		for unprunedCursor < len(unprunedBySerial) &&
+			unprunedBySerial[unprunedCursor].Serial < f.Serial {
 			unprunedCursor++
-			// if there is no L2 we should not see any here anyway
-		} else if b.HasL2 && len(prunedBySerial) > 0 && len(prunedBySerial) < prunedCursor {
-			if countFresh[i].Serial ==
-				prunedBySerial[prunedCursor].Serial {
-				// populate freshness of pruned item
-				ps := prunedBySerial[prunedCursor]
-				// add the counter record to the size
-				ps.Size += CounterLen
-				ps.Freshness = countFresh[i].Freshness
-				prunedMap[ps.Serial] = prunedCursor
-				prunedCursor++
-			}
 		}
+		for prunedCursor < len(prunedBySerial) &&
+			prunedBySerial[prunedCursor].Serial < f.Serial {
+			prunedCursor++
+		}
+		var it *count.Item
+		if unprunedCursor < len(unprunedBySerial) &&
+			unprunedBySerial[unprunedCursor].Serial == f.Serial {
+			it = unprunedBySerial[unprunedCursor]
+		} else if prunedCursor < len(prunedBySerial) &&
+			prunedBySerial[prunedCursor].Serial == f.Serial {
+			it = prunedBySerial[prunedCursor]
+		} else {
+			continue
+		}
+		// add the counter record to the size
+		it.Size += CounterLen
+		it.Freshness = f.Freshness
 	}
 	if b.HasL2 {
 		// lastly, we need to count the size of all relevant transactions from the
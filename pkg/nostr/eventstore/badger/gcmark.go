@@ -4,40 +4,88 @@ import (
 	"sort"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/count"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"github.com/Hubmakerlabs/replicatr/pkg/units"
 )
 
 type DelItems []uint64
 
 // GCMark first gathers the serial, data size and last accessed information
-// about all events and pruned events using GCCount then sorts the results of
-// the events and indexes by least recently accessed and generates the set of
-// serials of events that need to be deleted
+// about all events and pruned events using GCCount, then applies the Retention
+// rules and sorts the remaining events by priority and least recently accessed
+// and the indexes by least recently accessed, and generates the set of serials
+// of events that need to be deleted
 func (b *Backend) GCMark() (pruneEvents, pruneIndexes DelItems, err error) {
 	var unpruned, pruned count.Items
 	var uTotal, pTotal int
 	if unpruned, pruned, uTotal, pTotal, err = b.GCCount(); chk.E(err) {
 		return
 	}
+	pruneEvents, pruneIndexes = b.mark(unpruned, pruned, uTotal, pTotal)
+	return
+}
+
+// GCPlan is what the garbage collector would prune, with the census of the
+// event store it was marked from.
+type GCPlan struct {
+	// Unpruned are the events in the event store, and Pruned are the events
+	// that have been pruned to the L2 leaving only their indexes.
+	Unpruned, Pruned count.Items
+	// UnprunedTotal and PrunedTotal are their sizes in bytes.
+	UnprunedTotal, PrunedTotal int
+	// PruneEvents are the serials of the events that would be pruned, and
+	// PruneIndexes those of the pruned events whose indexes would be deleted.
+	PruneEvents, PruneIndexes DelItems
+}
+
+// GCPlan counts and marks the events and indexes that the garbage collector
+// would prune, without changing anything.
+func (b *Backend) GCPlan() (plan *GCPlan, err error) {
+	b.gcMx.Lock()
+	defer b.gcMx.Unlock()
+	chk.E(b.FlushAccesses())
+	plan = &GCPlan{}
+	if plan.Unpruned, plan.Pruned, plan.UnprunedTotal, plan.PrunedTotal,
+		err = b.GCCount(); chk.E(err) {
+		return
+	}
+	plan.PruneEvents, plan.PruneIndexes = b.mark(plan.Unpruned, plan.Pruned,
+		plan.UnprunedTotal, plan.PrunedTotal)
+	return
+}
+
+// mark selects the events and indexes to prune from the results of GCCount.
+func (b *Backend) mark(unpruned, pruned count.Items, uTotal,
+	pTotal int) (pruneEvents, pruneIndexes DelItems) {
+
+	prune, eligible := b.Retention.Mark(unpruned, timestamp.Now())
+	remaining := uTotal
+	for _, it := range prune {
+		remaining -= int(it.Size)
+		pruneEvents = append(pruneEvents, it.Serial)
+	}
+	if len(prune) > 0 {
+		log.D.F("found %d events to prune by retention rules %s", len(prune),
+			b.Path)
+	}
 	hw, lw := b.GetEventHeadroom()
-	if uTotal > hw {
+	if b.DBSizeLimit > 0 && uTotal > hw {
 		// run event GC mark
-		sort.Sort(unpruned)
-		pruneOff := uTotal - lw
-		var cumulative, lastIndex int
-		for lastIndex = range unpruned {
-			if cumulative > pruneOff {
+		var n int
+		for _, it := range eligible {
+			if remaining <= lw {
 				break
 			}
-			cumulative += int(unpruned[lastIndex].Size)
-			pruneEvents = append(pruneEvents, unpruned[lastIndex].Serial)
+			remaining -= int(it.Size)
+			pruneEvents = append(pruneEvents, it.Serial)
+			n++
 		}
 		log.D.F("found %d events to prune, which will bring current "+
 			"utilization down to %0.6f Gb %s",
-			lastIndex-1, float64(uTotal-cumulative)/units.Gb, b.Path)
+			n, float64(remaining)/units.Gb, b.Path)
 	}
 	l2hw, l2lw := b.GetIndexHeadroom()
-	if b.HasL2 && pTotal > l2hw {
+	if b.HasL2 && b.DBSizeLimit > 0 && pTotal > l2hw {
 		// run index GC mark
 		sort.Sort(pruned)
 		var lastIndex int
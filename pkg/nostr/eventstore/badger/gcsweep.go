@@ -79,7 +79,8 @@ func (b *Backend) GCSweep(evs, idxs DelItems) (err error) {
 		// we have to remove everything
 		prfs := [][]byte{{index.Event.B()}}
 		prfs = append(prfs, index.FilterPrefixes...)
-		prfs = append(prfs, []byte{index.Counter.B()})
+		prfs = append(prfs, []byte{index.Counter.B()},
+			[]byte{index.Expiration.B()})
 		for _, prf := range prfs {
 			stream := b.DB.NewStream()
 			stream.Prefix = prf
@@ -9,6 +9,7 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/kinder"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/pubkey"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/search"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
 	"golang.org/x/exp/slices"
 )
@@ -86,5 +87,15 @@ func GetIndexKeysForEvent(ev *event.T, ser *serial.T) (keyz [][]byte) {
 		// log.T.F("date key: %x %0x %0x", k[0], k[1:9], k[9:])
 		keyz = append(keyz, k)
 	}
+	// ~ by NIP-40 expiration date
+	if exp, ok := ev.Expiration(); ok {
+		k := index.Expiration.Key(createdat.New(exp), ser)
+		keyz = append(keyz, k)
+	}
+	// ~ by NIP-50 search words + date
+	for word := range search.Terms(ev) {
+		k := index.Word.Key(GetWordHash(word), CA, ser)
+		keyz = append(keyz, k)
+	}
 	return
 }
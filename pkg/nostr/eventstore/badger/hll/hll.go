@@ -0,0 +1,64 @@
+// Package hll is a HyperLogLog sketch for estimating the number of distinct
+// 64 bit values, such as event serials, in a fixed amount of memory.
+package hll
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// Precision is the number of bits of the hash used to select a register.
+	Precision = 14
+	// Registers is the number of registers, which gives a standard error of
+	// about 0.8%.
+	Registers = 1 << Precision
+)
+
+// T is a HyperLogLog sketch.
+type T struct {
+	registers [Registers]uint8
+}
+
+// New creates an empty sketch.
+func New() (h *T) { return &T{} }
+
+// hash mixes the bits of a value so that sequential values such as serials are
+// spread evenly across the registers.
+func hash(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
+
+// Add adds a value to the sketch.
+func (h *T) Add(v uint64) {
+	x := hash(v)
+	idx := x >> (64 - Precision)
+	rank := uint8(bits.LeadingZeros64(x<<Precision|1<<(Precision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Count returns the estimated number of distinct values added to the sketch.
+func (h *T) Count() (n uint64) {
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	m := float64(Registers)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// small ranges are more accurately counted by the empty registers
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
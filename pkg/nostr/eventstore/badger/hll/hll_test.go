@@ -0,0 +1,21 @@
+package hll
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	for _, n := range []uint64{0, 1, 100, 10000, 1000000} {
+		h := New()
+		for i := uint64(0); i < n; i++ {
+			h.Add(i)
+			// duplicates do not change the estimate
+			h.Add(i)
+		}
+		count := h.Count()
+		if diff := math.Abs(float64(count) - float64(n)); diff > float64(n)*0.03 {
+			t.Errorf("expected about %d, got %d", n, count)
+		}
+	}
+}
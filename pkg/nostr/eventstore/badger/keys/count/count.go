@@ -1,6 +1,7 @@
 package count
 
 import (
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 )
 
@@ -8,6 +9,11 @@ type Item struct {
 	Serial    uint64
 	Size      uint32
 	Freshness timestamp.T
+	// PubKey, Kind and CreatedAt are those of the event, which are only known
+	// for events that have not been pruned.
+	PubKey    [32]byte
+	Kind      kind.T
+	CreatedAt timestamp.T
 }
 
 type Items []*Item
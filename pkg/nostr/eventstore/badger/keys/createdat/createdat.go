@@ -44,6 +44,6 @@ func FromKey(k []byte) (p *T) {
 		panic("cannot get a serial without at least 16 bytes")
 	}
 	key := make([]byte, Len)
-	copy(key, k[len(k)-serial.Len:len(k)-Len+serial.Len])
+	copy(key, k[len(k)-serial.Len-Len:len(k)-serial.Len])
 	return &T{Val: timestamp.FromBytes(key)}
 }
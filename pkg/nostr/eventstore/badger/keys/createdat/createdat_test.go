@@ -19,3 +19,12 @@ func TestT(t *testing.T) {
 		t.Fatalf("expected %d got %d", n.Int(), el.Val.Int())
 	}
 }
+
+func TestFromKey(t *testing.T) {
+	n := timestamp.Now()
+	key := append([]byte{1, 2, 3}, n.Bytes()...)
+	key = append(key, 0, 0, 0, 0, 0, 0, 0, 7)
+	if c := FromKey(key); c.Val != n {
+		t.Fatalf("expected %d got %d", n.Int(), c.Val.Int())
+	}
+}
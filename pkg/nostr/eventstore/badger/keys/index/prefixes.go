@@ -7,6 +7,7 @@ import (
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/kinder"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/pubkey"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/minio/sha256-simd"
 )
 
 type P byte
@@ -99,6 +100,29 @@ const (
 	//
 	//   [ 9 ][ 8 bytes Serial ] : value: [ 8 bytes timestamp ]
 	Counter
+
+	// Tombstone marks an event ID, or the hash of the address of a replaceable
+	// event, as deleted. The value is the timestamp of the deletion event, for
+	// addresses, versions created at or before this are deleted. The timestamp
+	// of an event ID that was not in the event store when it was deleted is
+	// followed by the pubkeys of the deleters, and only their events with the
+	// ID are deleted.
+	//
+	//   [ 10 ][ 32 bytes event ID or address hash ] : value: [ 8 bytes timestamp ][ 32 bytes pubkey ]...
+	Tombstone
+
+	// Expiration contains the NIP-40 expiration timestamp of events that have
+	// one, so expired events can be found and swept in timestamp order.
+	//
+	//   [ 11 ][ 8 bytes timestamp.T ][ 8 bytes Serial ]
+	Expiration
+
+	// Word is the NIP-50 search index, with a key for each distinct word in
+	// the searchable text of an event, identified by the first 8 bytes of the
+	// hash of the word, followed by the timestamp and serial.
+	//
+	//   [ 12 ][ 8 bytes word hash ][ 8 bytes timestamp.T ][ 8 bytes Serial ]
+	Word
 )
 
 // FilterPrefixes is a slice of the prefixes used by filter index to enable a loop
@@ -112,6 +136,7 @@ var FilterPrefixes = [][]byte{
 	{Tag.B()},
 	{Tag32.B()},
 	{TagAddr.B()},
+	{Word.B()},
 }
 
 // KeySizes are the byte size of keys of each type of key prefix. int(P) or call the P.I() method
@@ -138,4 +163,13 @@ var KeySizes = []int{
 	1 + kinder.Len + pubkey.Len + 100 + createdat.Len + serial.Len,
 	// Counter
 	1 + serial.Len,
+	// Tombstone
+	1 + sha256.Size,
+	// Expiration
+	1 + createdat.Len + serial.Len,
+	// Word
+	1 + WordHashLen + createdat.Len + serial.Len,
 }
+
+// WordHashLen is the number of bytes of the hash of a word in a Word key.
+const WordHashLen = 8
@@ -6,11 +6,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Hubmakerlabs/replicatr/pkg/atomic"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/del"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
 	"github.com/Hubmakerlabs/replicatr/pkg/slog"
 	"github.com/Hubmakerlabs/replicatr/pkg/units"
 	"github.com/dgraph-io/badger/v4"
@@ -19,7 +21,10 @@ import (
 
 var log, chk = slog.New(os.Stderr)
 
-var _ eventstore.Store = (*Backend)(nil)
+var (
+	_ eventstore.Store              = (*Backend)(nil)
+	_ eventstore.ApproximateCounter = (*Backend)(nil)
+)
 
 type PruneFunc func(ifc any, deleteItems del.Items) (err error)
 
@@ -41,19 +46,51 @@ type Backend struct {
 	// exceeded.
 	DBHighWater int
 	// GCFrequency is the frequency of checks of the current utilisation.
-	GCFrequency    time.Duration
-	HasL2          bool
-	BlockCacheSize int
-	InitLogLevel   int
-	Logger         *logger
+	GCFrequency time.Duration
+	// ApproximateCountAbove is the number of matching events above which a
+	// count is estimated rather than exact, or zero to always count exactly.
+	ApproximateCountAbove int
+	HasL2                 bool
+	BlockCacheSize        int
+	InitLogLevel          int
+	Logger                *logger
+	// Retention are the rules for which events the garbage collector prunes,
+	// or nil to prune the least recently accessed events.
+	Retention *Retention
+	// ManualGC disables the garbage collector that runs in the background, so
+	// events are only pruned by calling GCRun or GCSweep.
+	ManualGC bool
 	// DB is the badger db interface
 	*badger.DB
 	// seq is the monotonic collision free index for raw event storage.
 	seq *badger.Sequence
+	// replaceMx serializes saving replaceable events.
+	replaceMx sync.Mutex
+	// GCRuns is the number of garbage collector runs since startup.
+	GCRuns atomic.Uint64
+	// gcMx serializes garbage collector runs and changes to the garbage
+	// collector parameters.
+	gcMx sync.Mutex
+	// gcFrequency receives a new GCFrequency for the GarbageCollector.
+	gcFrequency chan time.Duration
+	// accessMx protects accesses, the latest access time of each event serial
+	// that has not yet been written to its access counter record.
+	accessMx sync.Mutex
+	accesses map[uint64]timestamp.T
+	// flushMx serializes writing the access times.
+	flushMx sync.Mutex
 }
 
 const DefaultMaxLimit = 1024
 
+// SaveRetries is the number of times saving an event is attempted if the
+// transaction conflicts with another.
+const SaveRetries = 3
+
+// DefaultGCFrequency is the interval between garbage collector runs if none is
+// configured.
+const DefaultGCFrequency = time.Minute
+
 // GetBackend returns a reasonably configured badger.Backend.
 //
 // The variadic params correspond to DBSizeLimit, DBLowWater, DBHighWater and
@@ -123,16 +160,36 @@ func (b *Backend) Init() (err error) {
 	if b.MaxLimit == 0 {
 		b.MaxLimit = DefaultMaxLimit
 	}
-	if b.DBSizeLimit > 0 {
-		go b.GarbageCollector()
-	} else {
-		go b.GCCount()
+	if b.GCFrequency == 0 {
+		b.GCFrequency = DefaultGCFrequency
+	}
+	if b.DBSizeLimit == 0 {
+		// the census is run under the GC lock so Close waits for it
+		go func() {
+			b.gcMx.Lock()
+			defer b.gcMx.Unlock()
+			_, _, _, _, _ = b.GCCount()
+		}()
 		// go b.IndexGCCount()
 	}
+	// the garbage collector always runs to sweep expired events, unless it is
+	// being run manually
+	b.gcFrequency = make(chan time.Duration, 1)
+	if !b.ManualGC {
+		go b.GarbageCollector()
+	}
+	go b.AccessFlusher()
 	return nil
 }
 
-func (b *Backend) Close() { _, _ = b.DB.Close(), b.seq.Release() }
+// Close waits for a garbage collector run in progress, writes any recorded
+// access times and closes the database.
+func (b *Backend) Close() {
+	b.gcMx.Lock()
+	defer b.gcMx.Unlock()
+	chk.E(b.FlushAccesses())
+	_, _ = b.DB.Close(), b.seq.Release()
+}
 
 // SerialKey returns a key used for storing events, and the raw serial counter
 // bytes to copy into index keys.
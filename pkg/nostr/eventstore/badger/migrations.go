@@ -6,11 +6,15 @@ import (
 	"fmt"
 
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
 	"github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
 )
 
 func (b *Backend) runMigrations() (err error) {
-	return b.Update(func(txn *badger.Txn) (err error) {
+	var reindex, recode bool
+	if err = b.Update(func(txn *badger.Txn) (err error) {
 		var version uint16
 		var item *badger.Item
 		item, err = txn.Get([]byte{index.Version.B()})
@@ -56,12 +60,140 @@ func (b *Backend) runMigrations() (err error) {
 			chk.E(b.bumpVersion(txn, 3))
 		}
 
+		// version 4 adds the expiration and search indexes, which are written
+		// for existing events after this transaction as there may be too
+		// many for one transaction.
 		if version < 4 {
-			// ...
+			reindex = true
+		}
+
+		// version 5 replaces the gob encoding of stored events with the
+		// nostrbinary encoding, which is also done after this transaction.
+		if version < 5 {
+			recode = true
 		}
 
 		return nil
-	})
+	}); err != nil {
+		return
+	}
+	if reindex {
+		if err = b.reindex(); chk.E(err) {
+			return
+		}
+		if err = b.Update(func(txn *badger.Txn) error {
+			return b.bumpVersion(txn, 4)
+		}); chk.E(err) {
+			return
+		}
+	}
+	if recode {
+		if err = b.recode(); chk.E(err) {
+			return
+		}
+		if err = b.Update(func(txn *badger.Txn) error {
+			return b.bumpVersion(txn, 5)
+		}); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// recode rewrites all the stored events that are still gob encoded in the
+// nostrbinary encoding. Events that have been pruned to the L2 are skipped, as
+// are any that fail to decode, which are left as they were.
+func (b *Backend) recode() (err error) {
+	batch := b.DB.NewWriteBatch()
+	defer batch.Cancel()
+	var n int
+	prf := []byte{index.Event.B()}
+	if err = b.View(func(txn *badger.Txn) (err error) {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+		defer it.Close()
+		for it.Rewind(); it.ValidForPrefix(prf); it.Next() {
+			item := it.Item()
+			if item.KeySize() != 1+serial.Len ||
+				item.ValueSize() == sha256.Size {
+				continue
+			}
+			var v []byte
+			if v, err = item.ValueCopy(nil); chk.E(err) {
+				return
+			}
+			if nostrbinary.IsBinary(v) {
+				continue
+			}
+			ev, uErr := nostrbinary.Unmarshal(v)
+			if chk.E(uErr) {
+				continue
+			}
+			var bin []byte
+			if bin, err = nostrbinary.Marshal(ev); chk.E(err) {
+				err = nil
+				continue
+			}
+			if err = batch.Set(item.KeyCopy(nil), bin); chk.E(err) {
+				return
+			}
+			n++
+		}
+		return
+	}); err != nil {
+		return
+	}
+	if err = batch.Flush(); chk.E(err) {
+		return
+	}
+	if n > 0 {
+		log.I.F("re-encoded %d events %s", n, b.Path)
+	}
+	return
+}
+
+// reindex writes all the index keys of all the stored events, which adds the
+// keys of any new indexes. Events that have been pruned to the L2 are skipped.
+func (b *Backend) reindex() (err error) {
+	batch := b.DB.NewWriteBatch()
+	defer batch.Cancel()
+	var n int
+	prf := []byte{index.Event.B()}
+	if err = b.View(func(txn *badger.Txn) (err error) {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+		defer it.Close()
+		for it.Rewind(); it.ValidForPrefix(prf); it.Next() {
+			item := it.Item()
+			if item.KeySize() != 1+serial.Len ||
+				item.ValueSize() == sha256.Size {
+				continue
+			}
+			var v []byte
+			if v, err = item.ValueCopy(nil); chk.E(err) {
+				return
+			}
+			ev, uErr := nostrbinary.Unmarshal(v)
+			if chk.E(uErr) {
+				continue
+			}
+			ser := serial.FromKey(item.KeyCopy(nil))
+			for _, k := range GetIndexKeysForEvent(ev, ser) {
+				if err = batch.Set(k, nil); chk.E(err) {
+					return
+				}
+			}
+			n++
+		}
+		return
+	}); err != nil {
+		return
+	}
+	if err = batch.Flush(); chk.E(err) {
+		return
+	}
+	if n > 0 {
+		log.I.F("reindexed %d events %s", n, b.Path)
+	}
+	return
 }
 
 func (b *Backend) bumpVersion(txn *badger.Txn, version uint16) error {
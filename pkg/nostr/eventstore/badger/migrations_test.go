@@ -0,0 +1,82 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// storedEvents returns the values of all the stored events by their keys.
+func storedEvents(t *testing.T, b *Backend) (values map[string][]byte) {
+	values = make(map[string][]byte)
+	prf := []byte{index.Event.B()}
+	if err := b.View(func(txn *badger.Txn) (err error) {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+		defer it.Close()
+		for it.Rewind(); it.ValidForPrefix(prf); it.Next() {
+			item := it.Item()
+			if item.KeySize() != 1+serial.Len {
+				continue
+			}
+			var v []byte
+			if v, err = item.ValueCopy(nil); err != nil {
+				return
+			}
+			values[string(item.KeyCopy(nil))] = v
+		}
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestMigrateGobEvents(t *testing.T) {
+	b := newTestBackend(t)
+	ev := newTestEvent(t, keys.GeneratePrivateKey(), kind.TextNote,
+		timestamp.Now(), nil)
+	if err := b.SaveEvent(b.Ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	// store the event as it was before version 5
+	gb, err := nostrbinary.MarshalGob(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Update(func(txn *badger.Txn) (err error) {
+		for k := range storedEvents(t, b) {
+			if err = txn.Set([]byte(k), gb); err != nil {
+				return
+			}
+		}
+		return b.bumpVersion(txn, 4)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countResults(t, b, &filter.T{IDs: tag.T{ev.ID.String()}}); n != 1 {
+		t.Fatalf("expected gob encoded event to be found, got %d", n)
+	}
+	if err = b.runMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	values := storedEvents(t, b)
+	if len(values) != 1 {
+		t.Fatalf("expected 1 stored event, got %d", len(values))
+	}
+	for _, v := range values {
+		if !nostrbinary.IsBinary(v) {
+			t.Errorf("event was not re-encoded")
+		}
+	}
+	if n := countResults(t, b, &filter.T{IDs: tag.T{ev.ID.String()}}); n != 1 {
+		t.Fatalf("expected re-encoded event to be found, got %d", n)
+	}
+}
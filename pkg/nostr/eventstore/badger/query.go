@@ -21,6 +21,17 @@ import (
 func (b *Backend) QueryEvents(c context.T, f *filter.T) (ch event.C,
 	err error) {
 	ch = make(event.C, 1)
+	if f.Search != "" {
+		accessChan := make(chan *AccessEvent)
+		b.WG.Add(1)
+		go b.AccessLoop(c, accessChan)
+		limit := b.MaxLimit
+		if f.Limit != nil && *f.Limit > 0 && *f.Limit < limit {
+			limit = *f.Limit
+		}
+		go b.QuerySearch(c, ch, accessChan, f, limit)
+		return ch, nil
+	}
 
 	var queries []query
 	var extraFilter *filter.T
@@ -31,6 +42,7 @@ func (b *Backend) QueryEvents(c context.T, f *filter.T) (ch event.C,
 	}
 	accessChan := make(chan *AccessEvent)
 	// start up the access counter
+	b.WG.Add(1)
 	go b.AccessLoop(c, accessChan)
 	// max number of events we'll return
 	limit := b.MaxLimit
@@ -46,7 +58,10 @@ func (b *Backend) QueryEventsLoop(c context.T, ch event.C,
 	queries []query, limit int, extraFilter *filter.T, since uint64) {
 
 	var err error
+	// the searches stop sending results when the loop is finished
+	qc, cancel := context.Cancel(c)
 	defer func() {
+		cancel()
 		close(ch)
 		close(accessChan)
 	}()
@@ -62,7 +77,7 @@ func (b *Backend) QueryEventsLoop(c context.T, ch event.C,
 		default:
 		}
 		q2 := q1
-		go b.QueryEventsSearch(c, q2, since, extraFilter)
+		go b.QueryEventsSearch(qc, q2, since, extraFilter)
 	}
 	// receive results and ensure we only return the most recent ones always
 	emittedEvents := 0
@@ -153,11 +168,22 @@ func (b *Backend) QueryEventsSearch(c context.T, q2 query, since uint64,
 	})
 	if err != nil {
 		close(q2.results)
-		for _ = range q2.results {
-		}
 		return
 	}
+	defer close(q2.results)
+	// send returns false if the query has been canceled and the search should
+	// stop.
+	send := func(res Results) bool {
+		select {
+		case q2.results <- res:
+			return true
+		case <-c.Done():
+		case <-b.Ctx.Done():
+		}
+		return false
+	}
 	for _, eventKey := range eventKeys {
+		var stop bool
 		var ev *event.T
 		err = b.View(func(txn *badger.Txn) (err error) {
 			opts := badger.IteratorOptions{Reverse: true}
@@ -176,16 +202,8 @@ func (b *Backend) QueryEventsSearch(c context.T, q2 query, since uint64,
 					evt := &event.T{}
 					log.T.F("found event stub %0x must seek in L2", v)
 					evt.ID, _ = eventid.New(hex.Enc(v))
-					select {
-					case <-c.Done():
-						return
-					case <-b.Ctx.Done():
-						log.I.Ln("backend context canceled")
-						return
-					default:
-					}
-					q2.results <- Results{Ev: evt, TS: timestamp.Now(),
-						Ser: ser}
+					stop = !send(Results{Ev: evt, TS: timestamp.Now(),
+						Ser: ser})
 					return
 				}
 				if ev, err = nostrbinary.Unmarshal(v); chk.E(err) {
@@ -195,18 +213,28 @@ func (b *Backend) QueryEventsSearch(c context.T, q2 query, since uint64,
 					log.D.S("got nil event from", v)
 					return
 				}
+				// never return events that have expired or been deleted
+				if ev.IsExpired() {
+					continue
+				}
+				var deleted bool
+				if deleted, err = isDeleted(txn, ev); chk.E(err) || deleted {
+					err = nil
+					continue
+				}
 				// check if this matches the other filters that were not part of the index
 				if extraFilter == nil || extraFilter.Matches(ev) {
 					res := Results{Ev: ev, TS: timestamp.Now(), Ser: ser}
-					// todo: this is getting stuck here and causing a major goroutine leak
-					q2.results <- res
+					if stop = !send(res); stop {
+						return
+					}
 				}
 			}
 			return
 		})
-	}
-	close(q2.results)
-	for _ = range q2.results {
+		if stop {
+			return
+		}
 	}
 	return
 }
@@ -0,0 +1,127 @@
+package badger
+
+import (
+	"errors"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/createdat"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/kinder"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/pubkey"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
+)
+
+// version is a stored version of a replaceable event and its serial.
+type version struct {
+	ev  *event.T
+	ser *serial.T
+}
+
+// getVersions returns the stored versions of the replaceable or parameterized
+// replaceable event with the same address as ev.
+//
+// Versions that have been pruned to the L2 only have their ID, which is enough
+// for replaceable kinds as the pubkey, kind and timestamp are in the index key,
+// but the d tag of parameterized replaceable events is not known so these are
+// skipped.
+func getVersions(txn *badger.Txn, ev *event.T) (versions []version,
+	err error) {
+
+	PK, _ := pubkey.New(ev.PubKey)
+	prf := index.PubkeyKind.Key(PK, kinder.New(ev.Kind))
+	address := eventstore.GetAddress(ev)
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+	defer it.Close()
+	for it.Rewind(); it.ValidForPrefix(prf); it.Next() {
+		k := it.Item().KeyCopy(nil)
+		if len(k) != index.KeySizes[index.PubkeyKind] {
+			continue
+		}
+		ser := serial.FromKey(k)
+		var item *badger.Item
+		if item, err = txn.Get(index.Event.Key(ser)); errors.Is(err,
+			badger.ErrKeyNotFound) {
+			err = nil
+			continue
+		} else if chk.E(err) {
+			return
+		}
+		var v []byte
+		if v, err = item.ValueCopy(nil); chk.E(err) {
+			return
+		}
+		var prev *event.T
+		if len(v) == sha256.Size {
+			if ev.Kind.IsParameterizedReplaceable() {
+				continue
+			}
+			prev = &event.T{
+				ID:        eventid.T(hex.Enc(v)),
+				PubKey:    ev.PubKey,
+				Kind:      ev.Kind,
+				CreatedAt: createdat.FromKey(k).Val,
+			}
+		} else {
+			var uErr error
+			if prev, uErr = nostrbinary.Unmarshal(v); chk.E(uErr) {
+				continue
+			}
+			// the pubkey in the index is only a prefix
+			if eventstore.GetAddress(prev) != address {
+				continue
+			}
+		}
+		versions = append(versions, version{ev: prev, ser: ser})
+	}
+	return
+}
+
+// replaceVersions applies the NIP-01 rules for replaceable and parameterized
+// replaceable events in the transaction that stores ev.
+//
+// If a version that is newer than ev is already stored,
+// eventstore.ErrEventReplaced is returned, otherwise all the older versions
+// are deleted along with their indexes.
+func (b *Backend) replaceVersions(txn *badger.Txn, ev *event.T) (err error) {
+	var versions []version
+	if versions, err = getVersions(txn, ev); err != nil {
+		return
+	}
+	for _, v := range versions {
+		if !eventstore.IsOlder(v.ev, ev) {
+			return eventstore.ErrEventReplaced
+		}
+	}
+	for _, v := range versions {
+		// pruned versions only have the indexes that don't depend on tags,
+		// the rest are left for the garbage collector.
+		for _, k := range GetIndexKeysForEvent(v.ev, v.ser) {
+			if err = txn.Delete(k); chk.E(err) {
+				return
+			}
+		}
+		if err = txn.Delete(GetCounterKey(v.ser)); chk.E(err) {
+			return
+		}
+		if err = txn.Delete(index.Event.Key(v.ser)); chk.E(err) {
+			return
+		}
+		// the L2 still has a copy of the old version, which must not be
+		// returned or revived.
+		if b.HasL2 {
+			if err = setTombstone(txn, GetTombstoneKey(v.ev),
+				timestamp.Now()); chk.E(err) {
+				return
+			}
+		}
+		log.T.F("replaced event %s with %s %s", v.ev.ID, ev.ID, b.Path)
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package badger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestReplace(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	now := timestamp.Now()
+	older := newTestEvent(t, sec, kind.ProfileMetadata, now-10, nil)
+	newer := newTestEvent(t, sec, kind.ProfileMetadata, now, nil)
+	if err := b.SaveEvent(b.Ctx, older); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, newer); err != nil {
+		t.Fatal(err)
+	}
+	f := &filter.T{Kinds: kinds.T{kind.ProfileMetadata}}
+	if n := countResults(t, b, f); n != 1 {
+		t.Fatalf("expected 1 version of profile, got %d", n)
+	}
+	if n := countResults(t, b,
+		&filter.T{IDs: tag.T{newer.ID.String()}}); n != 1 {
+		t.Fatal("newest version of profile not found")
+	}
+	if err := b.SaveEvent(b.Ctx, older); !errors.Is(err,
+		eventstore.ErrEventReplaced) {
+		t.Fatalf("expected older version to be rejected, got %v", err)
+	}
+	// parameterized replaceable events are replaced only by the same d tag
+	k := kind.T(30023)
+	a1 := newTestEvent(t, sec, k, now-10, tags.T{{"d", "a"}})
+	b1 := newTestEvent(t, sec, k, now-10, tags.T{{"d", "b"}})
+	a2 := newTestEvent(t, sec, k, now, tags.T{{"d", "a"}})
+	for _, ev := range []*event.T{a1, b1, a2} {
+		if err := b.SaveEvent(b.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f = &filter.T{Kinds: kinds.T{k}}
+	if n := countResults(t, b, f); n != 2 {
+		t.Fatalf("expected 2 addresses, got %d", n)
+	}
+	// versions created at the same time are replaced by the lowest ID
+	b2 := newTestEvent(t, sec, k, now, tags.T{{"d", "b"}})
+	b3 := newTestEvent(t, sec, k, now, tags.T{{"d", "b"}})
+	b3.Content = "other"
+	if err := b3.Sign(sec); err != nil {
+		t.Fatal(err)
+	}
+	if b3.ID < b2.ID {
+		b2, b3 = b3, b2
+	}
+	if err := b.SaveEvent(b.Ctx, b3); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, b2); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, b3); !errors.Is(err,
+		eventstore.ErrEventReplaced) {
+		t.Fatalf("expected higher ID to be rejected, got %v", err)
+	}
+	if n := countResults(t, b, f); n != 2 {
+		t.Fatalf("expected 2 addresses, got %d", n)
+	}
+}
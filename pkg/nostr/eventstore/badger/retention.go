@@ -0,0 +1,113 @@
+package badger
+
+import (
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/count"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+// Retention are the rules the garbage collector applies to decide which events
+// are pruned.
+//
+// Pinned events are never pruned. Events older than the MaxAge of their kind,
+// and the oldest events of authors over the AuthorQuota, are pruned on every
+// run. When the event store is over its high water mark the rest are pruned in
+// order of the Priority of their kind, and the least recently accessed first
+// among events of the same priority.
+//
+// A nil Retention prunes purely by last access.
+type Retention struct {
+	// Pinned returns true if the events of a hex encoded pubkey are never
+	// pruned.
+	Pinned func(pub string) bool
+	// PinKinds are the kinds of events that are never pruned.
+	PinKinds map[kind.T]bool
+	// Priority ranks kinds for pruning, events of kinds with a higher priority
+	// are pruned after those with a lower one. Kinds not listed have priority
+	// zero.
+	Priority map[kind.T]int
+	// MaxAge is the age after which events of a kind are pruned.
+	MaxAge map[kind.T]time.Duration
+	// AuthorQuota is the most events of an author that are kept, or zero for
+	// no limit.
+	AuthorQuota int
+}
+
+// Enforced returns true if there are rules that prune events even when the
+// event store is within its size limit.
+func (r *Retention) Enforced() bool {
+	return r != nil && (len(r.MaxAge) > 0 || r.AuthorQuota > 0)
+}
+
+// Mark applies the rules to the events found by GCCount, and returns the events
+// that must be pruned, and the events that may be pruned to reduce the size of
+// the event store in the order they should be. Pinned events are in neither.
+func (r *Retention) Mark(items count.Items, now timestamp.T) (prune,
+	eligible count.Items) {
+
+	if r == nil {
+		eligible = append(eligible, items...)
+		sort.Stable(eligible)
+		return
+	}
+	pinned := make(map[[32]byte]bool)
+	byAuthor := make(map[[32]byte]count.Items)
+	for _, it := range items {
+		if r.PinKinds[it.Kind] || r.isPinned(pinned, it.PubKey) {
+			continue
+		}
+		if maxAge, ok := r.MaxAge[it.Kind]; ok &&
+			it.CreatedAt.Time().Add(maxAge).Before(now.Time()) {
+			prune = append(prune, it)
+			continue
+		}
+		byAuthor[it.PubKey] = append(byAuthor[it.PubKey], it)
+	}
+	for _, evs := range byAuthor {
+		if r.AuthorQuota > 0 && len(evs) > r.AuthorQuota {
+			// the newest events of the author are kept
+			sort.SliceStable(evs, func(i, j int) bool {
+				return evs[i].CreatedAt > evs[j].CreatedAt
+			})
+			prune = append(prune, evs[r.AuthorQuota:]...)
+			evs = evs[:r.AuthorQuota]
+		}
+		eligible = append(eligible, evs...)
+	}
+	sort.SliceStable(eligible, func(i, j int) bool {
+		pi, pj := r.Priority[eligible[i].Kind], r.Priority[eligible[j].Kind]
+		if pi != pj {
+			return pi < pj
+		}
+		if eligible[i].Freshness != eligible[j].Freshness {
+			return eligible[i].Freshness < eligible[j].Freshness
+		}
+		return eligible[i].Serial < eligible[j].Serial
+	})
+	return
+}
+
+// isPinned calls Pinned once for each pubkey, remembering the results.
+func (r *Retention) isPinned(pinned map[[32]byte]bool, pub [32]byte) bool {
+	if r.Pinned == nil {
+		return false
+	}
+	p, ok := pinned[pub]
+	if !ok {
+		p = r.Pinned(hex.EncodeToString(pub[:]))
+		pinned[pub] = p
+	}
+	return p
+}
+
+// SetRetention changes the retention rules of the garbage collector, waiting
+// for a garbage collector run in progress to finish.
+func (b *Backend) SetRetention(r *Retention) {
+	b.gcMx.Lock()
+	defer b.gcMx.Unlock()
+	b.Retention = r
+}
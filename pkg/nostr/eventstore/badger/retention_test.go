@@ -0,0 +1,124 @@
+package badger
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/count"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestRetentionMark(t *testing.T) {
+	now := timestamp.Now()
+	var owner, spammer, other [32]byte
+	owner[0], spammer[0], other[0] = 1, 2, 3
+	items := count.Items{
+		// pinned by pubkey, even though it is the oldest and stalest
+		{Serial: 1, PubKey: owner, Kind: kind.TextNote, CreatedAt: 1},
+		// pinned by kind
+		{Serial: 2, PubKey: spammer, Kind: kind.FollowList, CreatedAt: 1},
+		// past the maximum age of its kind
+		{Serial: 3, PubKey: other, Kind: kind.Reaction, CreatedAt: now - 7200,
+			Freshness: now},
+		// the spammer is over the quota of 2 so the oldest is pruned
+		{Serial: 4, PubKey: spammer, Kind: kind.TextNote, CreatedAt: now - 30,
+			Freshness: now - 5},
+		{Serial: 5, PubKey: spammer, Kind: kind.TextNote, CreatedAt: now - 20,
+			Freshness: now - 4},
+		{Serial: 6, PubKey: spammer, Kind: kind.TextNote, CreatedAt: now - 10,
+			Freshness: now - 3},
+		// long form articles are pruned after notes even when staler
+		{Serial: 7, PubKey: other, Kind: kind.T(30023), CreatedAt: now - 100,
+			Freshness: now - 100},
+		{Serial: 8, PubKey: other, Kind: kind.TextNote, CreatedAt: now - 50,
+			Freshness: now - 1},
+	}
+	r := &Retention{
+		Pinned: func(pub string) bool {
+			return pub == hex.EncodeToString(owner[:])
+		},
+		PinKinds:    map[kind.T]bool{kind.FollowList: true},
+		Priority:    map[kind.T]int{30023: 1},
+		MaxAge:      map[kind.T]time.Duration{kind.Reaction: time.Hour},
+		AuthorQuota: 2,
+	}
+	serials := func(items count.Items) (s []uint64) {
+		for _, it := range items {
+			s = append(s, it.Serial)
+		}
+		return
+	}
+	prune, eligible := r.Mark(items, now)
+	if got := serials(prune); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Errorf("expected to prune [3 4], got %v", got)
+	}
+	expected := []uint64{5, 6, 8, 7}
+	got := serials(eligible)
+	if len(got) != len(expected) {
+		t.Fatalf("expected eligible %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected eligible %v, got %v", expected, got)
+		}
+	}
+	// without rules all events are eligible by last access
+	var none *Retention
+	if prune, eligible = none.Mark(items, now); len(prune) != 0 ||
+		len(eligible) != len(items) || eligible[0].Freshness != 0 ||
+		eligible[len(items)-1].Serial != 3 {
+		t.Errorf("expected all events by last access, got %v %v",
+			serials(prune), serials(eligible))
+	}
+}
+
+func TestGCRetention(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	pub, _ := keys.GetPublicKey(sec)
+	now := timestamp.Now()
+	old := newTestEvent(t, sec, kind.TextNote, now-7200, nil)
+	recent := newTestEvent(t, sec, kind.TextNote, now, nil)
+	profile := newTestEvent(t, sec, kind.ProfileMetadata, now-7200, nil)
+	for _, ev := range []*event.T{old, recent, profile} {
+		if err := b.SaveEvent(b.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.SetRetention(&Retention{
+		MaxAge: map[kind.T]time.Duration{kind.TextNote: time.Hour},
+	})
+	// there is no size limit, but the maximum age is always enforced
+	_, pruneEvents, _, err := b.GCRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruneEvents) != 1 {
+		t.Fatalf("expected 1 event past its maximum age, got %d",
+			len(pruneEvents))
+	}
+	f := &filter.T{Authors: []string{pub},
+		Kinds: kinds.T{kind.TextNote, kind.ProfileMetadata}}
+	if n := countResults(t, b, f); n != 2 {
+		t.Fatalf("expected 2 events after the GC, got %d", n)
+	}
+	// pinning the author keeps their events
+	b.SetRetention(&Retention{
+		Pinned: func(p string) bool { return strings.EqualFold(p, pub) },
+		MaxAge: map[kind.T]time.Duration{kind.ProfileMetadata: time.Hour},
+	})
+	if _, pruneEvents, _, err = b.GCRun(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pruneEvents) != 0 {
+		t.Fatalf("expected pinned events to be kept, pruned %d",
+			len(pruneEvents))
+	}
+}
@@ -1,6 +1,8 @@
 package badger
 
 import (
+	"errors"
+
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
@@ -15,111 +17,133 @@ import (
 	"github.com/minio/sha256-simd"
 )
 
+// SaveEvent stores an event and its indexes.
+//
+// Events that have been deleted are rejected with eventstore.ErrEventDeleted,
+// and events already stored with eventstore.ErrDupEvent, unless they were
+// pruned to the L2, in which case the event is restored.
+//
+// Replaceable and parameterized replaceable events replace older versions with
+// the same address in the same transaction, and are rejected with
+// eventstore.ErrEventReplaced if a newer version is already stored.
 func (b *Backend) SaveEvent(c context.T, ev *event.T) (err error) {
 	// make sure Close waits for this to complete
 	b.WG.Add(1)
 	defer b.WG.Done()
-	// first, search to see if the event ID already exists.
-	var foundSerial []byte
-	seri := serial.New(nil)
-	err = b.View(func(txn *badger.Txn) (err error) {
-		// query event by id to ensure we don't try to save duplicates
-		prf := index.Id.Key(id.New(ev.ID))
-		it := txn.NewIterator(badger.IteratorOptions{})
-		defer it.Close()
-		it.Seek(prf)
-		if it.ValidForPrefix(prf) {
-			var k []byte
-			// get the serial
-			k = it.Item().Key()
-			// copy serial out
-			keys.Read(k, index.Empty(), id.New(""), seri)
-			// save into foundSerial
-			foundSerial = seri.Val
+	// badger only detects conflicts on keys that were read, so two versions of
+	// the same address that find no previous version must not be stored
+	// concurrently.
+	if ev.Kind.IsReplaceable() || ev.Kind.IsParameterizedReplaceable() {
+		b.replaceMx.Lock()
+		defer b.replaceMx.Unlock()
+	}
+	for i := 0; i < SaveRetries; i++ {
+		if err = b.Update(func(txn *badger.Txn) (err error) {
+			return b.saveEvent(txn, ev)
+		}); !errors.Is(err, badger.ErrConflict) {
+			break
 		}
+		log.D.Ln("conflict saving event, retrying", ev.ID, b.Path)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, eventstore.ErrDupEvent),
+			errors.Is(err, eventstore.ErrEventDeleted),
+			errors.Is(err, eventstore.ErrEventReplaced):
+		default:
+			chk.E(err)
+		}
+	}
+	return
+}
+
+// saveEvent is the transaction that stores an event, see SaveEvent.
+func (b *Backend) saveEvent(txn *badger.Txn, ev *event.T) (err error) {
+	// events that have been deleted must not be stored again
+	var deleted bool
+	if deleted, err = isDeleted(txn, ev); err != nil {
 		return
-	})
-	if chk.E(err) {
-		return
 	}
-	// if the event is found but it has been replaced with the event ID (in case of
-	// L2) we need to restore it, or otherwise return that the event exists.
+	if deleted {
+		return eventstore.ErrEventDeleted
+	}
+	// query event by id to ensure we don't try to save duplicates
+	var foundSerial []byte
+	seri := serial.New(nil)
+	prf := index.Id.Key(id.New(ev.ID))
+	it := txn.NewIterator(badger.IteratorOptions{})
+	it.Seek(prf)
+	if it.ValidForPrefix(prf) {
+		// copy serial out
+		keys.Read(it.Item().Key(), index.Empty(), id.New(""), seri)
+		foundSerial = seri.Val
+	}
+	it.Close()
+	// if the event is found but it has been replaced with the event ID (in case
+	// of L2) we need to restore it, or otherwise return that the event exists.
 	if foundSerial != nil {
-		err = b.Update(func(txn *badger.Txn) (err error) {
-			// retrieve the event record
-			evKey := keys.Write(index.New(index.Event), seri)
-			it := txn.NewIterator(badger.IteratorOptions{})
-			defer it.Close()
-			it.Seek(evKey)
-			if it.ValidForPrefix(evKey) {
-				if it.Item().ValueSize() != sha256.Size {
-					// not a stub, we already have it
-					// log.I.Ln(" duplicate event", ev.ID)
-					return eventstore.ErrDupEvent
-				}
-				// we only need to restore the event binary and write the access counter key
-				// encode to binary
-				var bin []byte
-				if bin, err = nostrbinary.Marshal(ev); chk.D(err) {
-					return
-				}
-				if err = txn.Set(it.Item().Key(), bin); chk.E(err) {
-					return
-				}
-				// bump counter key
-				counterKey := GetCounterKey(seri)
-				val := keys.Write(createdat.New(timestamp.Now()))
-				if err = txn.Set(counterKey, val); chk.E(err) {
-					return
-				}
-				return
-				// } else {
-				// 	log.I.Ln("pruned duplicate event", ev.ID)
-				// return eventstore.ErrDupEvent
-			}
+		evKey := keys.Write(index.New(index.Event), seri)
+		var item *badger.Item
+		if item, err = txn.Get(evKey); errors.Is(err, badger.ErrKeyNotFound) {
+			err = nil
 			return
-		})
-		// if it was a dupe, we are done.
-		if err != nil {
+		} else if chk.E(err) {
 			return
 		}
-		// if this is a restore, we are done, no need to cache the JSON, as it is not a
-		// new event.
-		return
-	}
-	log.I.Ln("saving event to badger", ev.ToObject().String())
-	// otherwise, save new event record.
-	if err = b.Update(func(txn *badger.Txn) (err error) {
-		var idx []byte
-		var ser *serial.T
-		idx, ser = b.SerialKey()
-		// encode to binary
+		if item.ValueSize() != sha256.Size {
+			// not a stub, we already have it
+			return eventstore.ErrDupEvent
+		}
+		// we only need to restore the event binary and write the access
+		// counter key
 		var bin []byte
-		if bin, err = nostrbinary.Marshal(ev); chk.E(err) {
+		if bin, err = nostrbinary.Marshal(ev); chk.D(err) {
 			return
 		}
-		// raw event store
-		if err = txn.Set(idx, bin); chk.E(err) {
+		if err = txn.Set(evKey, bin); chk.E(err) {
 			return
 		}
-		// 	add the indexes
-		var keyz [][]byte
-		keyz = GetIndexKeysForEvent(ev, ser)
-		for _, k := range keyz {
-			if err = txn.Set(k, nil); chk.E(err) {
-				return
-			}
-		}
-		// initialise access counter key
-		counterKey := GetCounterKey(ser)
+		// bump counter key
+		counterKey := GetCounterKey(seri)
 		val := keys.Write(createdat.New(timestamp.Now()))
 		if err = txn.Set(counterKey, val); chk.E(err) {
 			return
 		}
-		// log.T.F("event saved %s %s", ev.ID, b.Path)
 		return
-	}); chk.E(err) {
+	}
+	if ev.Kind.IsReplaceable() || ev.Kind.IsParameterizedReplaceable() {
+		if err = b.replaceVersions(txn, ev); err != nil {
+			return
+		}
+	}
+	log.I.Ln("saving event to badger", ev.ToObject().String())
+	// otherwise, save new event record.
+	var idx []byte
+	var ser *serial.T
+	idx, ser = b.SerialKey()
+	// encode to binary
+	var bin []byte
+	if bin, err = nostrbinary.Marshal(ev); chk.E(err) {
+		return
+	}
+	// raw event store
+	if err = txn.Set(idx, bin); chk.E(err) {
+		return
+	}
+	// 	add the indexes
+	var keyz [][]byte
+	keyz = GetIndexKeysForEvent(ev, ser)
+	for _, k := range keyz {
+		if err = txn.Set(k, nil); chk.E(err) {
+			return
+		}
+	}
+	// initialise access counter key
+	counterKey := GetCounterKey(ser)
+	val := keys.Write(createdat.New(timestamp.Now()))
+	if err = txn.Set(counterKey, val); chk.E(err) {
 		return
 	}
+	// log.T.F("event saved %s %s", ev.ID, b.Path)
 	return
 }
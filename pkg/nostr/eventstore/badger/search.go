@@ -0,0 +1,217 @@
+package badger
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/arb"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/createdat"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/serial"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/nostrbinary"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/search"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
+)
+
+const (
+	// MaxSearchScan is the maximum number of index keys read for each word of
+	// a search, newest first.
+	MaxSearchScan = 100000
+	// MaxSearchCandidates is the maximum number of the newest events that
+	// contain all the words of a search and match the rest of its filter that
+	// are ranked.
+	MaxSearchCandidates = 1000
+)
+
+// GetWordHash returns the element of a Word index key for a search word.
+func GetWordHash(word string) *arb.T {
+	hash := sha256.Sum256([]byte(word))
+	return arb.New(hash[:index.WordHashLen])
+}
+
+// searchResult is an event found by a search and its relevance.
+type searchResult struct {
+	ev    *event.T
+	ser   *serial.T
+	score int
+}
+
+// searchCandidates returns the serials of the events that contain all of the
+// words, in the time range given, newest first.
+func (b *Backend) searchCandidates(c context.T, words []string, since,
+	until uint64) (sers []*serial.T, err error) {
+
+	type hit struct {
+		words     int
+		createdAt uint64
+	}
+	hits := make(map[uint64]*hit)
+	err = b.View(func(txn *badger.Txn) (err error) {
+		for _, word := range words {
+			prf := index.Word.Key(GetWordHash(word))
+			start := binary.BigEndian.AppendUint64(prf, until)
+			it := txn.NewIterator(badger.IteratorOptions{Reverse: true})
+			var scanned int
+			for it.Seek(start); it.ValidForPrefix(prf); it.Next() {
+				k := it.Item().Key()
+				if len(k) != index.KeySizes[index.Word] {
+					continue
+				}
+				createdAt := createdat.FromKey(k).Val.U64()
+				if createdAt < since {
+					break
+				}
+				ser := serial.FromKey(k).Uint64()
+				if h, ok := hits[ser]; ok {
+					h.words++
+				} else {
+					hits[ser] = &hit{words: 1, createdAt: createdAt}
+				}
+				if scanned++; scanned >= MaxSearchScan {
+					break
+				}
+			}
+			it.Close()
+			select {
+			case <-c.Done():
+				return context.Canceled
+			default:
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	type candidate struct {
+		ser       uint64
+		createdAt uint64
+	}
+	var candidates []candidate
+	for ser, h := range hits {
+		if h.words == len(words) {
+			candidates = append(candidates, candidate{ser, h.createdAt})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].createdAt > candidates[j].createdAt
+	})
+	for _, cand := range candidates {
+		sers = append(sers, serial.New(serial.Make(cand.ser)))
+	}
+	return
+}
+
+// searchEvents finds the events that match a filter with a NIP-50 search,
+// ranked by relevance and then by recency, up to limit results if limit is
+// greater than zero.
+//
+// The other fields of the filter are applied to the events that contain all of
+// the search words, newest first, until MaxSearchCandidates of them match, so
+// older events that match are not crowded out by newer ones that don't. Events
+// that have been pruned to the L2 cannot be searched.
+func (b *Backend) searchEvents(c context.T, f *filter.T,
+	limit int) (results []searchResult, err error) {
+
+	q := search.Parse(f.Search)
+	if len(q.Terms) == 0 {
+		return
+	}
+	var since, until uint64 = 0, math.MaxUint64
+	if f.Since != nil {
+		since = uint64(*f.Since)
+	}
+	if f.Until != nil {
+		until = uint64(*f.Until) + 1
+	}
+	var sers []*serial.T
+	if sers, err = b.searchCandidates(c, q.Terms, since, until); err != nil {
+		return
+	}
+	ext := f.Clone()
+	ext.Search = ""
+	err = b.View(func(txn *badger.Txn) (err error) {
+		var matched int
+		for _, ser := range sers {
+			if matched >= MaxSearchCandidates {
+				break
+			}
+			var item *badger.Item
+			if item, err = txn.Get(index.Event.Key(ser)); errors.Is(err,
+				badger.ErrKeyNotFound) {
+				err = nil
+				continue
+			} else if chk.E(err) {
+				return
+			}
+			if item.ValueSize() == sha256.Size {
+				continue
+			}
+			var v []byte
+			if v, err = item.ValueCopy(nil); chk.E(err) {
+				return
+			}
+			ev, uErr := nostrbinary.Unmarshal(v)
+			if chk.E(uErr) || ev.IsExpired() || !ext.Matches(ev) {
+				continue
+			}
+			var deleted bool
+			if deleted, err = isDeleted(txn, ev); chk.E(err) || deleted {
+				err = nil
+				continue
+			}
+			matched++
+			if score := q.Score(ev); score > 0 {
+				results = append(results, searchResult{ev, ser, score})
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].ev.CreatedAt > results[j].ev.CreatedAt
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return
+}
+
+// QuerySearch sends the results of a filter with a NIP-50 search to ch in
+// order of relevance, and closes the channels when done.
+func (b *Backend) QuerySearch(c context.T, ch event.C,
+	accessChan chan *AccessEvent, f *filter.T, limit int) {
+
+	defer func() {
+		close(ch)
+		close(accessChan)
+	}()
+	results, err := b.searchEvents(c, f, limit)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			chk.E(err)
+		}
+		return
+	}
+	for _, res := range results {
+		select {
+		case ch <- res.ev:
+		case <-c.Done():
+			return
+		case <-b.Ctx.Done():
+			return
+		}
+		accessChan <- MakeAccessEvent(res.ev.ID, res.ser)
+	}
+}
@@ -0,0 +1,117 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestSearch(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	now := timestamp.Now()
+	newNote := func(content string, ts timestamp.T, tt tags.T) *event.T {
+		ev := &event.T{Kind: kind.TextNote, CreatedAt: ts, Tags: tt,
+			Content: content}
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.SaveEvent(b.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	older := newNote("running a nostr relay", now-20, nil)
+	tagged := newNote("a relay", now-30, tags.T{{"t", "nostr"}})
+	newNote("something else entirely", now-10, nil)
+	newer := newNote("another nostr relay", now, nil)
+	ch, err := b.QueryEvents(b.Ctx, &filter.T{Search: "Nostr relay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []*event.T
+	for ev := range ch {
+		results = append(results, ev)
+	}
+	// the tag has a higher weight, then newer events come first
+	expected := []*event.T{tagged, newer, older}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i := range expected {
+		if results[i].ID != expected[i].ID {
+			t.Fatalf("result %d expected '%s', got '%s'", i,
+				expected[i].Content, results[i].Content)
+		}
+	}
+	limit := 1
+	until := (now - 5).Ptr()
+	for _, test := range []struct {
+		f *filter.T
+		n int
+	}{
+		{&filter.T{Search: "relay", Limit: &limit}, 1},
+		{&filter.T{Search: "relay", Until: until}, 2},
+		{&filter.T{Search: "relay", Kinds: kinds.T{kind.Article}}, 0},
+		{&filter.T{Search: "relay missing"}, 0},
+		{&filter.T{Search: "the"}, 0},
+	} {
+		if n := countResults(t, b, test.f); n != test.n {
+			t.Errorf("search '%s' expected %d results, got %d", test.f.Search,
+				test.n, n)
+		}
+	}
+	count, err := b.CountEvents(b.Ctx, &filter.T{Search: "nostr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected count of 3, got %d", count)
+	}
+}
+
+func TestSearchOlderMatches(t *testing.T) {
+	b := newTestBackend(t)
+	author, other := keys.GeneratePrivateKey(), keys.GeneratePrivateKey()
+	authorPub, _ := keys.GetPublicKey(author)
+	now := timestamp.Now()
+	older := &event.T{Kind: kind.TextNote, CreatedAt: now - 1,
+		Content: "an older relay note"}
+	if err := older.Sign(author); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, older); err != nil {
+		t.Fatal(err)
+	}
+	// more newer events with the word than are ranked, but by someone else
+	for i := 0; i <= MaxSearchCandidates; i++ {
+		ev := &event.T{Kind: kind.TextNote, CreatedAt: now + timestamp.T(i),
+			Content: "a newer relay note"}
+		if err := ev.Sign(other); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.SaveEvent(b.Ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, err := b.QueryEvents(b.Ctx, &filter.T{Search: "relay",
+		Authors: tag.T{authorPub}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []*event.T
+	for ev := range ch {
+		results = append(results, ev)
+	}
+	if len(results) != 1 || results[0].ID != older.ID {
+		t.Fatalf("expected the older note by the author, got %d results",
+			len(results))
+	}
+}
@@ -0,0 +1,190 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/ec/schnorr"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/arb"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore/badger/keys/index"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/minio/sha256-simd"
+)
+
+// GetTombstoneKey returns the tombstone key for an event ID.
+func GetTombstoneKey(ev *event.T) (key []byte) {
+	return GetIDTombstoneKey(ev.ID)
+}
+
+// GetIDTombstoneKey returns the tombstone key for an event ID.
+func GetIDTombstoneKey(evID eventid.T) (key []byte) {
+	return index.Tombstone.Key(arb.New(evID.Bytes()))
+}
+
+// GetAddressTombstoneKey returns the tombstone key for the address of a
+// replaceable event, as found in an `a` tag.
+func GetAddressTombstoneKey(address string) (key []byte) {
+	hash := sha256.Sum256([]byte(address))
+	return index.Tombstone.Key(arb.New(hash[:]))
+}
+
+// setTombstone writes a tombstone with the given timestamp, keeping the later
+// timestamp if there is already a tombstone with the same key.
+//
+// A tombstone bound to pubkeys is always replaced, as the tombstone written
+// here applies to the event whoever its author is.
+func setTombstone(txn *badger.Txn, key []byte, ts timestamp.T) (err error) {
+	var prev timestamp.T
+	var pubs []byte
+	if prev, pubs, err = getTombstone(txn, key); err != nil {
+		return
+	}
+	if prev > ts && len(pubs) == 0 {
+		return
+	}
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, uint64(ts))
+	return txn.Set(key, val)
+}
+
+// getTombstone returns the timestamp of a tombstone, or zero if there is none,
+// and the pubkeys the tombstone is bound to, if it is only for events by some
+// authors.
+func getTombstone(txn *badger.Txn, key []byte) (ts timestamp.T, pubs []byte,
+	err error) {
+
+	var item *badger.Item
+	if item, err = txn.Get(key); errors.Is(err, badger.ErrKeyNotFound) {
+		err = nil
+		return
+	} else if chk.E(err) {
+		return
+	}
+	err = item.Value(func(val []byte) (err error) {
+		if len(val) >= 8 {
+			ts = timestamp.T(binary.BigEndian.Uint64(val))
+			pubs = append(pubs, val[8:]...)
+		}
+		return
+	})
+	return
+}
+
+// isDeleted returns true if the event ID has a tombstone that is not bound to
+// other authors, or if the event is a replaceable event whose address was
+// deleted at or after it was created.
+func isDeleted(txn *badger.Txn, ev *event.T) (deleted bool, err error) {
+	var ts timestamp.T
+	var pubs []byte
+	if ts, pubs, err = getTombstone(txn, GetTombstoneKey(ev)); err != nil {
+		return
+	}
+	if ts > 0 {
+		if len(pubs) == 0 {
+			deleted = true
+			return
+		}
+		// an invalid pubkey does not match any
+		pub, _ := hex.Dec(ev.PubKey)
+		if deleted = boundTo(pubs, pub); deleted {
+			return
+		}
+	}
+	address := eventstore.GetAddress(ev)
+	if address == "" {
+		return
+	}
+	if ts, _, err = getTombstone(txn,
+		GetAddressTombstoneKey(address)); err != nil {
+		return
+	}
+	deleted = ts > 0 && ev.CreatedAt <= ts
+	return
+}
+
+// boundTo returns true if a pubkey is one of the pubkeys a tombstone is bound
+// to.
+func boundTo(pubs, pub []byte) bool {
+	if len(pub) != schnorr.PubKeyBytesLen {
+		return false
+	}
+	for i := 0; i+len(pub) <= len(pubs); i += len(pub) {
+		if bytes.Equal(pubs[i:i+len(pub)], pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeleted returns true if the event has been deleted by a NIP-09 deletion
+// event and must not be stored or returned in query results.
+func (b *Backend) IsDeleted(ev *event.T) (deleted bool, err error) {
+	err = b.View(func(txn *badger.Txn) (err error) {
+		deleted, err = isDeleted(txn, ev)
+		return
+	})
+	return
+}
+
+// TombstoneAddress marks the address of a replaceable event as deleted up to
+// the given timestamp, so versions created at or before it will not be stored
+// again. The versions already stored must be removed with DeleteEvent.
+func (b *Backend) TombstoneAddress(c context.T, address string,
+	until timestamp.T) (err error) {
+
+	return b.Update(func(txn *badger.Txn) (err error) {
+		return setTombstone(txn, GetAddressTombstoneKey(address), until)
+	})
+}
+
+// TombstoneEventID marks an event ID that is not in the event store as deleted
+// by the given author, so the event will not be stored if it is published or
+// revived from an L2 later. As the author of the event is not known, the
+// tombstone only applies to an event with the same pubkey as the deletion.
+//
+// If the event ID already has a tombstone for any author, it is kept.
+func (b *Backend) TombstoneEventID(c context.T, evID eventid.T,
+	pubkey string) (err error) {
+
+	var pub []byte
+	if pub, err = hex.Dec(pubkey); chk.E(err) {
+		return
+	}
+	if len(pub) != schnorr.PubKeyBytesLen {
+		return log.E.Err("invalid pubkey length %d for tombstone of %s",
+			len(pub), evID)
+	}
+	key := GetIDTombstoneKey(evID)
+	return b.Update(func(txn *badger.Txn) (err error) {
+		var ts timestamp.T
+		var pubs []byte
+		if ts, pubs, err = getTombstone(txn, key); err != nil {
+			return
+		}
+		if ts > 0 && len(pubs) == 0 {
+			return
+		}
+		if boundTo(pubs, pub) {
+			return
+		}
+		val := make([]byte, 8, 8+len(pubs)+len(pub))
+		binary.BigEndian.PutUint64(val, uint64(timestamp.Now()))
+		val = append(append(val, pubs...), pub...)
+		return txn.Set(key, val)
+	})
+}
+
+// RemoveTombstone removes the tombstone of an event ID, so the event can be
+// stored again.
+func (b *Backend) RemoveTombstone(c context.T, evID eventid.T) (err error) {
+	return b.Update(func(txn *badger.Txn) (err error) {
+		return txn.Delete(GetIDTombstoneKey(evID))
+	})
+}
@@ -0,0 +1,155 @@
+package badger
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventstore"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/filter"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+// newTestBackend opens a badger event store in a temporary directory that is
+// closed when the test finishes.
+func newTestBackend(t *testing.T) (b *Backend) {
+	c, cancel := context.Cancel(context.Bg())
+	b = GetBackend(c, &sync.WaitGroup{}, t.TempDir(), false, 0)
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the garbage collector run at startup so it cannot race with the
+	// test
+	for b.GCRuns.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.gcMx.Lock()
+	b.gcMx.Unlock()
+	t.Cleanup(func() {
+		cancel()
+		b.WG.Wait()
+		b.Close()
+	})
+	return
+}
+
+func newTestEvent(t *testing.T, sec string, k kind.T, ts timestamp.T,
+	tt tags.T) (ev *event.T) {
+
+	ev = &event.T{Kind: k, CreatedAt: ts, Tags: tt, Content: "test"}
+	if err := ev.Sign(sec); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func countResults(t *testing.T, b *Backend, f *filter.T) (n int) {
+	ch, err := b.QueryEvents(b.Ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ev := range ch {
+		if ev != nil {
+			n++
+		}
+	}
+	return
+}
+
+func TestTombstone(t *testing.T) {
+	b := newTestBackend(t)
+	sec := keys.GeneratePrivateKey()
+	now := timestamp.Now()
+	ev := newTestEvent(t, sec, kind.TextNote, now, nil)
+	if err := b.SaveEvent(b.Ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if n := countResults(t, b, &filter.T{IDs: tag.T{ev.ID.String()}}); n != 1 {
+		t.Fatalf("expected 1 event before deletion, got %d", n)
+	}
+	if err := b.DeleteEvent(b.Ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if n := countResults(t, b, &filter.T{IDs: tag.T{ev.ID.String()}}); n != 0 {
+		t.Fatalf("expected no events after deletion, got %d", n)
+	}
+	if err := b.SaveEvent(b.Ctx, ev); !errors.Is(err,
+		eventstore.ErrEventDeleted) {
+		t.Fatalf("expected deleted event to be rejected, got %v", err)
+	}
+	// versions of an address created up to the deletion are rejected, later
+	// ones are accepted.
+	dTag := tags.T{{"d", "test"}}
+	older := newTestEvent(t, sec, kind.T(30023), now-10, dTag)
+	newer := newTestEvent(t, sec, kind.T(30023), now+10, dTag)
+	if err := b.TombstoneAddress(b.Ctx, eventstore.GetAddress(older),
+		now); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, older); !errors.Is(err,
+		eventstore.ErrEventDeleted) {
+		t.Fatalf("expected deleted address to be rejected, got %v", err)
+	}
+	if err := b.SaveEvent(b.Ctx, newer); err != nil {
+		t.Fatalf("expected newer version to be saved, got %v", err)
+	}
+	if n := countResults(t, b, &filter.T{Kinds: kinds.T{30023}}); n != 1 {
+		t.Fatalf("expected 1 version of address, got %d", n)
+	}
+}
+
+func TestTombstoneEventID(t *testing.T) {
+	b := newTestBackend(t)
+	author, other := keys.GeneratePrivateKey(), keys.GeneratePrivateKey()
+	authorPub, _ := keys.GetPublicKey(author)
+	otherPub, _ := keys.GetPublicKey(other)
+	now := timestamp.Now()
+	// an event deleted by its author before it was stored is rejected
+	ev := newTestEvent(t, author, kind.TextNote, now, nil)
+	if err := b.TombstoneEventID(b.Ctx, ev.ID, authorPub); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, ev); !errors.Is(err,
+		eventstore.ErrEventDeleted) {
+		t.Fatalf("expected event deleted by its author to be rejected, "+
+			"got %v", err)
+	}
+	// one deleted by someone else is not
+	ev = newTestEvent(t, author, kind.TextNote, now+1, nil)
+	if err := b.TombstoneEventID(b.Ctx, ev.ID, otherPub); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, ev); err != nil {
+		t.Fatalf("expected event deleted by another user to be saved, got %v",
+			err)
+	}
+	if n := countResults(t, b, &filter.T{IDs: tag.T{ev.ID.String()}}); n != 1 {
+		t.Fatalf("expected 1 event, got %d", n)
+	}
+	// and deleting it once it is stored applies to it whoever the author is
+	if err := b.DeleteEvent(b.Ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TombstoneEventID(b.Ctx, ev.ID, otherPub); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, ev); !errors.Is(err,
+		eventstore.ErrEventDeleted) {
+		t.Fatalf("expected deleted event to be rejected, got %v", err)
+	}
+	// until the tombstone is removed
+	if err := b.RemoveTombstone(b.Ctx, ev.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(b.Ctx, ev); err != nil {
+		t.Fatalf("expected event to be saved without tombstone, got %v", err)
+	}
+}
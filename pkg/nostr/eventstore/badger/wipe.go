@@ -16,6 +16,9 @@ func (b *Backend) Wipe() (err error) {
 		{index.Tag32.B()},
 		{index.TagAddr.B()},
 		{index.Counter.B()},
+		{index.Tombstone.B()},
+		{index.Expiration.B()},
+		{index.Word.B()},
 	}...); chk.E(err) {
 		return
 	}
@@ -5,4 +5,6 @@ import "errors"
 var (
 	ErrDupEvent       = errors.New("duplicate: event already exists")
 	ErrEventNotExists = errors.New("unknown: event not known by any source of this relay")
+	ErrEventDeleted   = errors.New("blocked: event has been deleted")
+	ErrEventReplaced  = errors.New("invalid: a newer version of this event is already stored")
 )
@@ -9,7 +9,9 @@ import (
 
 var log, chk = slog.New(os.Stderr)
 
-func isOlder(prev, next *event.T) bool {
+// IsOlder returns true if prev is replaced by next, that is, it was created
+// earlier, or at the same time and has the higher ID, as per NIP-01.
+func IsOlder(prev, next *event.T) bool {
 	return prev.CreatedAt < next.CreatedAt ||
 		(prev.CreatedAt == next.CreatedAt && prev.ID > next.ID)
 }
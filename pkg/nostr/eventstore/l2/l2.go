@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Hubmakerlabs/replicatr/pkg/atomic"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/context"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
@@ -42,6 +43,19 @@ type Backend struct {
 	//
 	// caller is responsible for populating this
 	EventSignal event.C
+	// lastPoll is the end of the time range of the last successful poll of the
+	// L2.
+	lastPoll atomic.Time
+}
+
+// PollLag returns the time since the last successful poll of the L2, or zero
+// if polling is disabled or has not yet succeeded.
+func (b *Backend) PollLag() time.Duration {
+	last := b.lastPoll.Load()
+	if last.IsZero() {
+		return 0
+	}
+	return time.Since(last)
 }
 
 func (b *Backend) Init() (err error) {
@@ -78,6 +92,7 @@ func (b *Backend) Init() (err error) {
 						Until: until.Ptr()}); chk.E(err) {
 					continue out
 				}
+				b.lastPoll.Store(until.Time())
 				last = until - b.PollOverlap*timestamp.T(b.PollFrequency/time.Second)
 				go func() {
 					for {
@@ -206,6 +221,12 @@ func (b *Backend) QueryEvents(c context.T, f *filter.T) (ch event.C,
 					continue
 				}
 				evMap[&ev2.ID] = struct{}{}
+				// events deleted in the L1 may still be in the L2, and the L2
+				// may not sweep expired events
+				if deleted, _ := b.L1.IsDeleted(ev2); deleted ||
+					ev2.IsExpired() {
+					continue
+				}
 				// first to find should send
 				ch <- ev2
 			}
@@ -226,6 +247,13 @@ func (b *Backend) Revive(ev1 *event.T, c context.T, ch, saveChan event.C) {
 			// if context is closed, break out
 			return
 		case ev3 := <-ch3:
+			// do not revive events that have been deleted or expired
+			if ev3 != nil {
+				if deleted, _ := b.L1.IsDeleted(ev3); deleted ||
+					ev3.IsExpired() {
+					return
+				}
+			}
 			ch <- ev3
 			// need to queue up the event to restore the event and counter records
 			saveChan <- ev3
@@ -264,9 +292,17 @@ func (b *Backend) DeleteEvent(c context.T, ev *event.T) (err error) {
 // any errors from each store. The only error defined here is
 // eventstore.ErrDupEvent if the store already has the event.
 //
+// Events that the L1 rejects because they have been deleted or replaced by a
+// newer version are not sent to the L2.
+//
 // Any errors from this method are not fatal, mostly, mostly anything else, like
 // auth- or filter- related denials are from a separate subsystem.
 func (b *Backend) SaveEvent(c context.T, ev *event.T) (err error) {
-	err = errors.Join(b.L1.SaveEvent(c, ev), b.L2.SaveEvent(c, ev))
+	if err = b.L1.SaveEvent(c, ev); errors.Is(err,
+		eventstore.ErrEventDeleted) || errors.Is(err,
+		eventstore.ErrEventReplaced) {
+		return
+	}
+	err = errors.Join(err, b.L2.SaveEvent(c, ev))
 	return
 }
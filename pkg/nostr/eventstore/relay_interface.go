@@ -25,47 +25,15 @@ type RelayWrapper struct {
 
 var _ RelayInterface = (*RelayWrapper)(nil)
 
+// Publish stores an event. Replacing older versions of replaceable events is
+// done by the Store, in the same transaction the event is saved in.
 func (w RelayWrapper) Publish(c context.T, evt *event.T) (err error) {
-	// var ch event.C
-	// defer close(ch)
 	if evt.Kind.IsEphemeral() {
 		// do not store ephemeral events
 		return nil
-		// todo: we are no longer deleting old replaceable events because this is racy
-		// } else if evt.Kind.IsReplaceable() {
-		// replaceable event, delete before storing
-		// ch, err = w.Store.QueryEvents(c, &filter.T{
-		// 	Authors: []string{evt.PubKey},
-		// 	Kinds:   kinds.T{evt.Kind},
-		// })
-		// if err != nil {
-		// 	return fmt.Errorf("failed to query before replacing: %w", err)
-		// }
-		// if previous := <-ch; previous != nil && isOlder(previous, evt) {
-		// 	if err = w.Store.DeleteEvent(c, previous); err != nil {
-		// 		return fmt.Errorf("failed to delete event for replacing: %w", err)
-		// 	}
-		// }
-		// } else if evt.Kind.IsParameterizedReplaceable() {
-		// parameterized replaceable event, delete before storing
-		// d := evt.Tags.GetFirst([]string{"d", ""})
-		// if d != nil {
-		// ch, err = w.Store.QueryEvents(c, &filter.T{
-		// 	Authors: []string{evt.PubKey},
-		// 	Kinds:   kinds.T{evt.Kind},
-		// 	Tags:    filter.TagMap{"d": []string{d.Value()}},
-		// })
-		// if err != nil {
-		// 	return fmt.Errorf("failed to query before parameterized replacing: %w", err)
-		// }
-		// if previous := <-ch; previous != nil && isOlder(previous, evt) {
-		// 	if err = w.Store.DeleteEvent(c, previous); chk.D(err) {
-		// 		return fmt.Errorf("failed to delete event for parameterized replacing: %w", err)
-		// 	}
-		// }
-		// }
 	}
-	if err = w.SaveEvent(c, evt); err != nil && !errors.Is(err, ErrDupEvent) {
+	if err = w.SaveEvent(c, evt); err != nil && !errors.Is(err, ErrDupEvent) &&
+		!errors.Is(err, ErrEventReplaced) {
 		return fmt.Errorf("failed to save: %w", err)
 	}
 	return nil
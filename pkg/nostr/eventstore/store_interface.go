@@ -32,6 +32,13 @@ type Store interface {
 	SaveEvent(c context.T, ev *event.T) (err error)
 }
 
+// ApproximateCounter is a Store that can estimate the count of very large
+// result sets, which are reported as approximate in NIP-45 COUNT responses.
+type ApproximateCounter interface {
+	CountEventsApproximate(c context.T, f *filter.T) (count int,
+		approximate bool, err error)
+}
+
 // Cache is a sketch of an expanded interface that might be used for a
 // size-constrained event store.
 type Cache interface {
@@ -1,9 +1,11 @@
 package eventstore
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/hex"
 	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
 )
@@ -20,6 +22,23 @@ func GetAddrTagElements(tagValue string) (k uint16, pkb []byte, d string) {
 	return 0, nil, ""
 }
 
+// GetAddress returns the address of a replaceable or parameterized replaceable
+// event in the form used in `a` tags, kind:pubkey:d-tag, or an empty string for
+// other kinds of event.
+func GetAddress(ev *event.T) (address string) {
+	var d string
+	switch {
+	case ev.Kind.IsParameterizedReplaceable():
+		if t := ev.Tags.GetFirst([]string{"d", ""}); t != nil {
+			d = (*t)[1]
+		}
+	case ev.Kind.IsReplaceable():
+	default:
+		return
+	}
+	return fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, d)
+}
+
 func TagSorter(a, b tag.T) int {
 	if len(a) < 2 {
 		if len(b) < 2 {
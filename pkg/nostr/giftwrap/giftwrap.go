@@ -0,0 +1,147 @@
+// Package giftwrap seals and gift wraps events as described in NIP-59, which
+// is how NIP-17 private direct messages are sent.
+//
+// The message, the rumor, is an unsigned event from the sender. It is
+// encrypted to the recipient in a seal, signed by the sender, which is itself
+// encrypted to the recipient in a gift wrap signed by a random key, so only the
+// recipient can learn who sent it. The timestamps of the seal and gift wrap are
+// randomised so they do not reveal when the message was sent.
+package giftwrap
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/crypt"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/wire/object"
+	"github.com/Hubmakerlabs/replicatr/pkg/slog"
+	"lukechampine.com/frand"
+)
+
+var log, chk = slog.New(os.Stderr)
+
+// MaxTimestampTweak is the most seconds the timestamps of seals and gift
+// wraps are set in the past.
+const MaxTimestampTweak = 2 * 24 * 60 * 60
+
+// randomNow returns the current time moved a random amount into the past.
+func randomNow() timestamp.T {
+	return timestamp.Now() - timestamp.T(frand.Intn(MaxTimestampTweak))
+}
+
+// Rumor returns the JSON of an unsigned event, with its ID set and without a
+// signature.
+func Rumor(ev *event.T) []byte {
+	ev.ID = ev.GetID()
+	return object.T{
+		{"id", ev.ID},
+		{"pubkey", ev.PubKey},
+		{"created_at", ev.CreatedAt},
+		{"kind", ev.Kind},
+		{"tags", ev.Tags},
+		{"content", ev.Content},
+	}.Bytes()
+}
+
+// Wrap seals a rumor from the owner of senderSec and gift wraps it to the
+// owner of recipientPub. The PubKey of the rumor is set to the sender.
+func Wrap(rumor *event.T, senderSec, recipientPub string) (wrap *event.T,
+	err error) {
+
+	if rumor.PubKey, err = keys.GetPublicKey(senderSec); chk.E(err) {
+		return
+	}
+	var key []byte
+	if key, err = crypt.ConversationKey(senderSec, recipientPub); chk.E(err) {
+		return
+	}
+	seal := &event.T{CreatedAt: randomNow(), Kind: kind.Seal, Tags: tags.T{}}
+	if seal.Content, err = crypt.EncryptNip44(string(Rumor(rumor)),
+		key); chk.E(err) {
+		return
+	}
+	if err = seal.Sign(senderSec); chk.E(err) {
+		return
+	}
+	// the gift wrap is signed by a key that is used only once
+	wrapSec := keys.GeneratePrivateKey()
+	if key, err = crypt.ConversationKey(wrapSec, recipientPub); chk.E(err) {
+		return
+	}
+	wrap = &event.T{
+		CreatedAt: randomNow(),
+		Kind:      kind.GiftWrap,
+		Tags:      tags.T{{"p", recipientPub}},
+	}
+	if wrap.Content, err = crypt.EncryptNip44(string(seal.Serialize()),
+		key); chk.E(err) {
+		return
+	}
+	if err = wrap.Sign(wrapSec); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Unwrap decrypts a gift wrap to the owner of recipientSec and returns the
+// rumor inside it, after checking the signatures of the gift wrap and the seal,
+// and that the rumor is from the author of the seal.
+func Unwrap(wrap *event.T, recipientSec string) (rumor *event.T, err error) {
+	if wrap.Kind != kind.GiftWrap {
+		return nil, log.D.Err("event of kind %d is not a gift wrap",
+			wrap.Kind)
+	}
+	var seal *event.T
+	if seal, err = open(wrap, recipientSec); err != nil {
+		return
+	}
+	if seal.Kind != kind.Seal {
+		return nil, log.D.Err("gift wrap contains kind %d, not a seal",
+			seal.Kind)
+	}
+	if rumor, err = open(seal, recipientSec); err != nil {
+		return
+	}
+	if rumor.PubKey != seal.PubKey {
+		return nil, log.D.Err("rumor pubkey %s is not the author of the "+
+			"seal %s", rumor.PubKey, seal.PubKey)
+	}
+	// rumors should have their id, but it can be computed if it is missing
+	if id := rumor.GetID(); rumor.ID == "" {
+		rumor.ID = id
+	} else if rumor.ID != id {
+		return nil, log.D.Err("rumor id %s should be %s", rumor.ID, id)
+	}
+	return
+}
+
+// open checks the signature of an event and decrypts the event in its content.
+func open(ev *event.T, recipientSec string) (inner *event.T, err error) {
+	var valid bool
+	if valid, err = ev.CheckSignature(); err != nil {
+		return
+	}
+	if !valid {
+		return nil, log.D.Err("invalid signature on kind %d event %s",
+			ev.Kind, ev.ID)
+	}
+	var key []byte
+	if key, err = crypt.ConversationKey(recipientSec, ev.PubKey); err != nil {
+		return
+	}
+	var content string
+	if content, err = crypt.DecryptNip44(ev.Content, key); err != nil {
+		return
+	}
+	inner = &event.T{}
+	if err = json.Unmarshal([]byte(content), inner); err != nil {
+		return nil, log.D.Err("invalid event in kind %d content: %s",
+			ev.Kind, err)
+	}
+	return
+}
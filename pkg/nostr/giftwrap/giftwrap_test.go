@@ -0,0 +1,75 @@
+package giftwrap
+
+import (
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/crypt"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func TestWrap(t *testing.T) {
+	senderSec, recipientSec := keys.GeneratePrivateKey(),
+		keys.GeneratePrivateKey()
+	senderPub, _ := keys.GetPublicKey(senderSec)
+	recipientPub, _ := keys.GetPublicKey(recipientSec)
+	rumor := &event.T{CreatedAt: timestamp.Now(),
+		Kind: kind.PrivateDirectMessage, Tags: tags.T{{"p", recipientPub}},
+		Content: "hello"}
+	wrap, err := Wrap(rumor, senderSec, recipientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap.Kind != kind.GiftWrap || wrap.PubKey == senderPub ||
+		!wrap.Tags.ContainsAny("p", recipientPub) ||
+		wrap.CreatedAt > timestamp.Now() {
+		t.Fatalf("invalid gift wrap %s", wrap.Serialize())
+	}
+	var got *event.T
+	if got, err = Unwrap(wrap, recipientSec); err != nil {
+		t.Fatal(err)
+	}
+	if got.PubKey != senderPub || got.Content != "hello" ||
+		got.ID != rumor.ID || got.Sig != "" {
+		t.Errorf("unexpected rumor %s", got.Serialize())
+	}
+	// nobody else can open it
+	if _, err = Unwrap(wrap, senderSec); err == nil {
+		t.Errorf("gift wrap opened by the sender")
+	}
+	// nor can it be altered
+	wrap.Content = wrap.Content[:len(wrap.Content)-4] + "AAAA"
+	if _, err = Unwrap(wrap, recipientSec); err == nil {
+		t.Errorf("altered gift wrap was opened")
+	}
+}
+
+func TestUnwrapImpersonation(t *testing.T) {
+	senderSec, recipientSec := keys.GeneratePrivateKey(),
+		keys.GeneratePrivateKey()
+	victim, _ := keys.GetPublicKey(keys.GeneratePrivateKey())
+	recipientPub, _ := keys.GetPublicKey(recipientSec)
+	// a rumor claiming to be from someone other than the author of the seal
+	rumor := &event.T{PubKey: victim, CreatedAt: timestamp.Now(),
+		Kind: kind.PrivateDirectMessage, Content: "it's me"}
+	key, _ := crypt.ConversationKey(senderSec, recipientPub)
+	seal := &event.T{CreatedAt: timestamp.Now(), Kind: kind.Seal}
+	seal.Content, _ = crypt.EncryptNip44(string(Rumor(rumor)), key)
+	if err := seal.Sign(senderSec); err != nil {
+		t.Fatal(err)
+	}
+	wrapSec := keys.GeneratePrivateKey()
+	key, _ = crypt.ConversationKey(wrapSec, recipientPub)
+	wrap := &event.T{CreatedAt: timestamp.Now(), Kind: kind.GiftWrap,
+		Tags: tags.T{{"p", recipientPub}}}
+	wrap.Content, _ = crypt.EncryptNip44(string(seal.Serialize()), key)
+	if err := wrap.Sign(wrapSec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unwrap(wrap, recipientSec); err == nil {
+		t.Errorf("rumor from %s accepted in a seal from another key", victim)
+	}
+}
@@ -46,6 +46,12 @@ const (
 	Reaction T = 7
 	// BadgeAward is an event type
 	BadgeAward T = 8
+	// Seal is a NIP-59 event that encrypts a rumor, an unsigned event, to a
+	// recipient, signed by the author of the rumor.
+	Seal T = 13
+	// PrivateDirectMessage is a NIP-17 chat message, which is only sent as
+	// the rumor of a seal inside a GiftWrap.
+	PrivateDirectMessage T = 14
 	// ReadReceipt is a type of event that marks a list of tagged events (e
 	// tags) as being seen by the client, its distinctive feature is the
 	// "expiration" tag which indicates a time after which the marking expires
@@ -192,6 +198,8 @@ var Map = map[T]string{
 	RecommendRelay:              "RecommendRelay",
 	FollowList:                  "FollowList",
 	EncryptedDirectMessage:      "EncryptedDirectMessage",
+	Seal:                        "Seal",
+	PrivateDirectMessage:        "PrivateDirectMessage",
 	EventDeletion:               "EventDeletion",
 	Repost:                      "Repost",
 	Reaction:                    "Reaction",
@@ -20,3 +20,14 @@ func IsPrivileged(k ...kind.T) (is bool) {
 	}
 	return
 }
+
+// IsGiftWrap returns true if any of the kinds are gift wrapped events, which
+// are only for the recipient tagged in them.
+func IsGiftWrap(k ...kind.T) bool {
+	for i := range k {
+		if k[i] == kind.GiftWrap || k[i] == kind.GiftWrapWithKind4 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,300 @@
+package nostrbinary
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/ec/schnorr"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/eventid"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tag"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+	"github.com/minio/sha256-simd"
+)
+
+// Version is the version of the binary encoding written by Marshal.
+const Version = 1
+
+// Header is the first byte of an encoded event, which identifies the format
+// version. A Gob stream always starts with a message length that is either
+// below 0x80 or a negated byte count from 0xf8 up, so the headers of the binary
+// encoding never begin a Gob encoded event.
+const Header = 0x80 | Version
+
+// The binary encoding of an event is:
+//
+//	header     1 byte
+//	id         32 bytes
+//	pubkey     32 bytes
+//	sig        64 bytes
+//	created_at signed varint
+//	kind       unsigned varint
+//	tags       unsigned varint count of tags, then for each tag an unsigned
+//	           varint count of fields, each of which is encoded as a field
+//	content    unsigned varint length followed by the bytes
+//
+// A tag field is an unsigned varint of its length shifted left by one, with the
+// low bit set if the field is a 64 character lowercase hex string, such as an
+// event ID or pubkey, which is then stored as its 32 bytes. Otherwise the bytes
+// of the string follow.
+const (
+	fixedLen  = 1 + sha256.Size + schnorr.PubKeyBytesLen + schnorr.SignatureSize
+	hexField  = 1
+	hexLen    = 64
+	hexBinLen = hexLen / 2
+)
+
+var ErrTruncated = errors.New("truncated binary event")
+
+// Marshal encodes an event in the binary encoding. The ID, pubkey and
+// signature are only checked to be hex of the correct length, as these are
+// validated when the event is received.
+func Marshal(evt *event.T) (b []byte, err error) {
+	if evt == nil {
+		err = errors.New("nil event")
+		return
+	}
+	b = make([]byte, fixedLen, fixedLen+Size(evt))
+	b[0] = Header
+	if err = decodeHex(b[1:1+sha256.Size], string(evt.ID), "ID"); err != nil {
+		return nil, err
+	}
+	pk := b[1+sha256.Size : 1+sha256.Size+schnorr.PubKeyBytesLen]
+	if err = decodeHex(pk, evt.PubKey, "pubkey"); err != nil {
+		return nil, err
+	}
+	if err = decodeHex(b[fixedLen-schnorr.SignatureSize:], evt.Sig,
+		"signature"); err != nil {
+		return nil, err
+	}
+	b = binary.AppendVarint(b, evt.CreatedAt.I64())
+	b = binary.AppendUvarint(b, uint64(evt.Kind))
+	b = binary.AppendUvarint(b, uint64(len(evt.Tags)))
+	for _, t := range evt.Tags {
+		b = binary.AppendUvarint(b, uint64(len(t)))
+		for _, f := range t {
+			if isHex(f) {
+				b = binary.AppendUvarint(b, hexLen<<1|hexField)
+				b, _ = hex.AppendDecode(b, []byte(f))
+				continue
+			}
+			b = binary.AppendUvarint(b, uint64(len(f))<<1)
+			b = append(b, f...)
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(evt.Content)))
+	b = append(b, evt.Content...)
+	return
+}
+
+// Size returns an upper bound of the length of the variable part of the binary
+// encoding of an event, which is used to allocate the buffer in one go.
+func Size(evt *event.T) (n int) {
+	n = 3*binary.MaxVarintLen64 + len(evt.Content)
+	for _, t := range evt.Tags {
+		n += binary.MaxVarintLen64
+		for _, f := range t {
+			n += binary.MaxVarintLen64 + len(f)
+		}
+	}
+	return
+}
+
+// Unmarshal decodes an event in the binary encoding, or in the legacy Gob
+// encoding if it does not start with a binary encoding header.
+func Unmarshal(data []byte) (evt *event.T, err error) {
+	if len(data) == 0 {
+		return nil, ErrTruncated
+	}
+	if data[0] != Header {
+		if IsBinary(data) {
+			return nil, fmt.Errorf("unknown binary event version %d",
+				data[0]&^0x80)
+		}
+		return UnmarshalGob(data)
+	}
+	if len(data) < fixedLen {
+		return nil, ErrTruncated
+	}
+	evt = &event.T{
+		ID:     eventid.T(hex.EncodeToString(data[1 : 1+sha256.Size])),
+		PubKey: hex.EncodeToString(data[1+sha256.Size : fixedLen-schnorr.SignatureSize]),
+		Sig:    hex.EncodeToString(data[fixedLen-schnorr.SignatureSize : fixedLen]),
+	}
+	r := reader(data[fixedLen:])
+	var ts int64
+	if ts, err = r.varint(); err != nil {
+		return nil, err
+	}
+	evt.CreatedAt = timestamp.T(ts)
+	var k uint64
+	if k, err = r.uvarint(); err != nil {
+		return nil, err
+	}
+	if k > 0xffff {
+		return nil, fmt.Errorf("invalid kind %d", k)
+	}
+	evt.Kind = kind.T(k)
+	var nTags uint64
+	if nTags, err = r.count(); err != nil {
+		return nil, err
+	}
+	evt.Tags = make(tags.T, nTags)
+	for i := range evt.Tags {
+		var nFields uint64
+		if nFields, err = r.count(); err != nil {
+			return nil, err
+		}
+		t := make(tag.T, nFields)
+		for j := range t {
+			if t[j], err = r.field(); err != nil {
+				return nil, err
+			}
+		}
+		evt.Tags[i] = t
+	}
+	var l uint64
+	if l, err = r.count(); err != nil {
+		return nil, err
+	}
+	var content []byte
+	if content, err = r.next(l); err != nil {
+		return nil, err
+	}
+	evt.Content = string(content)
+	if len(r) > 0 {
+		return nil, fmt.Errorf("%d bytes after binary event", len(r))
+	}
+	return
+}
+
+// Peek returns the pubkey, timestamp and kind of an encoded event without
+// decoding the rest of it. The pubkey of a binary encoded event refers to the
+// data.
+func Peek(data []byte) (pub []byte, createdAt timestamp.T, k kind.T,
+	err error) {
+
+	if len(data) == 0 || data[0] != Header {
+		var evt *event.T
+		if evt, err = Unmarshal(data); err != nil {
+			return
+		}
+		if pub, err = hex.DecodeString(evt.PubKey); err != nil {
+			return
+		}
+		return pub, evt.CreatedAt, evt.Kind, nil
+	}
+	if len(data) < fixedLen {
+		err = ErrTruncated
+		return
+	}
+	pub = data[1+sha256.Size : fixedLen-schnorr.SignatureSize]
+	r := reader(data[fixedLen:])
+	var ts int64
+	if ts, err = r.varint(); err != nil {
+		return
+	}
+	var k64 uint64
+	if k64, err = r.uvarint(); err != nil {
+		return
+	}
+	return pub, timestamp.T(ts), kind.T(k64), nil
+}
+
+// IsBinary returns true if the data starts with the header of any version of
+// the binary encoding, and false if it is a legacy Gob encoded event.
+func IsBinary(data []byte) bool {
+	return len(data) > 0 && data[0] >= 0x80 && data[0] < 0xf8
+}
+
+// decodeHex decodes a hex string into dst, which must be exactly filled.
+func decodeHex(dst []byte, s, name string) (err error) {
+	if len(s) != len(dst)*2 {
+		return fmt.Errorf("incorrect event %s len, got %d expected %d",
+			name, len(s), len(dst)*2)
+	}
+	if _, err = hex.Decode(dst, []byte(s)); err != nil {
+		return fmt.Errorf("invalid event %s: %w", name, err)
+	}
+	return
+}
+
+// isHex returns true if a tag field is a 64 character lowercase hex string,
+// which encodes back to exactly the same string.
+func isHex(s string) bool {
+	if len(s) != hexLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// reader reads the variable length fields of the binary encoding.
+type reader []byte
+
+func (r *reader) uvarint() (v uint64, err error) {
+	var n int
+	if v, n = binary.Uvarint(*r); n <= 0 {
+		return 0, ErrTruncated
+	}
+	*r = (*r)[n:]
+	return
+}
+
+func (r *reader) varint() (v int64, err error) {
+	var n int
+	if v, n = binary.Varint(*r); n <= 0 {
+		return 0, ErrTruncated
+	}
+	*r = (*r)[n:]
+	return
+}
+
+// count reads a number of items or bytes, each of which takes at least one
+// byte, so corrupt counts cannot cause huge allocations.
+func (r *reader) count() (v uint64, err error) {
+	if v, err = r.uvarint(); err != nil {
+		return
+	}
+	if v > uint64(len(*r)) {
+		return 0, ErrTruncated
+	}
+	return
+}
+
+func (r *reader) next(n uint64) (b []byte, err error) {
+	if n > uint64(len(*r)) {
+		return nil, ErrTruncated
+	}
+	b, *r = (*r)[:n], (*r)[n:]
+	return
+}
+
+func (r *reader) field() (f string, err error) {
+	var v uint64
+	if v, err = r.uvarint(); err != nil {
+		return
+	}
+	var b []byte
+	if v&hexField != 0 {
+		if v>>1 != hexLen {
+			return "", fmt.Errorf("invalid hex tag field length %d", v>>1)
+		}
+		if b, err = r.next(hexBinLen); err != nil {
+			return
+		}
+		return hex.EncodeToString(b), nil
+	}
+	if b, err = r.next(v >> 1); err != nil {
+		return
+	}
+	return string(b), nil
+}
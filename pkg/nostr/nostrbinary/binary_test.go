@@ -0,0 +1,176 @@
+package nostrbinary
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/keys"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/timestamp"
+)
+
+func testEvents(t testing.TB) (events []*event.T) {
+	sec := keys.GeneratePrivateKey()
+	pub, _ := keys.GetPublicKey(sec)
+	id := strings.Repeat("0f", 32)
+	for _, ev := range []*event.T{
+		{Kind: kind.TextNote, CreatedAt: timestamp.Now(), Content: "hello"},
+		{Kind: kind.TextNote, CreatedAt: 1688555517, Tags: tags.T{},
+			Content: "ブンブンピーブピー"},
+		{Kind: kind.Reaction, CreatedAt: timestamp.Now(), Content: "+",
+			Tags: tags.T{{"e", id, "wss://relay.example.com", "root"},
+				{"p", pub}, {"p", strings.ToUpper(pub)}, {"t"}, {}}},
+		{Kind: kind.T(30023), CreatedAt: -1, Content: "",
+			Tags: tags.T{{"d", "article"}, {"expiration", "1700000000"}}},
+		{Kind: kind.T(65535), CreatedAt: timestamp.Now(),
+			Content: strings.Repeat("long content ", 1000)},
+	} {
+		if err := ev.Sign(sec); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	return
+}
+
+func TestMarshal(t *testing.T) {
+	for i, ev := range testEvents(t) {
+		b, err := Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsBinary(b) {
+			t.Fatalf("%d: encoding has no binary header", i)
+		}
+		var gb []byte
+		if gb, err = MarshalGob(ev); err != nil {
+			t.Fatal(err)
+		}
+		if IsBinary(gb) {
+			t.Fatalf("%d: gob encoding is detected as binary", i)
+		}
+		if len(b) >= len(gb) {
+			t.Errorf("%d: binary encoding is %d bytes, gob %d", i, len(b),
+				len(gb))
+		}
+		// both the binary and the legacy gob encoding are decoded
+		for _, data := range [][]byte{b, gb} {
+			var dec *event.T
+			if dec, err = Unmarshal(data); err != nil {
+				t.Fatal(err)
+			}
+			if dec.ID != ev.ID || dec.PubKey != ev.PubKey ||
+				dec.Sig != ev.Sig || dec.CreatedAt != ev.CreatedAt ||
+				dec.Kind != ev.Kind || dec.Content != ev.Content ||
+				len(dec.Tags) != len(ev.Tags) {
+				t.Fatalf("%d: expected\n%s\ngot\n%s", i, ev.Serialize(),
+					dec.Serialize())
+			}
+			for j := range ev.Tags {
+				if !dec.Tags[j].Equals(ev.Tags[j]) {
+					t.Errorf("%d: expected tag %v, got %v", i, ev.Tags[j],
+						dec.Tags[j])
+				}
+			}
+			var valid bool
+			if valid, err = dec.CheckSignature(); err != nil || !valid {
+				t.Errorf("%d: decoded event has invalid signature %v", i, err)
+			}
+		}
+		for _, data := range [][]byte{b, gb} {
+			pub, ts, k, pErr := Peek(data)
+			if pErr != nil {
+				t.Fatal(pErr)
+			}
+			if hex.EncodeToString(pub) != ev.PubKey || ts != ev.CreatedAt ||
+				k != ev.Kind {
+				t.Errorf("%d: peeked %x %d %d", i, pub, ts, k)
+			}
+		}
+		// every truncation of the encoding is an error, not a panic
+		for l := 0; l < len(b); l++ {
+			if _, err = Unmarshal(b[:l]); err == nil {
+				t.Fatalf("%d: decoded event truncated to %d of %d bytes", i,
+					l, len(b))
+			}
+		}
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	ev := testEvents(t)[0]
+	for name, tc := range map[string]*event.T{
+		"short id":     {ID: ev.ID[:62], PubKey: ev.PubKey, Sig: ev.Sig},
+		"short pubkey": {ID: ev.ID, PubKey: ev.PubKey[:62], Sig: ev.Sig},
+		"invalid sig": {ID: ev.ID, PubKey: ev.PubKey,
+			Sig: "zz" + ev.Sig[2:]},
+	} {
+		if _, err := Marshal(tc); err == nil {
+			t.Errorf("%s: event was encoded", name)
+		}
+	}
+	b, _ := Marshal(ev)
+	b[0] = 0x80 | (Version + 1)
+	if _, err := Unmarshal(b); err == nil {
+		t.Errorf("unknown version was decoded")
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	events := testEvents(b)
+	b.Run("gob", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, ev := range events {
+				if _, err := MarshalGob(ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, ev := range events {
+				if _, err := Marshal(ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	events := testEvents(b)
+	for _, bm := range []struct {
+		name    string
+		marshal func(*event.T) ([]byte, error)
+	}{
+		{"gob", MarshalGob},
+		{"binary", Marshal},
+	} {
+		encoded := make([][]byte, len(events))
+		var size int
+		for i, ev := range events {
+			var err error
+			if encoded[i], err = bm.marshal(ev); err != nil {
+				b.Fatal(err)
+			}
+			size += len(encoded[i])
+		}
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(size)/float64(len(events)), "bytes/event")
+			for i := 0; i < b.N; i++ {
+				for _, data := range encoded {
+					if _, err := Unmarshal(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
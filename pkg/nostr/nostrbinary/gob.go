@@ -1,5 +1,8 @@
-// Package nostrbinary provides a simple interface for using Gob encoding on
-// nostr events.
+// Package nostrbinary provides a compact binary encoding of nostr events for
+// storage.
+//
+// Events were previously stored with Gob encoding, which is still decoded by
+// Unmarshal so databases can be migrated to the current format.
 package nostrbinary
 
 import (
@@ -25,6 +28,8 @@ var log, chk = slog.New(os.Stderr)
 
 // Event is the most compact and exact form of an event as encoded in native Go
 // form. This will produce the most compact form of Gob encoded binary data.
+//
+// It is only used for the legacy Gob encoding.
 type Event struct {
 	ID        [sha256.Size]byte
 	PubKey    [schnorr.PubKeyBytesLen]byte
@@ -50,7 +55,7 @@ func FromEventT(evt *event.T) (evb *Event, err error) {
 		return
 	}
 	copy(evb.ID[:], evt.ID.Bytes())
-	if len(evt.ID) != schnorr.PubKeyBytesLen*2 {
+	if len(evt.PubKey) != schnorr.PubKeyBytesLen*2 {
 		err = fmt.Errorf("incorrect event pubkey len, got %d expected %d",
 			len(evt.PubKey), schnorr.PubKeyBytesLen*2)
 		return
@@ -95,7 +100,8 @@ func (evb *Event) ToEventT() (evt *event.T) {
 	return
 }
 
-func Unmarshal(data []byte) (evt *event.T, err error) {
+// UnmarshalGob decodes an event in the legacy Gob encoding.
+func UnmarshalGob(data []byte) (evt *event.T, err error) {
 	buf := bytes.NewBuffer(data)
 	dec := gob.NewDecoder(buf)
 	evb := &Event{}
@@ -106,7 +112,8 @@ func Unmarshal(data []byte) (evt *event.T, err error) {
 	return
 }
 
-func Marshal(evt *event.T) (b []byte, err error) {
+// MarshalGob encodes an event in the legacy Gob encoding.
+func MarshalGob(evt *event.T) (b []byte, err error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	var evb *Event
@@ -44,6 +44,8 @@ var (
 	NIP15                          = NostrMarketplace
 	EventTreatment                 = NIP{"EVent Treatment", 16}
 	NIP16                          = EventTreatment
+	PrivateDirectMessages          = NIP{"Private Direct Messages", 17}
+	NIP17                          = PrivateDirectMessages
 	Reposts                        = NIP{"Reposts", 18}
 	NIP18                          = Reposts
 	Bech32EncodedEntities          = NIP{"bech32-encoded entities", 19}
@@ -106,6 +108,8 @@ var (
 	NIP57                          = LightningZaps
 	Badges                         = NIP{"Badges", 58}
 	NIP58                          = Badges
+	GiftWrap                       = NIP{"Gift Wrap", 59}
+	NIP59                          = GiftWrap
 	RelayListMetadata              = NIP{"Relay List Metadata", 65}
 	NIP65                          = RelayListMetadata
 	ModeratedCommunities           = NIP{"Moderated Communities", 72}
@@ -116,6 +120,8 @@ var (
 	NIP78                          = ApplicationSpecificData
 	Highlights                     = NIP{"Highlights", 84}
 	NIP84                          = Highlights
+	RelayManagement                = NIP{"Relay Management API", 86}
+	NIP86                          = RelayManagement
 	RecommendedApplicationHandlers = NIP{"Recommended Application Handlers", 89}
 	NIP89                          = RecommendedApplicationHandlers
 	DataVendingMachines            = NIP{"Data Vending Machines", 90}
@@ -143,6 +149,7 @@ var NIPMap = map[int]NIP{
 	14: NIP14,
 	15: NIP15,
 	16: NIP16,
+	17: NIP17,
 	18: NIP18,
 	19: NIP19,
 	20: NIP20,
@@ -174,11 +181,13 @@ var NIPMap = map[int]NIP{
 	56: NIP56,
 	57: NIP57,
 	58: NIP58,
+	59: NIP59,
 	65: NIP65,
 	72: NIP72,
 	75: NIP75,
 	78: NIP78,
 	84: NIP84,
+	86: NIP86,
 	89: NIP89,
 	90: NIP90,
 	94: NIP94,
@@ -240,6 +249,15 @@ type Limits struct {
 	RestrictedWrites bool        `json:"restricted_writes"`
 	Oldest           timestamp.T `json:"created_at_lower_limit,omitempty"`
 	Newest           timestamp.T `json:"created_at_upper_limit,omitempty"`
+	// MaxEventsPerMinute is the number of events an unauthenticated client can
+	// publish per minute, authenticated users may have higher limits.
+	MaxEventsPerMinute int `json:"max_events_per_minute,omitempty"`
+	// MaxReqsPerMinute is the number of subscriptions or counts an
+	// unauthenticated client can request per minute.
+	MaxReqsPerMinute int `json:"max_reqs_per_minute,omitempty"`
+	// MaxConnectionsPerMinute is the number of connections a client IP address
+	// can open per minute.
+	MaxConnectionsPerMinute int `json:"max_connections_per_minute,omitempty"`
 }
 type Payment struct {
 	Amount int    `json:"amount"`
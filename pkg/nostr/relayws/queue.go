@@ -0,0 +1,196 @@
+package relayws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/labels"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/noticeenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/interfaces/enveloper"
+	"github.com/fasthttp/websocket"
+)
+
+// SlowConsumerPolicy is what is done with a connection whose send queue
+// reaches its high water mark.
+type SlowConsumerPolicy int
+
+const (
+	// DropEvents discards EVENT envelopes while the queue is above the high
+	// water mark, and sends a NOTICE with the number of events dropped once
+	// the queue has drained. Other envelopes are still queued.
+	DropEvents SlowConsumerPolicy = iota
+	// Disconnect closes the connection with a close message explaining why.
+	Disconnect
+)
+
+// SlowConsumerPolicies are the names of the SlowConsumerPolicy values.
+var SlowConsumerPolicies = []string{"drop", "close"}
+
+// ParseSlowConsumerPolicy converts a name in SlowConsumerPolicies to a
+// SlowConsumerPolicy.
+func ParseSlowConsumerPolicy(s string) (p SlowConsumerPolicy, ok bool) {
+	for i, v := range SlowConsumerPolicies {
+		if s == v {
+			return SlowConsumerPolicy(i), true
+		}
+	}
+	return DropEvents, false
+}
+
+// String returns the name of a SlowConsumerPolicy.
+func (p SlowConsumerPolicy) String() string {
+	if int(p) < len(SlowConsumerPolicies) {
+		return SlowConsumerPolicies[p]
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultQueueSize is the number of envelopes a send queue holds if no
+	// size is configured.
+	DefaultQueueSize = 1024
+	// SlowConsumerReason is the reason given when closing a connection that
+	// does not read its messages fast enough.
+	SlowConsumerReason = "slow consumer: messages not read fast enough"
+)
+
+var (
+	// ErrSlowConsumer is returned when an envelope is not queued because the
+	// connection is being closed for not reading its messages fast enough.
+	ErrSlowConsumer = errors.New(SlowConsumerReason)
+	// ErrWriterStopped is returned when writing to a connection whose writer
+	// has stopped.
+	ErrWriterStopped = errors.New("connection writer stopped")
+)
+
+// QueueConfig is the configuration of the send queue of a connection.
+type QueueConfig struct {
+	// Size is the maximum number of envelopes waiting to be sent.
+	Size int
+	// HighWater is the number of envelopes waiting at which the Policy is
+	// applied, it is three quarters of the Size if not set.
+	HighWater int
+	// Policy is what is done when the HighWater is reached.
+	Policy SlowConsumerPolicy
+	// OnSlow, if set, is called with the policy each time it is applied to
+	// the connection.
+	OnSlow func(p SlowConsumerPolicy)
+}
+
+// StartWriter creates the send queue of a connection and starts the goroutine
+// that writes the envelopes in it, so that the producers of messages are not
+// blocked by a slow client. The writer runs until StopWriter is called or a
+// write fails.
+func (ws *WebSocket) StartWriter(cfg QueueConfig) {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultQueueSize
+	}
+	if cfg.HighWater <= 0 || cfg.HighWater > cfg.Size {
+		cfg.HighWater = cfg.Size * 3 / 4
+	}
+	ws.queueCfg = cfg
+	ws.done = make(chan struct{})
+	ws.queue = make(chan enveloper.I, cfg.Size)
+	go ws.writer()
+}
+
+// StopWriter stops the writer of the send queue, envelopes still in the queue
+// are discarded.
+func (ws *WebSocket) StopWriter() {
+	if ws.done == nil {
+		return
+	}
+	ws.stopOnce.Do(func() { close(ws.done) })
+}
+
+// QueueDepth returns the number of envelopes waiting to be sent.
+func (ws *WebSocket) QueueDepth() int { return len(ws.queue) }
+
+// writer writes the envelopes in the send queue to the connection.
+func (ws *WebSocket) writer() {
+	for {
+		select {
+		case <-ws.done:
+			return
+		case env := <-ws.queue:
+			if err := ws.writeEnvelope(env, 1); err != nil {
+				log.D.F("failed to write to %s: %v, closing connection",
+					ws.RealRemote(), err)
+				ws.StopWriter()
+				chk.D(ws.Conn.Close())
+				return
+			}
+			// tell the client how many events it missed once it has caught
+			// up
+			if ws.dropping.Load() &&
+				len(ws.queue) < ws.queueCfg.HighWater/2 {
+
+				ws.dropping.Store(false)
+				n := ws.dropped.Swap(0)
+				chk.D(ws.writeEnvelope(&noticeenvelope.T{Text: fmt.Sprintf(
+					"%d events were dropped because they were not read "+
+						"fast enough", n)}, 1))
+			}
+		}
+	}
+}
+
+// enqueue adds an envelope to the send queue, applying the slow consumer
+// policy if the queue is above its high water mark.
+func (ws *WebSocket) enqueue(env enveloper.I) (err error) {
+	select {
+	case <-ws.done:
+		return ErrWriterStopped
+	default:
+	}
+	if len(ws.queue) >= ws.queueCfg.HighWater {
+		switch ws.queueCfg.Policy {
+		case Disconnect:
+			ws.evict()
+			return ErrSlowConsumer
+		case DropEvents:
+			if env.Label() == labels.EVENT {
+				if !ws.dropping.Swap(true) {
+					log.D.F("dropping events to slow consumer %s %s",
+						ws.RealRemote(), ws.AuthPubKey())
+					if ws.queueCfg.OnSlow != nil {
+						ws.queueCfg.OnSlow(DropEvents)
+					}
+				}
+				ws.dropped.Inc()
+				return
+			}
+		}
+	}
+	select {
+	case ws.queue <- env:
+	default:
+		// the queue is full of envelopes that can't be dropped
+		ws.evict()
+		return ErrSlowConsumer
+	}
+	return
+}
+
+// evict closes the connection of a slow consumer, with a close message giving
+// the reason.
+func (ws *WebSocket) evict() {
+	var first bool
+	ws.stopOnce.Do(func() {
+		close(ws.done)
+		first = true
+	})
+	if !first {
+		return
+	}
+	log.I.F("closing connection of slow consumer %s %s", ws.RealRemote(),
+		ws.AuthPubKey())
+	if ws.queueCfg.OnSlow != nil {
+		ws.queueCfg.OnSlow(Disconnect)
+	}
+	chk.D(ws.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation,
+			SlowConsumerReason), time.Now().Add(time.Second)))
+	chk.D(ws.Conn.Close())
+}
@@ -0,0 +1,125 @@
+package relayws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eoseenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/envelopes/eventenvelope"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/fasthttp/websocket"
+)
+
+// newTestConnection returns the relay side of a websocket connection with its
+// writer started, and the client side.
+func newTestConnection(t *testing.T, cfg QueueConfig) (ws *WebSocket,
+	client *websocket.Conn) {
+
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns <- conn
+		}))
+	t.Cleanup(srv.Close)
+	var err error
+	client, _, err = websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	ws = &WebSocket{Conn: <-conns}
+	ws.StartWriter(cfg)
+	t.Cleanup(ws.StopWriter)
+	return
+}
+
+func TestQueueDropEvents(t *testing.T) {
+	var slow []SlowConsumerPolicy
+	ws, client := newTestConnection(t, QueueConfig{Size: 4, HighWater: 2,
+		OnSlow: func(p SlowConsumerPolicy) { slow = append(slow, p) }})
+	// hold up the writer so the queue fills
+	ws.mutex.Lock()
+	ev := &eventenvelope.T{SubscriptionID: "sub", Event: &event.T{}}
+	for i := 0; i < 10; i++ {
+		if err := ws.WriteEnvelope(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// envelopes other than events are queued above the high water mark
+	if err := ws.WriteEnvelope(&eoseenvelope.T{Sub: "sub"}); err != nil {
+		t.Fatal(err)
+	}
+	ws.mutex.Unlock()
+	// the writer may have taken an event before it blocked, so the events sent
+	// and dropped must add up to those written
+	var sent, dropped int
+	var eose bool
+	for {
+		chk.E(client.SetReadDeadline(time.Now().Add(time.Second)))
+		_, b, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		msg := string(b)
+		if strings.HasPrefix(msg, `["EVENT"`) {
+			sent++
+			continue
+		}
+		if strings.HasPrefix(msg, `["EOSE"`) {
+			eose = true
+			continue
+		}
+		if _, err = fmt.Sscanf(msg, `["NOTICE","%d events were dropped`,
+			&dropped); err != nil {
+			t.Fatalf("unexpected message %s", msg)
+		}
+		break
+	}
+	if !eose || sent < 2 || sent+dropped != 10 {
+		t.Errorf("expected eose and 10 events sent or dropped, got %v %d %d",
+			eose, sent, dropped)
+	}
+	if len(slow) != 1 || slow[0] != DropEvents {
+		t.Errorf("expected one drop, got %v", slow)
+	}
+}
+
+func TestQueueDisconnect(t *testing.T) {
+	ws, client := newTestConnection(t, QueueConfig{Size: 4, HighWater: 2,
+		Policy: Disconnect})
+	ws.mutex.Lock()
+	ev := &eventenvelope.T{SubscriptionID: "sub", Event: &event.T{}}
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		err = ws.WriteEnvelope(ev)
+	}
+	ws.mutex.Unlock()
+	if err != ErrSlowConsumer {
+		t.Fatalf("expected slow consumer error, got %v", err)
+	}
+	if err = ws.WriteEnvelope(ev); err != ErrWriterStopped {
+		t.Fatalf("expected writer stopped error, got %v", err)
+	}
+	for {
+		chk.E(client.SetReadDeadline(time.Now().Add(time.Second)))
+		if _, _, err = client.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if ce, ok := err.(*websocket.CloseError); !ok ||
+		ce.Code != websocket.ClosePolicyViolation ||
+		ce.Text != SlowConsumerReason {
+		t.Fatalf("expected slow consumer close, got %v", err)
+	}
+}
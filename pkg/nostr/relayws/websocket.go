@@ -60,6 +60,19 @@ type WebSocket struct {
 	authPubKey   atomic.String
 	Authed       chan struct{}
 	OffenseCount atomic.Uint32 // when client does dumb stuff, increment this
+	// OnWrite, if set, is called with each envelope written, such as for
+	// collecting metrics.
+	OnWrite func(env enveloper.I)
+	// queue is the send queue of envelopes waiting for the writer, if it has
+	// been started with StartWriter.
+	queue    chan enveloper.I
+	queueCfg QueueConfig
+	done     chan struct{}
+	stopOnce sync.Once
+	// dropping is set while events are being dropped by the DropEvents policy
+	// and dropped counts them.
+	dropping atomic.Bool
+	dropped  atomic.Uint32
 }
 
 func (ws *WebSocket) Pong() (err error) {
@@ -86,13 +99,26 @@ func (ws *WebSocket) WriteTextMessage(b []byte) (err error) {
 	return ws.write(websocket.TextMessage, b)
 }
 
-// WriteEnvelope writes a message with a given websocket type specifier
+// WriteEnvelope writes a message with a given websocket type specifier.
+//
+// If the writer has been started the envelope is added to the send queue, and
+// the slow consumer policy is applied if the queue is above its high water
+// mark.
 func (ws *WebSocket) WriteEnvelope(env enveloper.I) (err error) {
+	if ws.queue == nil {
+		return ws.writeEnvelope(env, 2)
+	}
+	return ws.enqueue(env)
+}
+
+// writeEnvelope writes an envelope to the connection, the location of the
+// caller skip levels up is logged.
+func (ws *WebSocket) writeEnvelope(env enveloper.I, skip int) (err error) {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
 	var file string
 	var line int
-	_, file, line, _ = runtime.Caller(1)
+	_, file, line, _ = runtime.Caller(skip)
 	loc := fmt.Sprintf("%s:%d", file, line)
 	var evkind string
 	var ek kind.T
@@ -100,6 +126,9 @@ func (ws *WebSocket) WriteEnvelope(env enveloper.I) (err error) {
 		ek = env.(*eventenvelope.T).Event.Kind
 		evkind = kind.GetString(ek)
 	}
+	if ws.OnWrite != nil {
+		ws.OnWrite(env)
+	}
 	rawJSON := env.ToArray().Bytes()
 	log.D.F("sending message to %s %s %s %s %s",
 		ws.RealRemote(),
@@ -0,0 +1,212 @@
+// Package search implements the tokenising of the text of events and the
+// parsing and matching of NIP-50 search queries.
+package search
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kinds"
+)
+
+const (
+	// MinWordLen is the minimum number of characters of an indexed word.
+	MinWordLen = 2
+	// MaxWordLen is the maximum number of bytes of an indexed word, longer
+	// words are most likely encoded data.
+	MaxWordLen = 64
+	// LanguageTag is the NIP-32 label tag that carries the language of an
+	// event, in the ISO-639-1 label namespace.
+	LanguageTag = "l"
+	// LanguageNamespace is the NIP-32 label namespace for languages.
+	LanguageNamespace = "ISO-639-1"
+)
+
+// StopWords are common english words that are not indexed.
+var StopWords = map[string]struct{}{
+	"an": {}, "and": {}, "are": {}, "as": {}, "at": {}, "be": {}, "by": {},
+	"for": {}, "from": {}, "in": {}, "is": {}, "it": {}, "of": {}, "on": {},
+	"or": {}, "that": {}, "the": {}, "this": {}, "to": {}, "was": {},
+	"with": {},
+}
+
+// Field is a piece of the text of an event that is indexed and the weight of
+// words found in it when ranking results.
+type Field struct {
+	Text   string
+	Weight int
+}
+
+// TagWeights are the weights of the tags of events that are indexed.
+var TagWeights = map[string]int{
+	"title":   3,
+	"name":    3,
+	"summary": 2,
+	"subject": 2,
+	"t":       2,
+}
+
+// ProfileWeights are the weights of the fields of kind 0 profile metadata that
+// are indexed.
+var ProfileWeights = map[string]int{
+	"name":         3,
+	"display_name": 3,
+	"nip05":        2,
+	"about":        1,
+}
+
+// Fields returns the text of an event that is searchable.
+//
+// Privileged kinds such as direct messages are not searchable, nor is the
+// content of follow lists. The content of profile metadata events is decoded
+// and only the name and description fields are used.
+func Fields(ev *event.T) (fields []Field) {
+	if kinds.IsPrivileged(ev.Kind) {
+		return
+	}
+	switch ev.Kind {
+	case kind.ProfileMetadata:
+		var profile map[string]any
+		if err := json.Unmarshal([]byte(ev.Content), &profile); err != nil {
+			break
+		}
+		for name, weight := range ProfileWeights {
+			if s, ok := profile[name].(string); ok {
+				fields = append(fields, Field{s, weight})
+			}
+		}
+	case kind.FollowList:
+	default:
+		fields = append(fields, Field{ev.Content, 1})
+	}
+	for _, t := range ev.Tags {
+		if len(t) < 2 {
+			continue
+		}
+		if weight, ok := TagWeights[t[0]]; ok {
+			fields = append(fields, Field{t[1], weight})
+		}
+	}
+	return
+}
+
+// Tokenize splits text into lower case words, skipping URLs, nostr: URIs,
+// stop words and words that are too short or too long.
+func Tokenize(text string) (words []string) {
+	for _, field := range strings.Fields(text) {
+		if strings.Contains(field, "://") || strings.HasPrefix(field, "nostr:") {
+			continue
+		}
+		for _, word := range strings.FieldsFunc(strings.ToLower(field),
+			func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			}) {
+			if utf8.RuneCountInString(word) < MinWordLen ||
+				len(word) > MaxWordLen {
+				continue
+			}
+			if _, stop := StopWords[word]; stop {
+				continue
+			}
+			words = append(words, word)
+		}
+	}
+	return
+}
+
+// Terms returns the searchable words of an event, each with the sum of the
+// weights of the fields it is found in.
+func Terms(ev *event.T) (terms map[string]int) {
+	terms = make(map[string]int)
+	for _, field := range Fields(ev) {
+		for _, word := range Tokenize(field.Text) {
+			terms[word] += field.Weight
+		}
+	}
+	return
+}
+
+// Language returns the ISO-639-1 language code of an event, as found in a
+// NIP-32 label, or an empty string if there is none.
+func Language(ev *event.T) (lang string) {
+	for _, t := range ev.Tags {
+		if len(t) >= 3 && t[0] == LanguageTag && t[2] == LanguageNamespace {
+			return strings.ToLower(t[1])
+		}
+	}
+	return
+}
+
+// Query is a parsed NIP-50 search query.
+type Query struct {
+	// Terms are the distinct words all of which must be found in an event.
+	Terms []string
+	// Language restricts results to events labeled with this language.
+	Language string
+	// IncludeSpam disables the filtering of results from spammers.
+	IncludeSpam bool
+}
+
+// Parse splits a NIP-50 search string into its words and key:value
+// extensions. Unknown extensions are ignored.
+func Parse(s string) (q *Query) {
+	q = &Query{}
+	var text []string
+	for _, field := range strings.Fields(s) {
+		key, value, found := strings.Cut(field, ":")
+		if !found || value == "" || strings.Contains(value, "//") {
+			text = append(text, field)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "language":
+			q.Language = strings.ToLower(value)
+		case "include":
+			if strings.ToLower(value) == "spam" {
+				q.IncludeSpam = true
+			}
+		case "domain", "sentiment", "nsfw":
+			// not supported, but not search terms either
+		default:
+			text = append(text, field)
+		}
+	}
+	seen := make(map[string]struct{})
+	for _, word := range Tokenize(strings.Join(text, " ")) {
+		if _, ok := seen[word]; ok {
+			continue
+		}
+		seen[word] = struct{}{}
+		q.Terms = append(q.Terms, word)
+	}
+	return
+}
+
+// Score returns the relevance of an event to the query, which is the sum of
+// the weights of the query terms in the event, or zero if the event does not
+// contain all of the terms or is not in the requested language.
+func (q *Query) Score(ev *event.T) (score int) {
+	if len(q.Terms) == 0 {
+		return
+	}
+	if q.Language != "" && Language(ev) != q.Language {
+		return
+	}
+	terms := Terms(ev)
+	for _, term := range q.Terms {
+		weight, ok := terms[term]
+		if !ok {
+			return 0
+		}
+		score += weight
+	}
+	return
+}
+
+// Matches returns true if the event contains all of the terms of the query
+// and is in the requested language.
+func (q *Query) Matches(ev *event.T) bool { return q.Score(ev) > 0 }
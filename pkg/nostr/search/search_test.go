@@ -0,0 +1,58 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/event"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/kind"
+	"github.com/Hubmakerlabs/replicatr/pkg/nostr/tags"
+)
+
+func TestTokenize(t *testing.T) {
+	words := Tokenize("The Nostr relay, at https://example.com/x " +
+		"nostr:npub1abc is GREAT! a ünïcode-test")
+	expected := []string{"nostr", "relay", "great", "ünïcode", "test"}
+	if !reflect.DeepEqual(words, expected) {
+		t.Fatalf("expected %v, got %v", expected, words)
+	}
+}
+
+func TestParse(t *testing.T) {
+	q := Parse("Best nostr apps language:EN include:spam domain:x.com " +
+		"nostr best")
+	if !reflect.DeepEqual(q.Terms, []string{"best", "nostr", "apps"}) {
+		t.Fatalf("unexpected terms %v", q.Terms)
+	}
+	if q.Language != "en" || !q.IncludeSpam {
+		t.Fatalf("unexpected extensions %+v", q)
+	}
+}
+
+func TestScore(t *testing.T) {
+	note := &event.T{Kind: kind.TextNote, Content: "nostr relays are fun",
+		Tags: tags.T{{"t", "nostr"}, {"l", "en", LanguageNamespace}}}
+	profile := &event.T{Kind: kind.ProfileMetadata,
+		Content: `{"name":"relay operator","about":"runs nostr relays"}`}
+	dm := &event.T{Kind: kind.EncryptedDirectMessage, Content: "nostr"}
+	follows := &event.T{Kind: kind.FollowList, Content: "nostr"}
+	for _, test := range []struct {
+		query string
+		ev    *event.T
+		score int
+	}{
+		{"nostr", note, 3},
+		{"nostr fun", note, 4},
+		{"nostr missing", note, 0},
+		{"nostr language:en", note, 3},
+		{"nostr language:de", note, 0},
+		{"operator relays", profile, 4},
+		{"nostr", dm, 0},
+		{"nostr", follows, 0},
+	} {
+		if score := Parse(test.query).Score(test.ev); score != test.score {
+			t.Errorf("query '%s' expected score %d, got %d", test.query,
+				test.score, score)
+		}
+	}
+}
@@ -62,6 +62,9 @@ func (tp *Tp) String() string {
 }
 
 func (tp *Tp) Clone() (tc *Tp) {
+	if tp == nil {
+		return
+	}
 	cp := *tp
 	return &cp
 }
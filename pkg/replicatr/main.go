@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/Hubmakerlabs/replicatr/app"
+	"github.com/Hubmakerlabs/replicatr/app/policy"
 	"github.com/Hubmakerlabs/replicatr/pkg/apputil"
 	"github.com/Hubmakerlabs/replicatr/pkg/config/base"
 	"github.com/Hubmakerlabs/replicatr/pkg/ic/agent"
@@ -57,6 +58,7 @@ var nips = number.List{
 	relayinfo.GenericTagQueries.Number,              // NIP12 generic tag queries
 	relayinfo.NostrMarketplace.Number,               // NIP15 marketplace
 	relayinfo.EventTreatment.Number,                 // NIP16
+	relayinfo.PrivateDirectMessages.Number,          // NIP17 private DMs to the relay chat
 	relayinfo.Reposts.Number,                        // NIP18 reposts
 	relayinfo.Bech32EncodedEntities.Number,          // NIP19 bech32 encodings
 	relayinfo.CommandResults.Number,                 // NIP20
@@ -66,9 +68,13 @@ var nips = number.List{
 	relayinfo.ParameterizedReplaceableEvents.Number, // NIP33
 	relayinfo.ExpirationTimestamp.Number,            // NIP40
 	relayinfo.VersionedEncryption.Number,
-	relayinfo.UserStatuses.Number,    // NIP38 user statuses
-	relayinfo.Authentication.Number,  // NIP42 auth
-	relayinfo.CountingResults.Number, // NIP45 count requests
+	relayinfo.UserStatuses.Number,     // NIP38 user statuses
+	relayinfo.Authentication.Number,   // NIP42 auth
+	relayinfo.CountingResults.Number,  // NIP45 count requests
+	relayinfo.SearchCapability.Number, // NIP50 search
+	relayinfo.GiftWrap.Number,         // NIP59 gift wrap
+	relayinfo.RelayManagement.Number,  // NIP86 relay management API
+	relayinfo.HTTPAuth.Number,         // NIP98 HTTP auth
 }
 
 var log, chk = slog.New(os.Stderr)
@@ -181,95 +187,23 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		}
 		os.Exit(0)
 	} else {
+		// fields missing from the file have their default values
+		conf = *base.GetDefaultConfig()
 		if err = conf.Load(configPath); chk.E(err) {
 			log.D.F("failed to load relay configuration: '%s'", err)
 			os.Exit(1)
 		}
 		log.I.Ln("loaded configuration from", configPath)
-		// if fields are empty, overwrite them with the cli args file
-		// versions
-		if len(args.Listen) > 0 {
-			conf.Listen = args.Listen
-		}
-		if args.Profile != "" {
-			conf.Profile = args.Profile
-		}
-		if args.Name != "" {
-			conf.Name = args.Name
-		}
-		if args.Description != "" {
-			conf.Description = args.Description
-		}
-		if args.Pubkey != "" {
-			conf.Pubkey = args.Pubkey
-		}
-		if args.Contact != "" {
-			conf.Contact = args.Contact
-		}
-		if args.Icon == "" {
-			conf.Icon = args.Icon
-		}
-		// CLI args on "separate" items add to the ones in the config
-		if len(args.Whitelist) == 0 {
-			conf.Whitelist = append(conf.Whitelist, args.Whitelist...)
-		}
-		if len(args.Owners) == 0 {
-			conf.Owners = append(conf.Owners, args.Owners...)
-		}
-		if args.SecKey != "" {
-			conf.SecKey = args.SecKey
-		}
-		if args.DBSizeLimit != 0 {
-			conf.DBSizeLimit = args.DBSizeLimit
-		}
-		if args.DBLowWater != 0 {
-			conf.DBLowWater = args.DBLowWater
-		}
-		if args.DBHighWater != 0 {
-			conf.DBHighWater = args.DBHighWater
-		}
-		if args.GCFrequency != 0 {
-			conf.GCFrequency = args.GCFrequency
-		}
-		if args.Pubkey != "" {
-			conf.Pubkey = args.Pubkey
-		}
-		if args.Whitelist != nil {
-			conf.Whitelist = args.Whitelist
-		}
-		if args.CanisterAddr != "" {
-			conf.CanisterAddr = args.CanisterAddr
-		}
-		if args.CanisterId != "" {
-			conf.CanisterId = args.CanisterId
-		}
+		// values given on the command line take precedence over the file
+		conf.Override(&args)
 		if err = inf.Load(infoPath); chk.E(err) {
 			inf = GetInfo(&conf)
 			log.D.F("failed to load relay information document: '%s' "+
 				"deriving from config", err)
 		}
 		if args.AuthRequired {
-			conf.AuthRequired = true
 			inf.Limitation.AuthRequired = true
 		}
-		if args.EventStore != "" {
-			conf.EventStore = args.EventStore
-		}
-		if args.MemLimit > 0 {
-			conf.MemLimit = args.MemLimit
-		}
-		if args.GCRatio > 0 {
-			conf.GCRatio = args.GCRatio
-		}
-		if args.MaxProcs > 0 {
-			conf.MaxProcs = args.MaxProcs
-		}
-		if args.PollFrequency > 0 {
-			conf.PollFrequency = args.PollFrequency
-		}
-		if args.PollOverlap > 0 {
-			conf.PollOverlap = args.PollOverlap
-		}
 	}
 	log.I.Ln(conf.SecKey)
 	_ = debug.SetGCPercent(conf.GCRatio)
@@ -279,11 +213,21 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		debug.SetMemoryLimit(conf.MemLimit)
 	}
 	rl := app.NewRelay(c, cancel, inf, &conf)
+	rl.ConfigPath, rl.InfoPath = configPath, infoPath
+	rl.Args = &args
+	// restore the bans from previous runs
+	if err = rl.Spam.Load(filepath.Join(dataDir, "spam.json")); chk.E(err) {
+		log.E.F("unable to load banned addresses: '%s'", err)
+	}
+	if err = rl.BannedEvents.Load(filepath.Join(dataDir,
+		"bannedevents.json")); chk.E(err) {
+		log.E.F("unable to load banned events: '%s'", err)
+	}
 	var db eventstore.Store
 	// if we are wiping we don't want to init db normally
 	switch {
 	case args.PubKeyCmd != nil:
-		secKeyBytes, err := hex.Dec(rl.Config.SecKey)
+		secKeyBytes, err := hex.Dec(rl.Config().SecKey)
 		if err != nil {
 			log.E.F("Error decoding SecKey: %s\n", err)
 			return
@@ -300,8 +244,8 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		fmt.Println(publicKeyBase64)
 		os.Exit(0)
 	case args.AddRelayCmd != nil:
-		a, err := agent.New(c, rl.Config.CanisterId, rl.Config.CanisterAddr,
-			rl.Config.SecKey)
+		a, err := agent.New(c, rl.Config().CanisterId, rl.Config().CanisterAddr,
+			rl.Config().SecKey)
 		if err != nil {
 			log.E.F("Error creating agent: %s\n", err)
 			os.Exit(1)
@@ -319,8 +263,8 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 			perm)
 		os.Exit(0)
 	case args.RemoveRelayCmd != nil:
-		a, err := agent.New(c, rl.Config.CanisterId, rl.Config.CanisterAddr,
-			rl.Config.SecKey)
+		a, err := agent.New(c, rl.Config().CanisterId, rl.Config().CanisterAddr,
+			rl.Config().SecKey)
 		if err != nil {
 			log.E.F("Error creating agent: %s\n", err)
 			os.Exit(1)
@@ -333,8 +277,8 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		log.I.F("User %s removed\n", args.RemoveRelayCmd.PubKey)
 		os.Exit(0)
 	case args.GetPermissionCmd != nil:
-		a, err := agent.New(c, rl.Config.CanisterId, rl.Config.CanisterAddr,
-			rl.Config.SecKey)
+		a, err := agent.New(c, rl.Config().CanisterId, rl.Config().CanisterAddr,
+			rl.Config().SecKey)
 		if err != nil {
 			log.E.F("Error creating agent: %s\n", err)
 			os.Exit(1)
@@ -353,29 +297,31 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 	// create both structures in any case
 	var badgerDB *badger.Backend
 	var icDB *IConly.Backend
-	eso := rl.Config.EventStore
+	eso := rl.Config().EventStore
 	if eso == "ic" || eso == "iconly" {
 		icDB = &IConly.Backend{
 			Ctx:             c,
 			WG:              &wg,
-			CanisterAddr:    rl.Config.CanisterAddr,
-			CanisterId:      rl.Config.CanisterId,
+			CanisterAddr:    rl.Config().CanisterAddr,
+			CanisterId:      rl.Config().CanisterId,
 			PrivateCanister: false, // for future implementation
-			SecKey:          rl.Config.SecKey,
+			SecKey:          rl.Config().SecKey,
 		}
 	}
 	if eso == "ic" || eso == "badger" || eso == "badgerbadger" {
 		badgerDB = &badger.Backend{
-			Ctx:            c,
-			WG:             &wg,
-			Path:           dataDir,
-			MaxLimit:       inf.Limitation.MaxLimit,
-			DBSizeLimit:    conf.DBSizeLimit,
-			DBLowWater:     conf.DBLowWater,
-			DBHighWater:    conf.DBHighWater,
-			GCFrequency:    time.Duration(conf.GCFrequency) * time.Second,
-			BlockCacheSize: 8 * units.Gb,
-			InitLogLevel:   slog.Off,
+			Ctx:                   c,
+			WG:                    &wg,
+			Path:                  dataDir,
+			MaxLimit:              inf.Limitation.MaxLimit,
+			DBSizeLimit:           conf.DBSizeLimit,
+			DBLowWater:            conf.DBLowWater,
+			DBHighWater:           conf.DBHighWater,
+			GCFrequency:           time.Duration(conf.GCFrequency) * time.Second,
+			ApproximateCountAbove: conf.ApproximateCount,
+			Retention:             rl.NewRetention(&conf),
+			BlockCacheSize:        8 * units.Gb,
+			InitLogLevel:          slog.Off,
 			// InitLogLevel:   slog.GetLogLevel(),
 		}
 	}
@@ -404,6 +350,7 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 			}()
 		}
 		interrupt.AddHandler(func() {
+			chk.E(badgerDB.FlushAccesses())
 			badgerDB.DB.Flatten(8)
 			badgerDB.DB.Close()
 			// wg.Done()
@@ -412,6 +359,7 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		db = badgerDB
 		wg.Add(1)
 		interrupt.AddHandler(func() {
+			chk.E(badgerDB.FlushAccesses())
 			badgerDB.DB.Flatten(8)
 			badgerDB.DB.Close()
 			// wg.Done()
@@ -425,13 +373,24 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		b2.InitLogLevel = badgerDB.InitLogLevel
 		db = badgerbadger.GetBackend(c, &wg, badgerDB, b2)
 		interrupt.AddHandler(func() {
+			chk.E(badgerDB.FlushAccesses())
 			badgerDB.DB.Flatten(8)
 			badgerDB.DB.Close()
+			chk.E(b2.FlushAccesses())
 			b2.DB.Flatten(8)
 			b2.DB.Close()
 			// wg.Done()
 		})
 	}
+	if args.GC != nil {
+		if badgerDB == nil {
+			log.E.F("the gc subcommand needs a badger event store, not '%s'",
+				eso)
+			os.Exit(1)
+		}
+		// the report must not race with a garbage collector run
+		badgerDB.ManualGC = true
+	}
 	if err = db.Init(); chk.E(err) {
 		log.E.F("unable to start database: '%s'", err)
 		os.Exit(1)
@@ -454,25 +413,74 @@ func Main(osArgs []string, c context.T, cancel context.F) {
 		rl.Import(db, args.ImportCmd.FromFile, &wg, args.ImportCmd.StartingFrom)
 		cancel()
 		os.Exit(0)
+	case args.GC != nil:
+		// the events of members of the pinned roles are kept, so the stored
+		// ACL events are replayed on top of the owners from the configuration
+		rl.QueryEvents = append(rl.QueryEvents, db.QueryEvents)
+		if err = rl.LoadACL(c); chk.E(err) {
+			log.E.F("unable to load ACL from event store: '%s'", err)
+			cancel()
+			os.Exit(1)
+		}
+		if err = rl.GC(badgerDB, args.GC, os.Stdout); chk.E(err) {
+			cancel()
+			os.Exit(1)
+		}
+		cancel()
+		os.Exit(0)
 	case args.ExportCmd != nil:
 		rl.Export(badgerDB, args.ExportCmd.ToFile, &wg)
 		cancel()
 		os.Exit(0)
 	}
+	rl.Badger = badgerDB
+	if conf.Metrics {
+		rl.EnableMetrics(db)
+	}
 	rl.StoreEvent = append(rl.StoreEvent, rl.Chat)
 	rl.StoreEvent = append(rl.StoreEvent, db.SaveEvent)
 	rl.QueryEvents = append(rl.QueryEvents, db.QueryEvents)
-	rl.CountEvents = append(rl.CountEvents, db.CountEvents)
+	if ac, ok := db.(eventstore.ApproximateCounter); ok {
+		rl.CountEvents = append(rl.CountEvents, ac.CountEventsApproximate)
+	} else {
+		rl.CountEvents = append(rl.CountEvents, app.ExactCount(db.CountEvents))
+	}
 	rl.DeleteEvent = append(rl.DeleteEvent, db.DeleteEvent)
 	rl.OnConnect = append(rl.OnConnect, rl.AuthCheck)
-	rl.RejectFilter = append(rl.RejectFilter, app.NoSearchQueries)
+	if len(conf.EphemeralRateLimits) > 0 {
+		rl.RejectEvent = append(rl.RejectEvent,
+			app.LimitEphemeralEvents(conf.EphemeralRateLimits))
+	}
+	rl.RejectEvent = append(rl.RejectEvent, rl.RestrictKinds)
+	rl.RejectEvent = append(rl.RejectEvent, rl.RequirePoW)
 	rl.RejectFilter = append(rl.RejectFilter, app.NoComplexFilters)
 	rl.RejectFilter = append(rl.RejectFilter, app.NoEmptyFilters)
 	rl.RejectFilter = append(rl.RejectFilter, rl.FilterPrivileged)
 	rl.RejectCountFilter = append(rl.RejectCountFilter, rl.FilterPrivileged)
-	rl.OverrideDeletion = append(rl.OverrideDeletion, rl.OverrideDelete)
+	if conf.WritePolicy != "" {
+		// the policy plugin is asked last, about what the relay would accept
+		plugin := policy.New(conf.WritePolicy)
+		go plugin.Run(c)
+		rl.RejectEvent = append(rl.RejectEvent, rl.PolicyEvent(plugin))
+		rl.RejectFilter = append(rl.RejectFilter, rl.PolicyFilter(plugin))
+		rl.RejectCountFilter = append(rl.RejectCountFilter,
+			rl.PolicyFilter(plugin))
+	}
+	if badgerDB != nil {
+		rl.TombstoneAddress = append(rl.TombstoneAddress,
+			badgerDB.TombstoneAddress)
+		rl.TombstoneEventID = append(rl.TombstoneEventID,
+			badgerDB.TombstoneEventID)
+	}
 	// run the chat ACL initialization
 	rl.Init()
+	// replay the stored ACL events on top of the owners from the configuration
+	if err = rl.LoadACL(c); chk.E(err) {
+		log.E.F("unable to load ACL from event store: '%s'", err)
+	}
+	// apply changes to the configuration files without restarting
+	interrupt.AddReloadHandler(func() { chk.E(rl.Reload()) })
+	go rl.WatchConfig(app.ReloadCheckInterval)
 	var servs []http.Server
 	for i := range conf.Listen {
 		serv := http.Server{